@@ -13,11 +13,25 @@ import (
 	"github.com/gin-gonic/gin"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/apikeys"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/commands"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/database"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/handlers"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/hibernator"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/middleware"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/outbox"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/previewlinks"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/provisioner"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/reaper"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/reconciler"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/worker"
 	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
 	"github.com/villageFlower/paypilot_dev_session_service/pkg/logger"
 	"go.uber.org/zap"
@@ -39,6 +53,11 @@ import (
 
 // @host localhost:8080
 // @BasePath /api/v1
+
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description JWT as "Bearer <token>"
 func main() {
 	// Load configuration
 	cfg, err := config.Load("")
@@ -60,18 +79,26 @@ func main() {
 	}
 	defer database.Close()
 
-	// Run migrations
-	if err := database.AutoMigrate(logger.Log); err != nil {
-		logger.Log.Fatal("Failed to run migrations", zap.Error(err))
+	// The migrate subcommand manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			logger.Log.Fatal("Migration failed", zap.Error(err))
+		}
+		return
+	}
+
+	// Apply or verify schema migrations
+	if err := database.Migrate(context.Background(), cfg.Database.Migrations, logger.Log); err != nil {
+		logger.Log.Fatal("Failed to prepare database schema", zap.Error(err))
 	}
 
-	// Initialize RabbitMQ
-	rmq, err := messaging.NewRabbitMQ(&cfg.RabbitMQ, logger.Log)
+	// Initialize the message broker
+	broker, err := newBroker(&cfg.RabbitMQ)
 	if err != nil {
-		logger.Log.Warn("Failed to initialize RabbitMQ (service will continue without messaging)", zap.Error(err))
-		rmq = nil
+		logger.Log.Warn("Failed to initialize message broker (service will continue without messaging)", zap.Error(err))
+		broker = nil
 	} else {
-		defer rmq.Close()
+		defer broker.Close()
 	}
 
 	// Set Gin mode
@@ -85,16 +112,87 @@ func main() {
 	router.Use(middleware.Recovery(logger.Log))
 	router.Use(middleware.CORS())
 
-	// Initialize Kubernetes client
-	k8sClient, err := kubernetes.NewClient(logger.Log, "./helm/dev-session-template")
+	// Initialize dev container runtime
+	runtime, err := newRuntime(&cfg.Kubernetes)
 	if err != nil {
 		logger.Log.Warn("Failed to initialize Kubernetes client (service will continue without K8s integration)", zap.Error(err))
-		k8sClient = nil
+		runtime = nil
+	}
+
+	// Lifecycle events, dead-letter administration and the broker health check
+	// need a broker, which is only missing when misconfigured. Events are only
+	// recorded when the outbox relay can publish them.
+	var emitter *events.Emitter
+	var relay *outbox.Relay
+	var deadLetters messaging.DeadLetterStore
+	var brokerState messaging.ConnectionState
+	if broker != nil {
+		emitter = events.NewEmitter(logger.Log)
+		relay = outbox.New(logger.Log, repository.NewPostgresOutboxRepository(database.DB), broker, &cfg.Outbox)
+		deadLetters = broker
+		brokerState = broker
+	}
+
+	// Session storage, shared by the background workers, the HTTP API and the command consumer
+	sessionRepo := repository.NewPostgresSessionRepository(database.DB, emitter)
+
+	// Start provisioning workers
+	provisionPool := worker.NewPool(logger.Log, cfg.Provisioner.Workers, cfg.Provisioner.QueueSize)
+	provisionPool.Start()
+	prov := provisioner.New(logger.Log, sessionRepo, runtime, provisionPool)
+
+	// Reconciliation and hibernation need a cluster to act on
+	var rec *reconciler.Reconciler
+	var hib *hibernator.Hibernator
+	if runtime != nil {
+		rec = reconciler.New(logger.Log, sessionRepo, runtime, prov, &cfg.Reconciler)
+		hib = hibernator.New(logger.Log, sessionRepo, runtime, prov, &cfg.Hibernation)
+	}
+
+	// Expired sessions are reaped with or without a cluster
+	sessionReaper := reaper.New(logger.Log, sessionRepo, runtime, &cfg.Reaper)
+
+	// The operations shared by the HTTP API and the command consumer
+	sessionService := sessions.NewService(logger.Log, sessionRepo, runtime, prov, sessionReaper)
+
+	// API keys of services calling the API
+	apiKeyService := apikeys.NewService(logger.Log, repository.NewPostgresAPIKeyRepository(database.DB))
+
+	// Shareable preview links need a signing secret
+	previewLinkService, err := previewlinks.NewService(logger.Log,
+		repository.NewPostgresPreviewLinkRepository(database.DB), sessionRepo, &cfg.PreviewLinks)
+	if err != nil {
+		logger.Log.Warn("Preview links are disabled", zap.Error(err))
+		previewLinkService = nil
 	}
 
 	// Initialize handlers
-	healthHandler := handlers.NewHealthHandler()
-	sessionHandler := handlers.NewSessionHandler(logger.Log, k8sClient)
+	healthHandler := handlers.NewHealthHandler(brokerState)
+	sessionHandler := handlers.NewSessionHandler(logger.Log, sessionRepo, sessionService, hib)
+	reconcileHandler := handlers.NewReconcileHandler(logger.Log, rec)
+	deadLetterHandler := handlers.NewDeadLetterHandler(logger.Log, deadLetters)
+	proxyHandler := handlers.NewProxyHandler(logger.Log, sessionRepo, runtime, hib, previewLinkService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(logger.Log, apiKeyService)
+	previewLinkHandler := handlers.NewPreviewLinkHandler(logger.Log, sessionService, previewLinkService)
+	forwardAuthHandler := handlers.NewForwardAuthHandler(logger.Log, sessionRepo, hib, previewLinkService,
+		cfg.Kubernetes.Ingress.Auth.CookieName, cfg.Kubernetes.Ingress.Auth.CookieSecure)
+
+	// Session, project and admin routes require a JWT or API key with the
+	// route's scope when authentication is enabled
+	var authenticate []gin.HandlerFunc
+	requireScope := func(string) gin.HandlerFunc { return func(*gin.Context) {} }
+	if cfg.Auth.Enabled {
+		validator, err := auth.NewValidator(context.Background(), logger.Log, &cfg.Auth)
+		if err != nil {
+			logger.Log.Fatal("Failed to initialize authentication", zap.Error(err))
+		}
+		authenticate = []gin.HandlerFunc{middleware.Auth(logger.Log, validator, apiKeyService)}
+		requireScope = middleware.RequireScope
+	} else {
+		logger.Log.Warn("Authentication is disabled, every caller can see and change all sessions")
+	}
+	read := requireScope(models.ScopeSessionsRead)
+	write := requireScope(models.ScopeSessionsWrite)
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
@@ -102,17 +200,53 @@ func main() {
 		// Health check
 		v1.GET("/health", healthHandler.Check)
 
+		// Forward-auth for session Ingresses, authenticated by session token or preview link
+		v1.GET("/auth/verify", forwardAuthHandler.Verify)
+
 		// Dev Session routes
-		sessions := v1.Group("/sessions")
+		sessions := v1.Group("/sessions", authenticate...)
+		{
+			sessions.POST("", write, sessionHandler.CreateSession)
+			sessions.GET("", read, sessionHandler.ListSessions)
+			sessions.GET("/:id", read, sessionHandler.GetSession)
+			sessions.GET("/:id/history", read, sessionHandler.GetSessionHistory)
+			sessions.GET("/project/:project_uuid", write, sessionHandler.GetOrCreateSessionByProjectUUID)
+			sessions.DELETE("/:id", write, sessionHandler.DeleteSession)
+			sessions.POST("/:id/stop", write, sessionHandler.StopSession)
+			sessions.POST("/:id/start", write, sessionHandler.StartSession)
+			sessions.POST("/:id/restart", write, sessionHandler.RestartSession)
+			sessions.POST("/:id/token/rotate", write, sessionHandler.RotateSessionToken)
+			sessions.GET("/:id/open/:endpoint", read, sessionHandler.OpenEndpoint)
+			sessions.POST("/:id/preview-links", write, previewLinkHandler.CreatePreviewLink)
+			sessions.GET("/:id/preview-links", read, previewLinkHandler.ListPreviewLinks)
+			sessions.DELETE("/:id/preview-links/:link_id", write, previewLinkHandler.RevokePreviewLink)
+		}
+
+		// Project routes
+		projects := v1.Group("/projects", authenticate...)
 		{
-			sessions.POST("", sessionHandler.CreateSession)
-			sessions.GET("", sessionHandler.ListSessions)
-			sessions.GET("/:id", sessionHandler.GetSession)
-			sessions.GET("/project/:project_uuid", sessionHandler.GetOrCreateSessionByProjectUUID)
-			sessions.DELETE("/:id", sessionHandler.DeleteSession)
+			projects.GET("/:project_uuid/sessions", read, sessionHandler.ListProjectSessions)
+		}
+
+		// Admin routes
+		admin := v1.Group("/admin", authenticate...)
+		admin.Use(requireScope(models.ScopeAdmin))
+		{
+			admin.POST("/reconcile", reconcileHandler.Reconcile)
+			admin.POST("/sessions/:id/restore", sessionHandler.RestoreSession)
+			admin.GET("/dead-letters", deadLetterHandler.ListDeadLetters)
+			admin.POST("/dead-letters/replay", deadLetterHandler.ReplayDeadLetters)
+			admin.DELETE("/dead-letters", deadLetterHandler.PurgeDeadLetters)
+			admin.POST("/api-keys", apiKeyHandler.IssueAPIKey)
+			admin.GET("/api-keys", apiKeyHandler.ListAPIKeys)
+			admin.POST("/api-keys/:id/rotate", apiKeyHandler.RotateAPIKey)
+			admin.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
 		}
 	}
 
+	// Dev container endpoints, authenticated by session token or preview link
+	router.Any("/s/:token/:endpoint/*path", proxyHandler.Proxy)
+
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -131,22 +265,40 @@ func main() {
 		}
 	}()
 
-	// Start message consumer if RabbitMQ is available
-	if rmq != nil {
+	// Start the session command consumer if a broker is available
+	if broker != nil {
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
+		ledger := commands.NewDBLedger(logger.Log, database.DB, &cfg.RabbitMQ.Ledger)
+		go ledger.Run(ctx)
+
+		commandConsumer := commands.NewConsumer(logger.Log, sessionService, broker, ledger)
 		go func() {
-			err := rmq.Consume(ctx, func(body []byte) error {
-				logger.Log.Info("Received message", zap.String("body", string(body)))
-				return nil
-			})
+			err := broker.Consume(ctx, commandConsumer.Handle)
 			if err != nil {
 				logger.Log.Error("Consumer stopped", zap.Error(err))
 			}
 		}()
 	}
 
+	// Start reconciliation, expiry, hibernation and event relay loops
+	loopCtx, stopLoops := context.WithCancel(context.Background())
+	defer stopLoops()
+
+	if rec != nil && cfg.Reconciler.Enabled {
+		go rec.Run(loopCtx)
+	}
+	if cfg.Reaper.Enabled {
+		go sessionReaper.Run(loopCtx)
+	}
+	if hib != nil && cfg.Hibernation.Enabled {
+		go hib.Run(loopCtx)
+	}
+	if relay != nil {
+		go relay.Run(loopCtx)
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -162,5 +314,64 @@ func main() {
 		logger.Log.Fatal("Server forced to shutdown", zap.Error(err))
 	}
 
+	stopLoops()
+
+	// Let in-flight provisioning finish so sessions are not left pending
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), time.Duration(cfg.Provisioner.ShutdownTimeout)*time.Second)
+	defer drainCancel()
+
+	if err := provisionPool.Shutdown(drainCtx); err != nil {
+		logger.Log.Warn("Provisioning workers did not drain in time", zap.Error(err))
+	}
+
 	logger.Log.Info("Server exited")
 }
+
+// newRuntime builds the dev container runtime selected in the configuration
+func newRuntime(cfg *config.KubernetesConfig) (kubernetes.Runtime, error) {
+	switch cfg.Runtime {
+	case "fake":
+		logger.Log.Info("Using in-memory dev container runtime")
+		rt := kubernetes.NewFakeRuntime()
+		rt.Ingress = ingressOptions(&cfg.Ingress)
+		return rt, nil
+	case "helm", "":
+		return kubernetes.NewClient(logger.Log, cfg.ChartPath,
+			kubernetes.WithKubeconfig(cfg.Kubeconfig),
+			kubernetes.WithTimeout(time.Duration(cfg.Timeout)*time.Second),
+			kubernetes.WithIngress(ingressOptions(&cfg.Ingress)))
+	default:
+		return nil, fmt.Errorf("unknown kubernetes runtime: %s", cfg.Runtime)
+	}
+}
+
+// newBroker builds the message broker selected in the configuration
+func newBroker(cfg *config.RabbitMQConfig) (messaging.Broker, error) {
+	switch cfg.Broker {
+	case "memory":
+		logger.Log.Info("Using in-memory message bus")
+		return messaging.NewMemoryBus(cfg, logger.Log), nil
+	case "rabbitmq", "":
+		// Connects in the background if RabbitMQ is not up yet
+		return messaging.NewRabbitMQ(cfg, logger.Log), nil
+	default:
+		return nil, fmt.Errorf("unknown message broker: %s", cfg.Broker)
+	}
+}
+
+// ingressOptions maps the Ingress configuration onto the runtime's options
+func ingressOptions(cfg *config.IngressConfig) kubernetes.IngressOptions {
+	authURL := ""
+	if cfg.Auth.Enabled {
+		authURL = cfg.Auth.URL
+	}
+	return kubernetes.IngressOptions{
+		Enabled:       cfg.Enabled,
+		ClassName:     cfg.ClassName,
+		HostTemplate:  cfg.HostTemplate,
+		TLS:           cfg.TLS.Enabled,
+		TLSSecretName: cfg.TLS.SecretName,
+		ClusterIssuer: cfg.TLS.ClusterIssuer,
+		AuthURL:       authURL,
+	}
+}
@@ -0,0 +1,234 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	// prefixBytes is the length of the random public part of a key
+	prefixBytes = 8
+	// secretBytes is the length of the random secret part of a key
+	secretBytes = 32
+	// touchResolution limits how often last_used_at is written for a busy key
+	touchResolution = time.Minute
+)
+
+// Errors returned by Service
+var (
+	// ErrNotFound is returned when no API key matches
+	ErrNotFound = repository.ErrAPIKeyNotFound
+	// ErrInvalidKey is returned for keys that are malformed, unknown, revoked or expired
+	ErrInvalidKey = errors.New("invalid api key")
+	// ErrRevoked is returned when rotating or revoking a revoked key
+	ErrRevoked = errors.New("api key is revoked")
+	// ErrInvalidRequest is returned for a key request without a name or with unknown scopes
+	ErrInvalidRequest = errors.New("invalid api key request")
+)
+
+// Service issues and manages API keys and identifies the services calling
+// with them. Keys look like ppk_<prefix>_<secret>; only the prefix and a
+// SHA-256 hash of the whole key are stored.
+type Service struct {
+	log  *zap.Logger
+	repo repository.APIKeyRepository
+}
+
+// NewService creates an API key service storing keys in repo
+func NewService(log *zap.Logger, repo repository.APIKeyRepository) *Service {
+	return &Service{log: log, repo: repo}
+}
+
+// Issue creates an API key and returns it with the plaintext key, which is
+// not stored and cannot be shown again
+func (s *Service) Issue(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (*models.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", ErrInvalidRequest)
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidRequest)
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidRequest, scope)
+		}
+	}
+
+	plaintext, prefix, hash, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &models.APIKey{
+		Name:      name,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedBy: actorFrom(ctx),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	s.log.Info("API key issued",
+		zap.Uint("api_key_id", key.ID),
+		zap.String("name", key.Name),
+		zap.Strings("scopes", key.Scopes),
+		zap.String("actor", key.CreatedBy))
+
+	return key, plaintext, nil
+}
+
+// List returns a page of API keys, newest first, and the total number of keys
+func (s *Service) List(ctx context.Context, page repository.Page) ([]models.APIKey, int64, error) {
+	return s.repo.List(ctx, page)
+}
+
+// Rotate replaces the key of an API key, keeping its name and scopes. The
+// previous key stops working at once. It returns the new plaintext key.
+func (s *Service) Rotate(ctx context.Context, id uint) (*models.APIKey, string, error) {
+	key, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if key.RevokedAt != nil {
+		return nil, "", ErrRevoked
+	}
+
+	plaintext, prefix, hash, err := generate()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	key.Prefix = prefix
+	key.Hash = hash
+	key.RotatedAt = &now
+	if err := s.repo.Save(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	s.log.Info("API key rotated",
+		zap.Uint("api_key_id", key.ID),
+		zap.String("name", key.Name),
+		zap.String("actor", actorFrom(ctx)))
+
+	return key, plaintext, nil
+}
+
+// Revoke disables an API key. The key is kept for the record.
+func (s *Service) Revoke(ctx context.Context, id uint) (*models.APIKey, error) {
+	key, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+
+	now := time.Now()
+	key.RevokedAt = &now
+	if err := s.repo.Save(ctx, key); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("API key revoked",
+		zap.Uint("api_key_id", key.ID),
+		zap.String("name", key.Name),
+		zap.String("actor", actorFrom(ctx)))
+
+	return key, nil
+}
+
+// Authenticate returns the service calling with plaintext. Every failure
+// wraps ErrInvalidKey.
+func (s *Service) Authenticate(ctx context.Context, plaintext string) (*auth.Principal, error) {
+	prefix, ok := parse(plaintext)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidKey)
+	}
+
+	key, err := s.repo.GetByPrefix(ctx, prefix)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown prefix", ErrInvalidKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashKey(plaintext)), []byte(key.Hash)) != 1 {
+		return nil, fmt.Errorf("%w: secret does not match", ErrInvalidKey)
+	}
+	now := time.Now()
+	if !key.Usable(now) {
+		return nil, fmt.Errorf("%w: revoked or expired", ErrInvalidKey)
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchResolution {
+		if err := s.repo.Touch(ctx, key.ID, now); err != nil {
+			s.log.Warn("Failed to record API key use", zap.Uint("api_key_id", key.ID), zap.Error(err))
+		}
+	}
+
+	return &auth.Principal{
+		APIKeyID:   key.ID,
+		APIKeyName: key.Name,
+		Scopes:     key.Scopes,
+		Admin:      key.Scopes.Has(models.ScopeAdmin),
+	}, nil
+}
+
+// generate returns a new random key with its public prefix and hash
+func generate() (plaintext, prefix, hash string, err error) {
+	random := make([]byte, prefixBytes+secretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	prefix = hex.EncodeToString(random[:prefixBytes])
+	plaintext = auth.APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(random[prefixBytes:])
+	return plaintext, prefix, hashKey(plaintext), nil
+}
+
+// parse returns the public prefix of a plaintext key
+func parse(plaintext string) (string, bool) {
+	rest, ok := strings.CutPrefix(plaintext, auth.APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != 2*prefixBytes || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// hashKey returns the hex SHA-256 of a plaintext key. Keys are random, so
+// a fast hash is enough to keep them from being recovered.
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// actorFrom returns the caller on ctx, as recorded for key changes
+func actorFrom(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Actor()
+	}
+	return models.ActorAPI
+}
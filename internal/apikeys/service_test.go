@@ -0,0 +1,136 @@
+package apikeys
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+func newTestService() (*Service, *repository.MemoryAPIKeyRepository) {
+	repo := repository.NewMemoryAPIKeyRepository()
+	return NewService(zap.NewNop(), repo), repo
+}
+
+func TestService_IssueAndAuthenticate(t *testing.T) {
+	svc, repo := newTestService()
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: 3, Admin: true})
+
+	key, plaintext, err := svc.Issue(ctx, " billing ", []string{models.ScopeSessionsRead}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "billing", key.Name)
+	assert.Equal(t, "user:3", key.CreatedBy)
+	assert.True(t, strings.HasPrefix(plaintext, auth.APIKeyPrefix+key.Prefix+"_"))
+	assert.NotContains(t, key.Hash, plaintext)
+
+	principal, err := svc.Authenticate(context.Background(), plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, principal.APIKeyID)
+	assert.Equal(t, "billing", principal.APIKeyName)
+	assert.True(t, principal.HasScope(models.ScopeSessionsRead))
+	assert.False(t, principal.HasScope(models.ScopeSessionsWrite))
+	assert.False(t, principal.Admin)
+	assert.Equal(t, "api_key:1", principal.Actor())
+
+	stored, err := repo.Get(context.Background(), key.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.LastUsedAt, "use is recorded")
+
+	_, err = svc.Authenticate(context.Background(), plaintext+"x")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = svc.Authenticate(context.Background(), auth.APIKeyPrefix+"0000000000000000_secret")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = svc.Authenticate(context.Background(), "not-a-key")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestService_IssueValidates(t *testing.T) {
+	svc, _ := newTestService()
+	ctx := context.Background()
+
+	_, _, err := svc.Issue(ctx, " ", []string{models.ScopeSessionsRead}, nil)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, _, err = svc.Issue(ctx, "billing", nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, _, err = svc.Issue(ctx, "billing", []string{"sessions:delete"}, nil)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestService_AdminScope(t *testing.T) {
+	svc, _ := newTestService()
+
+	_, plaintext, err := svc.Issue(context.Background(), "ops", []string{models.ScopeAdmin}, nil)
+	require.NoError(t, err)
+
+	principal, err := svc.Authenticate(context.Background(), plaintext)
+	require.NoError(t, err)
+	assert.True(t, principal.Admin)
+	assert.True(t, principal.HasScope(models.ScopeSessionsWrite))
+}
+
+func TestService_Expired(t *testing.T) {
+	svc, _ := newTestService()
+	expired := time.Now().Add(-time.Minute)
+
+	_, plaintext, err := svc.Issue(context.Background(), "billing", []string{models.ScopeSessionsRead}, &expired)
+	require.NoError(t, err)
+
+	_, err = svc.Authenticate(context.Background(), plaintext)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestService_Rotate(t *testing.T) {
+	svc, _ := newTestService()
+	ctx := context.Background()
+
+	key, old, err := svc.Issue(ctx, "billing", []string{models.ScopeSessionsWrite}, nil)
+	require.NoError(t, err)
+
+	rotated, plaintext, err := svc.Rotate(ctx, key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, rotated.ID)
+	assert.NotEqual(t, key.Prefix, rotated.Prefix)
+	assert.NotNil(t, rotated.RotatedAt)
+	assert.Equal(t, key.Scopes, rotated.Scopes)
+
+	_, err = svc.Authenticate(ctx, old)
+	assert.ErrorIs(t, err, ErrInvalidKey, "the previous key stops working")
+	principal, err := svc.Authenticate(ctx, plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, principal.APIKeyID)
+
+	_, _, err = svc.Rotate(ctx, 99)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestService_Revoke(t *testing.T) {
+	svc, _ := newTestService()
+	ctx := context.Background()
+
+	key, plaintext, err := svc.Issue(ctx, "billing", []string{models.ScopeSessionsRead}, nil)
+	require.NoError(t, err)
+
+	revoked, err := svc.Revoke(ctx, key.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+
+	_, err = svc.Authenticate(ctx, plaintext)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	_, err = svc.Revoke(ctx, key.ID)
+	assert.ErrorIs(t, err, ErrRevoked)
+	_, _, err = svc.Rotate(ctx, key.ID)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	keys, total, err := svc.List(ctx, repository.NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, keys, 1, "revoked keys stay listed")
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// APIKeyPrefix starts every API key, which tells them apart from JWTs
+const APIKeyPrefix = "ppk_"
+
+// Keys under which the authentication middleware stores the caller in the
+// gin context
+const (
+	// UserIDKey holds the caller's user ID as an int
+	UserIDKey = "user_id"
+	// RolesKey holds the caller's roles as a []string
+	RolesKey = "roles"
+	// APIKeyIDKey holds the ID of the caller's API key as a uint
+	APIKeyIDKey = "api_key_id"
+	// ActorKey holds the caller's actor, as recorded in logs and the status history
+	ActorKey = "actor"
+)
+
+// Principal is the authenticated caller of the API: a user identified by a
+// JWT or a service identified by an API key
+type Principal struct {
+	UserID int
+	Roles  []string
+	// Admin is set when the caller has the configured admin role or an API
+	// key with the admin scope, and may see and change every session
+	Admin bool
+	// APIKeyID and APIKeyName are set for services calling with an API key
+	APIKeyID   uint
+	APIKeyName string
+	// Scopes limits what an API key may do. Users may always read and write
+	// sessions.
+	Scopes models.Scopes
+}
+
+// HasRole reports whether the caller has role
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the caller may use routes guarded by scope
+func (p *Principal) HasScope(scope string) bool {
+	switch {
+	case p.Admin:
+		return true
+	case p.APIKeyID != 0:
+		return p.Scopes.Has(scope)
+	default:
+		return scope != models.ScopeAdmin
+	}
+}
+
+// Actor returns the actor recorded in logs and the status history for
+// changes the caller makes
+func (p *Principal) Actor() string {
+	if p.APIKeyID != 0 {
+		return "api_key:" + strconv.FormatUint(uint64(p.APIKeyID), 10)
+	}
+	return "user:" + strconv.Itoa(p.UserID)
+}
+
+// AllUsers reports whether the caller acts on sessions of every user.
+// Admins and services do; users only act on their own sessions.
+func (p *Principal) AllUsers() bool {
+	return p.Admin || p.APIKeyID != 0
+}
+
+// Owns reports whether the caller may see and change a session of userID
+func (p *Principal) Owns(userID int) bool {
+	return p.AllUsers() || p.UserID == userID
+}
+
+// principalKey is the context key of the authenticated caller
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the authenticated caller
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the caller set on ctx by WithPrincipal. It reports
+// false for requests that were not authenticated, such as those served
+// while authentication is disabled and those made by background jobs.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
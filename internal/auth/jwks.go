@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultJWKSRefresh is used when no JWKS refresh interval is configured
+	defaultJWKSRefresh = 5 * time.Minute
+	// unknownKeyRefresh limits how often an unknown key ID triggers a fetch
+	unknownKeyRefresh = 30 * time.Second
+)
+
+// ErrUnknownKey is returned for tokens signed with a key that is not in the key set
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// jwk is an entry of a JSON Web Key Set. Only RSA keys are used.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// keySet holds the RS256 public keys of a JWKS file or URL. Keys loaded
+// from a URL are fetched again once they are older than the refresh
+// interval, or sooner when a token names a key ID that is not known yet.
+type keySet struct {
+	log     *zap.Logger
+	load    func(ctx context.Context) ([]byte, error)
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// newFileKeySet loads the keys of a JWKS file once
+func newFileKeySet(path string) (*keySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &keySet{keys: keys, fetched: time.Now()}, nil
+}
+
+// newURLKeySet fetches the keys of a JWKS endpoint and keeps them refreshed
+func newURLKeySet(ctx context.Context, log *zap.Logger, url string, refresh time.Duration) (*keySet, error) {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	set := &keySet{
+		log:     log,
+		refresh: refresh,
+		load: func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return nil, err
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("JWKS endpoint returned %s", resp.Status)
+			}
+			return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		},
+	}
+
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	if err := set.fetch(ctx); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// key returns the public key with the given ID. A token without a key ID
+// is accepted when the set holds a single key.
+func (s *keySet) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.lookup(kid)
+	if s.load != nil {
+		age := time.Since(s.fetched)
+		if age > s.refresh || (!ok && age > unknownKeyRefresh) {
+			// Keep serving the known keys if the endpoint is unavailable
+			if err := s.fetch(ctx); err != nil {
+				s.log.Warn("Failed to refresh JWKS, keeping the known keys", zap.Error(err))
+			} else {
+				key, ok = s.lookup(kid)
+			}
+		}
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// lookup finds a key without fetching. Callers hold mu.
+func (s *keySet) lookup(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// fetch replaces the keys with those currently served. Callers hold mu.
+func (s *keySet) fetch(ctx context.Context) error {
+	s.fetched = time.Now()
+
+	data, err := s.load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.keys = keys
+	return nil
+}
+
+// parseJWKS returns the RSA signing keys of a JSON Web Key Set by key ID
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS holds no RSA signing keys")
+	}
+	return keys, nil
+}
+
+// rsaKey decodes the base64url modulus and exponent of an RSA JWK
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("exponent: %w", err)
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("unsupported key parameters")
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
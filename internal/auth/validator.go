@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultUserIDClaim is used when no user ID claim is configured
+	defaultUserIDClaim = "sub"
+	// defaultRolesClaim is used when no roles claim is configured
+	defaultRolesClaim = "roles"
+	// defaultAdminRole is used when no admin role is configured
+	defaultAdminRole = "admin"
+	// leeway absorbs clock skew with the token issuer
+	leeway = 30 * time.Second
+)
+
+var (
+	// ErrNoKeys is returned by NewValidator when neither an HS256 secret nor a JWKS is configured
+	ErrNoKeys = errors.New("no HS256 secret or JWKS configured")
+	// ErrInvalidToken is returned for tokens that are malformed, expired, not
+	// signed by a configured key or missing the user ID
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+// Validator checks JWTs signed with an HS256 shared secret or with an RS256
+// key from a JWKS and returns the caller they identify
+type Validator struct {
+	secret      []byte
+	keys        *keySet
+	parser      *jwt.Parser
+	userIDClaim string
+	rolesClaim  string
+	adminRole   string
+}
+
+// NewValidator creates a validator for the keys in cfg. A JWKS URL is
+// fetched before it returns, so a misconfigured endpoint fails at startup.
+func NewValidator(ctx context.Context, log *zap.Logger, cfg *config.AuthConfig) (*Validator, error) {
+	v := &Validator{
+		secret:      []byte(cfg.HS256Secret),
+		userIDClaim: cfg.UserIDClaim,
+		rolesClaim:  cfg.RolesClaim,
+		adminRole:   cfg.AdminRole,
+	}
+	if v.userIDClaim == "" {
+		v.userIDClaim = defaultUserIDClaim
+	}
+	if v.rolesClaim == "" {
+		v.rolesClaim = defaultRolesClaim
+	}
+	if v.adminRole == "" {
+		v.adminRole = defaultAdminRole
+	}
+
+	var err error
+	switch {
+	case cfg.JWKSFile != "":
+		v.keys, err = newFileKeySet(cfg.JWKSFile)
+	case cfg.JWKSURL != "":
+		v.keys, err = newURLKeySet(ctx, log, cfg.JWKSURL, time.Duration(cfg.JWKSRefresh)*time.Second)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	methods := []string{}
+	if len(v.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if v.keys != nil {
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(leeway),
+	}
+	if cfg.Issuer != "" {
+		options = append(options, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		options = append(options, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+// Validate checks the signature and claims of token and returns the caller
+// it identifies. Every failure wraps ErrInvalidToken.
+func (v *Validator) Validate(ctx context.Context, token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := v.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.Alg() {
+		case jwt.SigningMethodHS256.Alg():
+			return v.secret, nil
+		case jwt.SigningMethodRS256.Alg():
+			kid, _ := t.Header["kid"].(string)
+			return v.keys.key(ctx, kid)
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, err := intClaim(claims[v.userIDClaim])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claim %s: %v", ErrInvalidToken, v.userIDClaim, err)
+	}
+
+	principal := &Principal{
+		UserID: userID,
+		Roles:  stringsClaim(claims[v.rolesClaim]),
+	}
+	principal.Admin = principal.HasRole(v.adminRole)
+	return principal, nil
+}
+
+// intClaim reads a positive user ID from a JSON number or a numeric string
+func intClaim(value interface{}) (int, error) {
+	var id int
+	switch value := value.(type) {
+	case float64:
+		if value != float64(int(value)) {
+			return 0, errors.New("not an integer")
+		}
+		id = int(value)
+	case string:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, errors.New("not an integer")
+		}
+		id = parsed
+	case nil:
+		return 0, errors.New("missing")
+	default:
+		return 0, errors.New("not an integer")
+	}
+
+	if id <= 0 {
+		return 0, errors.New("not a user ID")
+	}
+	return id, nil
+}
+
+// stringsClaim reads roles from a JSON array of strings or a space
+// separated string, as used by the scope claim
+func stringsClaim(value interface{}) []string {
+	switch value := value.(type) {
+	case []interface{}:
+		roles := make([]string, 0, len(value))
+		for _, role := range value {
+			if role, ok := role.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	case string:
+		return strings.Fields(value)
+	default:
+		return nil
+	}
+}
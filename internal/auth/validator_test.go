@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const testSecret = "test-secret"
+
+// sign returns a token for claims signed with key. Unless claims set exp,
+// the token expires in an hour; an exp of nil leaves it out.
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	if exp, ok := claims["exp"]; !ok {
+		claims["exp"] = time.Now().Add(time.Hour).Unix()
+	} else if exp == nil {
+		delete(claims, "exp")
+	}
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// jwksJSON encodes the public halves of keys as a JWKS
+func jwksJSON(t *testing.T, keys map[string]*rsa.PrivateKey) []byte {
+	t.Helper()
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func TestNewValidator_RequiresKeys(t *testing.T) {
+	_, err := NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{Enabled: true})
+	assert.ErrorIs(t, err, ErrNoKeys)
+
+	_, err = NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{JWKSFile: "missing.json"})
+	assert.Error(t, err)
+}
+
+func TestValidator_HS256(t *testing.T) {
+	v, err := NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{
+		HS256Secret: testSecret,
+		Issuer:      "paypilot",
+		Audience:    "dev-sessions",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	token := sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", jwt.MapClaims{
+		"sub": "42", "roles": []string{"developer", "admin"}, "iss": "paypilot", "aud": "dev-sessions",
+	})
+	principal, err := v.Validate(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, 42, principal.UserID)
+	assert.Equal(t, []string{"developer", "admin"}, principal.Roles)
+	assert.True(t, principal.Admin)
+	assert.Equal(t, "user:42", principal.Actor())
+
+	for name, claims := range map[string]jwt.MapClaims{
+		"expired":      {"sub": "42", "iss": "paypilot", "aud": "dev-sessions", "exp": time.Now().Add(-time.Hour).Unix()},
+		"no expiry":    {"sub": "42", "iss": "paypilot", "aud": "dev-sessions", "exp": nil},
+		"wrong issuer": {"sub": "42", "iss": "other", "aud": "dev-sessions"},
+		"wrong aud":    {"sub": "42", "iss": "paypilot", "aud": "other"},
+		"no user":      {"iss": "paypilot", "aud": "dev-sessions"},
+		"bad user":     {"sub": "alice", "iss": "paypilot", "aud": "dev-sessions"},
+	} {
+		token = sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", claims)
+		_, err := v.Validate(ctx, token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	forged := sign(t, jwt.SigningMethodHS256, []byte("other-secret"), "", jwt.MapClaims{"sub": "42", "iss": "paypilot", "aud": "dev-sessions"})
+	_, err = v.Validate(ctx, forged)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	unsigned := sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", jwt.MapClaims{"sub": "42", "iss": "paypilot", "aud": "dev-sessions"})
+	_, err = v.Validate(ctx, unsigned)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestValidator_RS256FromFile(t *testing.T) {
+	key := newRSAKey(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwksJSON(t, map[string]*rsa.PrivateKey{"k1": key}), 0o600))
+
+	v, err := NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{
+		JWKSFile:    path,
+		UserIDClaim: "uid",
+		RolesClaim:  "scope",
+		AdminRole:   "sessions:admin",
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	token := sign(t, jwt.SigningMethodRS256, key, "k1", jwt.MapClaims{"uid": 7, "scope": "sessions:read sessions:admin"})
+	principal, err := v.Validate(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, 7, principal.UserID)
+	assert.True(t, principal.Admin)
+
+	// A single key is used for tokens without a key ID
+	token = sign(t, jwt.SigningMethodRS256, key, "", jwt.MapClaims{"uid": 7})
+	principal, err = v.Validate(ctx, token)
+	require.NoError(t, err)
+	assert.False(t, principal.Admin)
+
+	token = sign(t, jwt.SigningMethodRS256, newRSAKey(t), "k1", jwt.MapClaims{"uid": 7})
+	_, err = v.Validate(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "signed by another key")
+
+	token = sign(t, jwt.SigningMethodHS256, []byte(testSecret), "", jwt.MapClaims{"uid": 7})
+	_, err = v.Validate(ctx, token)
+	assert.ErrorIs(t, err, ErrInvalidToken, "HS256 is not configured")
+}
+
+func TestValidator_RS256FromURL(t *testing.T) {
+	key := newRSAKey(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(jwksJSON(t, map[string]*rsa.PrivateKey{"k1": key}))
+	}))
+	defer server.Close()
+
+	v, err := NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{JWKSURL: server.URL})
+	require.NoError(t, err)
+
+	token := sign(t, jwt.SigningMethodRS256, key, "k1", jwt.MapClaims{"sub": "9"})
+	principal, err := v.Validate(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, 9, principal.UserID)
+
+	token = sign(t, jwt.SigningMethodRS256, key, "k2", jwt.MapClaims{"sub": "9"})
+	_, err = v.Validate(context.Background(), token)
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestKeySet_RefreshesStaleKeys(t *testing.T) {
+	old, rotated := newRSAKey(t), newRSAKey(t)
+	served := map[string]*rsa.PrivateKey{"old": old}
+	set := &keySet{
+		log:     zap.NewNop(),
+		refresh: time.Minute,
+		load: func(ctx context.Context) ([]byte, error) {
+			return jwksJSON(t, served), nil
+		},
+	}
+	require.NoError(t, set.fetch(context.Background()))
+
+	served = map[string]*rsa.PrivateKey{"rotated": rotated}
+	_, err := set.key(context.Background(), "rotated")
+	assert.ErrorIs(t, err, ErrUnknownKey, "recently fetched keys are not fetched again")
+
+	set.fetched = time.Now().Add(-2 * unknownKeyRefresh)
+	key, err := set.key(context.Background(), "rotated")
+	require.NoError(t, err)
+	assert.Equal(t, rotated.N, key.N)
+}
+
+func TestParseJWKS(t *testing.T) {
+	_, err := parseJWKS([]byte(`{"keys": [{"kty": "EC", "kid": "ec"}]}`))
+	assert.Error(t, err, "no RSA keys")
+
+	_, err = parseJWKS([]byte(`{"keys": [{"kty": "RSA", "kid": "bad", "n": "!!", "e": "AQAB"}]}`))
+	assert.Error(t, err)
+
+	_, err = parseJWKS([]byte(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,318 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"go.uber.org/zap"
+)
+
+// Command types
+const (
+	CreateSession = "create_session"
+	DeleteSession = "delete_session"
+	StopSession   = "stop_session"
+	UpdateSession = "update_session"
+)
+
+// Reply codes
+const (
+	CodeOK             = "ok"
+	CodeInvalidCommand = "invalid_command"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeUnavailable    = "unavailable"
+	CodeInternal       = "internal_error"
+)
+
+// Command is the JSON body of a command message. Commands other than
+// create_session address their session by session_id or project_uuid.
+type Command struct {
+	Type        string     `json:"type"`
+	SessionID   uint       `json:"session_id,omitempty"`
+	ProjectUUID string     `json:"project_uuid,omitempty"`
+	ProjectID   *int       `json:"project_id,omitempty"`
+	UserID      *int       `json:"user_id,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// Reply is published to the command's reply-to queue with its correlation ID
+type Reply struct {
+	Type    string          `json:"type"`
+	OK      bool            `json:"ok"`
+	Code    string          `json:"code"`
+	Error   string          `json:"error,omitempty"`
+	Session *models.Session `json:"session,omitempty"`
+}
+
+// errInvalid marks a command that failed validation
+type errInvalid struct {
+	reason string
+}
+
+func (e *errInvalid) Error() string {
+	return e.reason
+}
+
+// invalid returns a validation error for a command
+func invalid(format string, args ...interface{}) error {
+	return &errInvalid{reason: fmt.Sprintf(format, args...)}
+}
+
+// Consumer executes session commands received over RabbitMQ through the
+// same session service the HTTP API uses
+type Consumer struct {
+	log      *zap.Logger
+	sessions *sessions.Service
+	replier  messaging.Replier
+	ledger   Ledger
+}
+
+// NewConsumer creates a command consumer. Replies are sent through replier.
+// Commands are deduplicated by message ID through ledger, which may be nil.
+func NewConsumer(log *zap.Logger, svc *sessions.Service, replier messaging.Replier, ledger Ledger) *Consumer {
+	return &Consumer{
+		log:      log,
+		sessions: svc,
+		replier:  replier,
+		ledger:   ledger,
+	}
+}
+
+// Handle executes one command message and replies with the outcome.
+// Commands that fail for good, such as malformed or invalid commands and
+// commands for a missing or conflicting session, are acknowledged and the
+// failure is reported in the reply. Internal and unavailable failures may
+// pass, so they are returned without a reply: the message is retried and
+// dead-lettered once it runs out of attempts, and the attempt that settles
+// it replies. A redelivered message with a message ID already in the ledger
+// is not executed again, its recorded reply is sent instead; only settled
+// commands are recorded, so a retried or replayed one runs again.
+func (c *Consumer) Handle(ctx context.Context, msg messaging.Message) error {
+	dedupe := c.ledger != nil && msg.MessageID != ""
+	if dedupe {
+		recorded, done, err := c.ledger.Begin(ctx, msg.MessageID)
+		if err != nil {
+			// Returned so the message is retried once the other delivery is done
+			return err
+		}
+		if done {
+			c.log.Info("Duplicate command, replaying recorded reply",
+				zap.String("message_id", msg.MessageID),
+				zap.String("correlation_id", msg.CorrelationID))
+			c.send(ctx, msg, recorded)
+			return nil
+		}
+	}
+
+	reply := c.handle(ctx, msg)
+	if transient(reply.Code) {
+		// Only settled commands are recorded, a retry or replay runs it again
+		if dedupe {
+			if err := c.ledger.Release(ctx, msg.MessageID); err != nil {
+				c.log.Error("Failed to release command claim",
+					zap.String("message_id", msg.MessageID),
+					zap.Error(err))
+			}
+		}
+		return fmt.Errorf("%s: %s", reply.Code, reply.Error)
+	}
+
+	body, err := json.Marshal(reply)
+	if err != nil {
+		c.log.Error("Failed to encode command reply", zap.Error(err))
+		return nil
+	}
+
+	if dedupe {
+		if err := c.ledger.Finish(ctx, msg.MessageID, body); err != nil {
+			c.log.Error("Failed to record handled command",
+				zap.String("message_id", msg.MessageID),
+				zap.Error(err))
+		}
+	}
+
+	c.send(ctx, msg, body)
+	return nil
+}
+
+// handle decodes and executes a command and builds its reply
+func (c *Consumer) handle(ctx context.Context, msg messaging.Message) Reply {
+	var cmd Command
+	var reply Reply
+
+	if err := json.Unmarshal(msg.Body, &cmd); err != nil {
+		reply = failure("", invalid("malformed command: %v", err))
+	} else {
+		session, err := c.execute(ctx, &cmd)
+		if err != nil {
+			reply = failure(cmd.Type, err)
+		} else {
+			reply = Reply{Type: cmd.Type, OK: true, Code: CodeOK, Session: session}
+		}
+	}
+
+	if !reply.OK {
+		c.log.Warn("Command failed",
+			zap.String("type", cmd.Type),
+			zap.String("code", reply.Code),
+			zap.String("error", reply.Error),
+			zap.String("correlation_id", msg.CorrelationID))
+	} else {
+		c.log.Info("Command executed",
+			zap.String("type", cmd.Type),
+			zap.String("correlation_id", msg.CorrelationID))
+	}
+
+	return reply
+}
+
+// execute validates a command and runs it against the session service
+func (c *Consumer) execute(ctx context.Context, cmd *Command) (*models.Session, error) {
+	if err := cmd.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx = sessions.WithActor(ctx, models.ActorCommand)
+
+	if cmd.Type == CreateSession {
+		session := &models.Session{
+			UserID:      *cmd.UserID,
+			ProjectID:   *cmd.ProjectID,
+			ProjectUUID: cmd.ProjectUUID,
+			IsActive:    true,
+		}
+		if cmd.ExpiresAt != nil {
+			session.ExpiresAt = *cmd.ExpiresAt
+		}
+
+		if _, err := c.sessions.Create(ctx, session); err != nil {
+			return nil, err
+		}
+		return session, nil
+	}
+
+	id, err := c.sessionID(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cmd.Type {
+	case DeleteSession:
+		return c.sessions.Delete(ctx, id)
+	case StopSession:
+		return c.sessions.Stop(ctx, id)
+	default:
+		return c.sessions.Update(ctx, id, sessions.Update{
+			UserID:    cmd.UserID,
+			ProjectID: cmd.ProjectID,
+			ExpiresAt: cmd.ExpiresAt,
+		})
+	}
+}
+
+// sessionID resolves the session a command addresses
+func (c *Consumer) sessionID(ctx context.Context, cmd *Command) (uint, error) {
+	if cmd.SessionID != 0 {
+		return cmd.SessionID, nil
+	}
+
+	session, err := c.sessions.GetByProjectUUID(ctx, cmd.ProjectUUID)
+	if err != nil {
+		return 0, err
+	}
+	return session.ID, nil
+}
+
+// validate checks that a command has the fields its type requires
+func (cmd *Command) validate() error {
+	switch cmd.Type {
+	case CreateSession:
+		if cmd.UserID == nil || *cmd.UserID <= 0 {
+			return invalid("user_id is required")
+		}
+		if cmd.ProjectID == nil || *cmd.ProjectID <= 0 {
+			return invalid("project_id is required")
+		}
+		if cmd.ProjectUUID == "" {
+			return invalid("project_uuid is required")
+		}
+		if _, err := uuid.Parse(cmd.ProjectUUID); err != nil {
+			return invalid("project_uuid is not a valid UUID")
+		}
+		return nil
+	case DeleteSession, StopSession, UpdateSession:
+		if cmd.SessionID == 0 && cmd.ProjectUUID == "" {
+			return invalid("session_id or project_uuid is required")
+		}
+		if cmd.Type == UpdateSession && cmd.UserID == nil && cmd.ProjectID == nil && cmd.ExpiresAt == nil {
+			return invalid("update_session needs user_id, project_id or expires_at")
+		}
+		return nil
+	case "":
+		return invalid("type is required")
+	default:
+		return invalid("unknown command type %q", cmd.Type)
+	}
+}
+
+// failure builds the reply for a failed command
+func failure(commandType string, err error) Reply {
+	return Reply{
+		Type:  commandType,
+		OK:    false,
+		Code:  errorCode(err),
+		Error: err.Error(),
+	}
+}
+
+// errorCode classifies an error for the reply
+func errorCode(err error) string {
+	var invalidErr *errInvalid
+
+	switch {
+	case errors.As(err, &invalidErr):
+		return CodeInvalidCommand
+	case errors.Is(err, sessions.ErrNotFound), errors.Is(err, kubernetes.ErrReleaseNotFound):
+		return CodeNotFound
+	case errors.Is(err, sessions.ErrExists), errors.Is(err, sessions.ErrInactive),
+		errors.Is(err, sessions.ErrProvisioning), errors.Is(err, sessions.ErrWaking),
+		errors.Is(err, sessions.ErrStopping), errors.Is(err, sessions.ErrNoDevContainer),
+		errors.Is(err, sessions.ErrStopped), errors.Is(err, sessions.ErrNotStopped), errors.Is(err, sessions.ErrHibernated),
+		errors.Is(err, models.ErrInvalidTransition), errors.Is(err, repository.ErrStaleStatus):
+		return CodeConflict
+	case errors.Is(err, sessions.ErrUnavailable), errors.Is(err, sessions.ErrRuntimeUnavailable):
+		return CodeUnavailable
+	default:
+		return CodeInternal
+	}
+}
+
+// transient reports whether a command that failed with code may succeed
+// when it is tried again
+func transient(code string) bool {
+	return code == CodeInternal || code == CodeUnavailable
+}
+
+// send publishes the encoded reply if the command asked for one
+func (c *Consumer) send(ctx context.Context, msg messaging.Message, body []byte) {
+	if msg.ReplyTo == "" {
+		return
+	}
+
+	if err := c.replier.Reply(ctx, msg, body); err != nil {
+		c.log.Error("Failed to publish command reply",
+			zap.String("reply_to", msg.ReplyTo),
+			zap.String("correlation_id", msg.CorrelationID),
+			zap.Error(err))
+	}
+}
@@ -0,0 +1,292 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+// fakeReplier records replies
+type fakeReplier struct {
+	replies []messaging.Message
+	bodies  [][]byte
+}
+
+func (r *fakeReplier) Reply(ctx context.Context, msg messaging.Message, body []byte) error {
+	r.replies = append(r.replies, msg)
+	r.bodies = append(r.bodies, body)
+	return nil
+}
+
+func handle(t *testing.T, body string) (*fakeReplier, Reply) {
+	t.Helper()
+
+	replier := &fakeReplier{}
+	consumer := NewConsumer(zap.NewNop(), nil, replier, nil)
+
+	err := consumer.Handle(context.Background(), messaging.Message{
+		Body:          []byte(body),
+		ReplyTo:       "caller.replies",
+		CorrelationID: "corr-1",
+	})
+	require.NoError(t, err)
+	require.Len(t, replier.bodies, 1)
+
+	var reply Reply
+	require.NoError(t, json.Unmarshal(replier.bodies[0], &reply))
+	return replier, reply
+}
+
+func TestHandle_MalformedCommand(t *testing.T) {
+	replier, reply := handle(t, "{not json")
+
+	assert.False(t, reply.OK)
+	assert.Equal(t, CodeInvalidCommand, reply.Code)
+	assert.Contains(t, reply.Error, "malformed command")
+	assert.Equal(t, "caller.replies", replier.replies[0].ReplyTo)
+	assert.Equal(t, "corr-1", replier.replies[0].CorrelationID)
+}
+
+func TestHandle_InvalidCommands(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		error string
+	}{
+		{"missing type", `{}`, "type is required"},
+		{"unknown type", `{"type":"launch_rocket"}`, `unknown command type "launch_rocket"`},
+		{"create without user", `{"type":"create_session","project_id":1,"project_uuid":"550e8400-e29b-41d4-a716-446655440000"}`, "user_id is required"},
+		{"create without project", `{"type":"create_session","user_id":1,"project_uuid":"550e8400-e29b-41d4-a716-446655440000"}`, "project_id is required"},
+		{"create with bad uuid", `{"type":"create_session","user_id":1,"project_id":1,"project_uuid":"nope"}`, "project_uuid is not a valid UUID"},
+		{"delete without target", `{"type":"delete_session"}`, "session_id or project_uuid is required"},
+		{"stop without target", `{"type":"stop_session"}`, "session_id or project_uuid is required"},
+		{"update without fields", `{"type":"update_session","session_id":3}`, "update_session needs user_id, project_id or expires_at"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, reply := handle(t, tt.body)
+
+			assert.False(t, reply.OK)
+			assert.Equal(t, CodeInvalidCommand, reply.Code)
+			assert.Equal(t, tt.error, reply.Error)
+		})
+	}
+}
+
+func TestHandle_NoReplyTo(t *testing.T) {
+	replier := &fakeReplier{}
+	consumer := NewConsumer(zap.NewNop(), nil, replier, nil)
+
+	err := consumer.Handle(context.Background(), messaging.Message{Body: []byte(`{}`)})
+	require.NoError(t, err)
+	assert.Empty(t, replier.replies)
+}
+
+// fakeLedger keeps handled messages in memory
+type fakeLedger struct {
+	replies  map[string][]byte
+	inFlight map[string]bool
+	finished int
+}
+
+func newFakeLedger() *fakeLedger {
+	return &fakeLedger{replies: map[string][]byte{}, inFlight: map[string]bool{}}
+}
+
+func (l *fakeLedger) Begin(ctx context.Context, messageID string) ([]byte, bool, error) {
+	if reply, ok := l.replies[messageID]; ok {
+		return reply, true, nil
+	}
+	if l.inFlight[messageID] {
+		return nil, false, ErrInProgress
+	}
+	l.inFlight[messageID] = true
+	return nil, false, nil
+}
+
+func (l *fakeLedger) Finish(ctx context.Context, messageID string, reply []byte) error {
+	delete(l.inFlight, messageID)
+	l.replies[messageID] = reply
+	l.finished++
+	return nil
+}
+
+func (l *fakeLedger) Release(ctx context.Context, messageID string) error {
+	delete(l.inFlight, messageID)
+	return nil
+}
+
+func TestHandle_DuplicateReplaysRecordedReply(t *testing.T) {
+	replier := &fakeReplier{}
+	ledger := newFakeLedger()
+	consumer := NewConsumer(zap.NewNop(), nil, replier, ledger)
+
+	msg := messaging.Message{
+		Body:          []byte(`{"type":"launch_rocket"}`),
+		MessageID:     "msg-1",
+		ReplyTo:       "caller.replies",
+		CorrelationID: "corr-1",
+	}
+	require.NoError(t, consumer.Handle(context.Background(), msg))
+	require.NoError(t, consumer.Handle(context.Background(), msg))
+
+	assert.Equal(t, 1, ledger.finished, "the command must only be executed once")
+	require.Len(t, replier.bodies, 2)
+	assert.Equal(t, replier.bodies[0], replier.bodies[1])
+}
+
+func TestHandle_InProgressIsRetried(t *testing.T) {
+	replier := &fakeReplier{}
+	ledger := newFakeLedger()
+	ledger.inFlight["msg-1"] = true
+	consumer := NewConsumer(zap.NewNop(), nil, replier, ledger)
+
+	err := consumer.Handle(context.Background(), messaging.Message{
+		Body:      []byte(`{}`),
+		MessageID: "msg-1",
+		ReplyTo:   "caller.replies",
+	})
+	assert.ErrorIs(t, err, ErrInProgress)
+	assert.Empty(t, replier.replies)
+}
+
+func TestHandle_WithoutMessageIDSkipsLedger(t *testing.T) {
+	replier := &fakeReplier{}
+	ledger := newFakeLedger()
+	consumer := NewConsumer(zap.NewNop(), nil, replier, ledger)
+
+	msg := messaging.Message{Body: []byte(`{}`), ReplyTo: "caller.replies"}
+	require.NoError(t, consumer.Handle(context.Background(), msg))
+	require.NoError(t, consumer.Handle(context.Background(), msg))
+
+	assert.Zero(t, ledger.finished)
+	assert.Len(t, replier.replies, 2)
+}
+
+// newUnavailableConsumer returns a bus and a consumer of it whose session
+// service has no runtime, with one session to send commands to
+func newUnavailableConsumer(t *testing.T, maxAttempts int) (*messaging.MemoryBus, *Consumer) {
+	t.Helper()
+
+	bus := messaging.NewMemoryBus(&config.RabbitMQConfig{
+		Queue:    "paypilot_queue",
+		Commands: "dev_session.command.#",
+		Retry:    config.RabbitMQRetryConfig{MaxAttempts: maxAttempts, InitialDelay: 1},
+	}, zap.NewNop())
+	t.Cleanup(func() { bus.Close() })
+
+	repo := repository.NewMemorySessionRepository()
+	require.NoError(t, repo.Create(context.Background(), &models.Session{
+		ProjectUUID: "550e8400-e29b-41d4-a716-446655440000",
+		TokenHash:   models.HashToken("tok"),
+	}, ""))
+
+	return bus, NewConsumer(zap.NewNop(), sessions.NewService(zap.NewNop(), repo, nil, nil, nil), bus, nil)
+}
+
+func TestHandle_UnavailableIsRetriedThenDeadLettered(t *testing.T) {
+	bus, consumer := newUnavailableConsumer(t, 2)
+
+	attempts := make(chan error, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bus.Consume(ctx, func(ctx context.Context, msg messaging.Message) error {
+		err := consumer.Handle(ctx, msg)
+		attempts <- err
+		return err
+	})
+
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.command.stop_session", []byte(`{"type":"stop_session","session_id":1}`)))
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		select {
+		case err := <-attempts:
+			assert.ErrorContains(t, err, CodeUnavailable, "attempt %d", attempt)
+		case <-time.After(3 * time.Second):
+			t.Fatalf("attempt %d was not delivered", attempt)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		letters, err := bus.DeadLetters(context.Background(), 10)
+		return err == nil && len(letters) == 1 && letters[0].Attempts == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandle_PermanentFailureIsAcknowledged(t *testing.T) {
+	_, consumer := newUnavailableConsumer(t, 2)
+	replier := &fakeReplier{}
+	consumer.replier = replier
+
+	err := consumer.Handle(context.Background(), messaging.Message{
+		Body:    []byte(`{"type":"stop_session","session_id":42}`),
+		ReplyTo: "caller.replies",
+	})
+	require.NoError(t, err)
+	require.Len(t, replier.bodies, 1)
+
+	var reply Reply
+	require.NoError(t, json.Unmarshal(replier.bodies[0], &reply))
+	assert.Equal(t, CodeNotFound, reply.Code)
+}
+
+func TestErrorCode(t *testing.T) {
+	assert.Equal(t, CodeNotFound, errorCode(sessions.ErrNotFound))
+	assert.Equal(t, CodeConflict, errorCode(sessions.ErrExists))
+	assert.Equal(t, CodeConflict, errorCode(sessions.ErrProvisioning))
+	assert.Equal(t, CodeUnavailable, errorCode(sessions.ErrUnavailable))
+	assert.Equal(t, CodeUnavailable, errorCode(sessions.ErrRuntimeUnavailable))
+	assert.Equal(t, CodeInternal, errorCode(errors.New("boom")))
+}
+
+func TestHandle_TransientFailureIsNotRecorded(t *testing.T) {
+	bus, consumer := newUnavailableConsumer(t, 1)
+	ledger := newFakeLedger()
+	consumer.ledger = ledger
+
+	handled := make(chan error, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bus.Consume(ctx, func(ctx context.Context, msg messaging.Message) error {
+		err := consumer.Handle(ctx, msg)
+		handled <- err
+		return err
+	})
+
+	// Request gives the command a message ID; nobody answers it
+	requestCtx, cancelRequest := context.WithTimeout(context.Background(), 2*time.Second)
+	t.Cleanup(cancelRequest)
+	go bus.Request(requestCtx, "dev_session.command.stop_session", []byte(`{"type":"stop_session","session_id":1}`))
+
+	next := func() error {
+		select {
+		case err := <-handled:
+			return err
+		case <-time.After(time.Second):
+			t.Fatal("command was not delivered")
+			return nil
+		}
+	}
+	assert.ErrorContains(t, next(), CodeUnavailable)
+	assert.Empty(t, ledger.inFlight, "the claim is released")
+	assert.Zero(t, ledger.finished, "a transient failure is not recorded")
+
+	// A replayed dead letter runs the command again instead of hitting the ledger
+	replayed, err := bus.ReplayDeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, replayed)
+	assert.ErrorContains(t, next(), CodeUnavailable)
+	assert.Zero(t, ledger.finished)
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// defaultRetention is used when no ledger retention is configured
+	defaultRetention = 7 * 24 * time.Hour
+	// defaultClaimTimeout is used when no claim timeout is configured
+	defaultClaimTimeout = 5 * time.Minute
+	// cleanupInterval is the time between removals of expired ledger entries
+	cleanupInterval = time.Hour
+)
+
+// ErrInProgress is returned by Ledger.Begin while another delivery of the
+// same message is being handled
+var ErrInProgress = errors.New("message is already being handled")
+
+// Ledger remembers handled messages by message ID so that a redelivered
+// command is not executed twice
+type Ledger interface {
+	// Begin claims a message. If the message was handled before it returns
+	// the recorded reply and done is true.
+	Begin(ctx context.Context, messageID string) (reply []byte, done bool, err error)
+	// Finish records the reply of a claimed message that was settled
+	Finish(ctx context.Context, messageID string, reply []byte) error
+	// Release gives up the claim on a message that was not settled, so that
+	// its next delivery, or its replay from the dead-letter queue, runs it
+	// again
+	Release(ctx context.Context, messageID string) error
+}
+
+// DBLedger is a Ledger stored in the processed_messages table
+type DBLedger struct {
+	log *zap.Logger
+	db  *gorm.DB
+	cfg *config.LedgerConfig
+}
+
+// NewDBLedger creates a ledger stored in db
+func NewDBLedger(log *zap.Logger, db *gorm.DB, cfg *config.LedgerConfig) *DBLedger {
+	return &DBLedger{
+		log: log,
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// Begin claims a message by inserting its ledger entry. A claim left
+// unfinished for longer than the claim timeout, e.g. because the consumer
+// crashed, is taken over.
+func (l *DBLedger) Begin(ctx context.Context, messageID string) ([]byte, bool, error) {
+	now := time.Now()
+
+	entry := models.ProcessedMessage{MessageID: messageID, ClaimedAt: now}
+	result := l.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&entry)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("failed to claim message: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil, false, nil
+	}
+
+	var existing models.ProcessedMessage
+	if err := l.db.WithContext(ctx).Where("message_id = ?", messageID).First(&existing).Error; err != nil {
+		return nil, false, fmt.Errorf("failed to load processed message: %w", err)
+	}
+	if existing.CompletedAt != nil {
+		return []byte(existing.Reply), true, nil
+	}
+
+	result = l.db.WithContext(ctx).Model(&models.ProcessedMessage{}).
+		Where("message_id = ? AND completed_at IS NULL AND claimed_at < ?", messageID, now.Add(-l.claimTimeout())).
+		Update("claimed_at", now)
+	if result.Error != nil {
+		return nil, false, fmt.Errorf("failed to take over message claim: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		l.log.Warn("Taking over abandoned message claim", zap.String("message_id", messageID))
+		return nil, false, nil
+	}
+
+	return nil, false, ErrInProgress
+}
+
+// Finish records the reply of a handled message
+func (l *DBLedger) Finish(ctx context.Context, messageID string, reply []byte) error {
+	updates := map[string]interface{}{
+		"completed_at": time.Now(),
+		"reply":        string(reply),
+	}
+	err := l.db.WithContext(ctx).Model(&models.ProcessedMessage{}).Where("message_id = ?", messageID).Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to record processed message: %w", err)
+	}
+	return nil
+}
+
+// Release removes the unfinished ledger entry of a message
+func (l *DBLedger) Release(ctx context.Context, messageID string) error {
+	err := l.db.WithContext(ctx).Where("message_id = ? AND completed_at IS NULL", messageID).Delete(&models.ProcessedMessage{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release message claim: %w", err)
+	}
+	return nil
+}
+
+// Run removes entries older than the retention period every hour until ctx
+// is cancelled
+func (l *DBLedger) Run(ctx context.Context) {
+	l.log.Info("Message ledger cleanup started", zap.Duration("retention", l.retention()))
+
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.log.Info("Stopping message ledger cleanup")
+			return
+		case <-ticker.C:
+			if _, err := l.Cleanup(ctx); err != nil {
+				l.log.Error("Failed to remove expired ledger entries", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Cleanup deletes entries older than the retention period and returns how
+// many were removed. A message redelivered after that is handled again.
+func (l *DBLedger) Cleanup(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-l.retention())
+
+	result := l.db.WithContext(ctx).Where("created_at < ?", cutoff).Delete(&models.ProcessedMessage{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+
+	if result.RowsAffected > 0 {
+		l.log.Info("Removed expired ledger entries", zap.Int64("count", result.RowsAffected))
+	}
+
+	return result.RowsAffected, nil
+}
+
+// retention returns how long handled messages are remembered
+func (l *DBLedger) retention() time.Duration {
+	retention := time.Duration(l.cfg.Retention) * time.Second
+	if retention <= 0 {
+		return defaultRetention
+	}
+	return retention
+}
+
+// claimTimeout returns how long a claim may stay unfinished before a
+// redelivery takes it over
+func (l *DBLedger) claimTimeout() time.Duration {
+	timeout := time.Duration(l.cfg.ClaimTimeout) * time.Second
+	if timeout <= 0 {
+		return defaultClaimTimeout
+	}
+	return timeout
+}
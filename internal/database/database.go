@@ -1,10 +1,10 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
-	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
 	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
 	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
@@ -22,8 +22,10 @@ func Initialize(cfg *config.DatabaseConfig, log *zap.Logger) error {
 	gormLogger := logger.Default.LogMode(logger.Info)
 
 	// Open database connection
+	// Constraint violations are translated into GORM errors such as gorm.ErrDuplicatedKey
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: gormLogger,
+		Logger:         gormLogger,
+		TranslateError: true,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
@@ -51,22 +53,39 @@ func Initialize(cfg *config.DatabaseConfig, log *zap.Logger) error {
 	return nil
 }
 
-// AutoMigrate runs auto migration for all models
-func AutoMigrate(log *zap.Logger) error {
+// Migrate prepares the schema at startup according to mode: "auto" (or
+// empty) applies pending migrations, "verify" refuses to start while any
+// migration is pending and "off" leaves the schema alone
+func Migrate(ctx context.Context, mode string, log *zap.Logger) error {
 	if DB == nil {
 		return fmt.Errorf("database not initialized")
 	}
+	if mode == "off" {
+		return nil
+	}
 
-	// Migrate dev session model
-	err := DB.AutoMigrate(
-		&models.Session{},
-	)
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	migrator, err := NewMigrator(sqlDB, log)
 	if err != nil {
-		return fmt.Errorf("failed to auto migrate: %w", err)
+		return err
 	}
 
-	log.Info("Database migration completed")
-	return nil
+	switch mode {
+	case "auto", "":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Info("Database migration completed", zap.Int("applied", applied), zap.Int("version", migrator.Latest()))
+		return nil
+	case "verify":
+		return migrator.Verify(ctx)
+	default:
+		return fmt.Errorf("unknown migration mode: %s", mode)
+	}
 }
 
 // Close closes the database connection
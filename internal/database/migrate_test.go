@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewMigrator_EmbeddedMigrations(t *testing.T) {
+	migrator, err := NewMigrator(nil, zap.NewNop())
+	require.NoError(t, err)
+	require.NotEmpty(t, migrator.migrations)
+
+	for i, migration := range migrator.migrations {
+		assert.Equal(t, i+1, migration.Version, "migration versions must be consecutive")
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+	assert.Equal(t, len(migrator.migrations), migrator.Latest())
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations(fstest.MapFS{
+		"0002_add_column.up.sql":     {Data: []byte("ALTER TABLE t ADD COLUMN c text;")},
+		"0002_add_column.down.sql":   {Data: []byte("ALTER TABLE t DROP COLUMN c;")},
+		"0001_create_table.up.sql":   {Data: []byte("CREATE TABLE t (id int);")},
+		"0001_create_table.down.sql": {Data: []byte("DROP TABLE t;")},
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 1, Name: "create_table", Up: "CREATE TABLE t (id int);", Down: "DROP TABLE t;"}, migrations[0])
+	assert.Equal(t, 2, migrations[1].Version)
+	assert.Equal(t, "add_column", migrations[1].Name)
+}
+
+func TestLoadMigrations_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		error string
+	}{
+		{"bad name", fstest.MapFS{"create.sql": {}}, "invalid migration file name: create.sql"},
+		{"zero version", fstest.MapFS{"0000_x.up.sql": {}}, "invalid migration version: 0000_x.up.sql"},
+		{"missing down", fstest.MapFS{"0001_x.up.sql": {Data: []byte("SELECT 1;")}}, "migration 1_x needs both an up and a down file"},
+		{"two names", fstest.MapFS{"0001_x.up.sql": {}, "0001_y.down.sql": {}}, "migration 1 has two names: x and y"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMigrations(tt.files)
+			assert.EqualError(t, err, tt.error)
+		})
+	}
+}
+
+func TestMigrator_Plan(t *testing.T) {
+	migrator := &Migrator{migrations: []Migration{{Version: 1}, {Version: 2}, {Version: 3}}}
+	now := time.Now()
+
+	versions := func(migrations []Migration) []int {
+		var versions []int
+		for _, migration := range migrations {
+			versions = append(versions, migration.Version)
+		}
+		return versions
+	}
+
+	up, down := migrator.plan(map[int]time.Time{}, 3)
+	assert.Equal(t, []int{1, 2, 3}, versions(up))
+	assert.Empty(t, down)
+
+	up, down = migrator.plan(map[int]time.Time{1: now, 2: now, 3: now}, 1)
+	assert.Empty(t, up)
+	assert.Equal(t, []int{3, 2}, versions(down), "migrations are reverted newest first")
+
+	up, down = migrator.plan(map[int]time.Time{1: now, 3: now}, 2)
+	assert.Equal(t, []int{2}, versions(up))
+	assert.Equal(t, []int{3}, versions(down))
+
+	up, down = migrator.plan(map[int]time.Time{1: now, 2: now}, 0)
+	assert.Empty(t, up)
+	assert.Equal(t, []int{2, 1}, versions(down))
+}
@@ -0,0 +1,31 @@
+-- Sessions as created by the first release. IF NOT EXISTS lets databases
+-- that were set up by GORM AutoMigrate adopt the versioned migrations.
+CREATE TABLE IF NOT EXISTS sessions (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user_id bigint NOT NULL,
+    project_id bigint NOT NULL,
+    project_uuid text NOT NULL,
+    token text NOT NULL,
+    expires_at timestamptz,
+    container_name text,
+    namespace text,
+    status text DEFAULT 'pending',
+    ip_address text,
+    user_agent text,
+    is_active boolean DEFAULT true,
+    preview_url text,
+    preview_path text,
+    chat_url text,
+    chat_path text,
+    vscode_url text,
+    vscode_path text
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_deleted_at ON sessions (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_project_id ON sessions (project_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_project_uuid ON sessions (project_uuid);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token ON sessions (token);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_sessions_last_accessed_at;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS internal_vscode_url;
+ALTER TABLE sessions DROP COLUMN IF EXISTS internal_chat_url;
+ALTER TABLE sessions DROP COLUMN IF EXISTS internal_preview_url;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_accessed_at;
@@ -0,0 +1,6 @@
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_accessed_at timestamptz;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS internal_preview_url text;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS internal_chat_url text;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS internal_vscode_url text;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_last_accessed_at ON sessions (last_accessed_at);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    event_id text NOT NULL,
+    type text NOT NULL,
+    session_id bigint,
+    payload jsonb NOT NULL,
+    attempts bigint DEFAULT 0,
+    last_error text,
+    delivered_at timestamptz
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_events_event_id ON outbox_events (event_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_session_id ON outbox_events (session_id);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_delivered_at ON outbox_events (delivered_at);
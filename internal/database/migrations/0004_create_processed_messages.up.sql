@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS processed_messages (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    message_id text NOT NULL,
+    claimed_at timestamptz,
+    completed_at timestamptz,
+    reply text
+);
+
+CREATE INDEX IF NOT EXISTS idx_processed_messages_created_at ON processed_messages (created_at);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_processed_messages_message_id ON processed_messages (message_id);
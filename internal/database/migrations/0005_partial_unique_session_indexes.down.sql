@@ -0,0 +1,7 @@
+-- Fails while a deleted and a live session share a project UUID or token;
+-- purge the deleted rows first
+DROP INDEX IF EXISTS idx_sessions_project_uuid;
+DROP INDEX IF EXISTS idx_sessions_token;
+
+CREATE UNIQUE INDEX idx_sessions_project_uuid ON sessions (project_uuid);
+CREATE UNIQUE INDEX idx_sessions_token ON sessions (token);
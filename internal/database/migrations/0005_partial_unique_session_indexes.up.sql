@@ -0,0 +1,7 @@
+-- Soft-deleted sessions no longer hold on to their project UUID and token,
+-- so a project can get a new session after its previous one was deleted
+DROP INDEX IF EXISTS idx_sessions_project_uuid;
+DROP INDEX IF EXISTS idx_sessions_token;
+
+CREATE UNIQUE INDEX idx_sessions_project_uuid ON sessions (project_uuid) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX idx_sessions_token ON sessions (token) WHERE deleted_at IS NULL;
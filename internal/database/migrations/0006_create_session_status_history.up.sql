@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS session_status_history (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    session_id bigint NOT NULL,
+    from_status text NOT NULL,
+    to_status text NOT NULL,
+    reason text,
+    actor text NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_status_history_session_id ON session_status_history (session_id);
@@ -0,0 +1,15 @@
+-- Hashed tokens cannot be turned back into plaintext. Every session gets a
+-- new random token, so existing session links stop working.
+DROP INDEX IF EXISTS idx_sessions_previous_token_hash;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS token_rotated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS previous_token_expires_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS previous_token_hash;
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token text;
+UPDATE sessions SET token = gen_random_uuid()::text;
+ALTER TABLE sessions ALTER COLUMN token SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_sessions_token_hash;
+ALTER TABLE sessions DROP COLUMN token_hash;
+CREATE UNIQUE INDEX idx_sessions_token ON sessions (token) WHERE deleted_at IS NULL;
@@ -0,0 +1,17 @@
+-- Session tokens are stored as SHA-256 hashes instead of plaintext. Existing
+-- tokens are hashed in place, so they keep working.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_hash text;
+UPDATE sessions SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex');
+ALTER TABLE sessions ALTER COLUMN token_hash SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_sessions_token;
+ALTER TABLE sessions DROP COLUMN token;
+CREATE UNIQUE INDEX idx_sessions_token_hash ON sessions (token_hash) WHERE deleted_at IS NULL;
+
+-- The token replaced by the last rotation keeps working until
+-- previous_token_expires_at
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS previous_token_hash text;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS previous_token_expires_at timestamptz;
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_rotated_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS idx_sessions_previous_token_hash ON sessions (previous_token_hash);
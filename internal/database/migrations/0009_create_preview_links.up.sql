@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS preview_links (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    session_id bigint NOT NULL,
+    created_by text,
+    expires_at timestamptz NOT NULL,
+    password_hash text,
+    max_uses bigint,
+    uses bigint NOT NULL DEFAULT 0,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+
+CREATE INDEX IF NOT EXISTS idx_preview_links_session_id ON preview_links (session_id);
@@ -0,0 +1,151 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// SchemaVersion is the version of the Envelope format. It is bumped on
+// incompatible changes so consumers can tell old and new events apart.
+const SchemaVersion = 1
+
+// Event types. Each is also the routing key the event is published with.
+const (
+	// SessionCreated is published when a session row is created or adopted
+	SessionCreated = "session.created"
+	// SessionUpdated is published when a session's owner, project ID or expiry changes
+	SessionUpdated = "session.updated"
+	// SessionRenewed is published when an expired project session is given a new token
+	SessionRenewed = "session.renewed"
+	// SessionTokenRotated is published when a session's access token is replaced
+	SessionTokenRotated = "session.token_rotated"
+	// SessionPending is published when a session is queued for provisioning again
+	SessionPending = "session.pending"
+	// SessionProvisioning is published when a dev container starts being installed
+	SessionProvisioning = "session.provisioning"
+	// SessionRunning is published when a dev container becomes ready
+	SessionRunning = "session.running"
+	// SessionError is published when provisioning or a lifecycle operation fails
+	SessionError = "session.error"
+	// SessionStopped is published when a dev container is scaled to zero on request
+	SessionStopped = "session.stopped"
+	// SessionRestarted is published when a dev container's pod is replaced
+	SessionRestarted = "session.restarted"
+	// SessionHibernated is published when an idle dev container is scaled to zero
+	SessionHibernated = "session.hibernated"
+	// SessionWaking is published when a hibernated dev container is being started
+	SessionWaking = "session.waking"
+	// SessionExpired is published when an expired session is torn down
+	SessionExpired = "session.expired"
+	// SessionDeleted is published when a session is deleted
+	SessionDeleted = "session.deleted"
+	// SessionRestored is published when a deleted session is restored
+	SessionRestored = "session.restored"
+)
+
+// Envelope is the JSON body of every published event
+type Envelope struct {
+	Version    int       `json:"version"`
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Session    Snapshot  `json:"session"`
+}
+
+// Snapshot is the state of a session when the event occurred.
+// The session token is deliberately left out.
+type Snapshot struct {
+	ID             uint                 `json:"id"`
+	UserID         int                  `json:"user_id"`
+	ProjectID      int                  `json:"project_id"`
+	ProjectUUID    string               `json:"project_uuid"`
+	Status         models.SessionStatus `json:"status"`
+	IsActive       bool                 `json:"is_active"`
+	Namespace      string               `json:"namespace"`
+	ContainerName  string               `json:"container_name"`
+	ExpiresAt      time.Time            `json:"expires_at"`
+	LastAccessedAt *time.Time           `json:"last_accessed_at"`
+	PreviewURL     string               `json:"preview_url"`
+	ChatURL        string               `json:"chat_url"`
+	VscodeURL      string               `json:"vscode_url"`
+}
+
+// NewEnvelope builds the event of the given type for a session
+func NewEnvelope(eventType string, session *models.Session) Envelope {
+	return Envelope{
+		Version:    SchemaVersion,
+		ID:         uuid.New().String(),
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Session: Snapshot{
+			ID:             session.ID,
+			UserID:         session.UserID,
+			ProjectID:      session.ProjectID,
+			ProjectUUID:    session.ProjectUUID,
+			Status:         session.Status,
+			IsActive:       session.IsActive,
+			Namespace:      session.Namespace,
+			ContainerName:  session.ContainerName,
+			ExpiresAt:      session.ExpiresAt,
+			LastAccessedAt: session.LastAccessedAt,
+			PreviewURL:     session.PreviewURL,
+			ChatURL:        session.ChatURL,
+			VscodeURL:      session.VscodeURL,
+		},
+	}
+}
+
+// StatusEvent returns the event type announcing that a session moved to status
+func StatusEvent(status models.SessionStatus) string {
+	return "session." + string(status)
+}
+
+// Emitter records session lifecycle events in the outbox table. The outbox
+// relay publishes them once the transaction that recorded them commits, so
+// an event is never sent for a change that was rolled back. A nil *Emitter
+// records nothing, so callers never need to check.
+type Emitter struct {
+	log *zap.Logger
+}
+
+// NewEmitter creates an emitter
+func NewEmitter(log *zap.Logger) *Emitter {
+	return &Emitter{log: log}
+}
+
+// Record writes an event for the session to the outbox using tx, which
+// should be the transaction that applies the session change
+func (e *Emitter) Record(tx *gorm.DB, eventType string, session *models.Session) error {
+	if e == nil {
+		return nil
+	}
+
+	envelope := NewEnvelope(eventType, session)
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to encode session event: %w", err)
+	}
+
+	event := models.OutboxEvent{
+		EventID:   envelope.ID,
+		Type:      eventType,
+		SessionID: session.ID,
+		Payload:   string(payload),
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to record session event: %w", err)
+	}
+
+	e.log.Debug("Session event recorded",
+		zap.String("type", eventType),
+		zap.String("event_id", envelope.ID),
+		zap.Uint("session_id", session.ID))
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"go.uber.org/zap"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newDryRunDB returns a database handle that builds statements without
+// running them, and a pointer to the last outbox row it was asked to create
+func newDryRunDB(t *testing.T) (*gorm.DB, **models.OutboxEvent) {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+	require.NoError(t, err)
+
+	var created *models.OutboxEvent
+	err = db.Callback().Create().After("gorm:create").Register("test:capture", func(tx *gorm.DB) {
+		if event, ok := tx.Statement.Dest.(*models.OutboxEvent); ok {
+			created = event
+		}
+	})
+	require.NoError(t, err)
+
+	return db, &created
+}
+
+func TestEmitter_RecordsEnvelope(t *testing.T) {
+	db, created := newDryRunDB(t)
+	emitter := NewEmitter(zap.NewNop())
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err := emitter.Record(db, SessionRunning, &models.Session{
+		ID:          7,
+		ProjectUUID: "550e8400-e29b-41d4-a716-446655440000",
+		ProjectID:   3,
+		UserID:      4,
+		Token:       "secret",
+		Status:      "running",
+		IsActive:    true,
+		ExpiresAt:   expiresAt,
+		PreviewURL:  "https://example.com/preview",
+	})
+	require.NoError(t, err)
+
+	event := *created
+	require.NotNil(t, event)
+	assert.Equal(t, SessionRunning, event.Type)
+	assert.Equal(t, uint(7), event.SessionID)
+	assert.Nil(t, event.DeliveredAt)
+	assert.NotContains(t, event.Payload, "secret")
+
+	var envelope Envelope
+	require.NoError(t, json.Unmarshal([]byte(event.Payload), &envelope))
+	assert.Equal(t, event.EventID, envelope.ID)
+	assert.Equal(t, SchemaVersion, envelope.Version)
+	assert.Equal(t, SessionRunning, envelope.Type)
+	assert.WithinDuration(t, time.Now(), envelope.OccurredAt, time.Minute)
+	assert.Equal(t, uint(7), envelope.Session.ID)
+	assert.Equal(t, models.StatusRunning, envelope.Session.Status)
+	assert.Equal(t, "https://example.com/preview", envelope.Session.PreviewURL)
+	assert.True(t, expiresAt.Equal(envelope.Session.ExpiresAt))
+}
+
+func TestEmitter_UniqueEventIDs(t *testing.T) {
+	session := &models.Session{ID: 1}
+	assert.NotEqual(t, NewEnvelope(SessionCreated, session).ID, NewEnvelope(SessionCreated, session).ID)
+}
+
+func TestEmitter_Nil(t *testing.T) {
+	db, created := newDryRunDB(t)
+
+	var nilEmitter *Emitter
+	assert.NoError(t, nilEmitter.Record(db, SessionCreated, &models.Session{ID: 1}))
+	assert.Nil(t, *created)
+}
+
+func TestStatusEvent(t *testing.T) {
+	assert.Equal(t, SessionRunning, StatusEvent("running"))
+	assert.Equal(t, SessionError, StatusEvent("error"))
+	assert.Equal(t, SessionHibernated, StatusEvent("hibernated"))
+	assert.Equal(t, SessionPending, StatusEvent("pending"))
+}
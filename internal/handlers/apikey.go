@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/apikeys"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+// APIKeyHandler issues, lists, rotates and revokes the API keys services
+// call the API with
+type APIKeyHandler struct {
+	log  *zap.Logger
+	keys *apikeys.Service
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(log *zap.Logger, keys *apikeys.Service) *APIKeyHandler {
+	return &APIKeyHandler{
+		log:  log,
+		keys: keys,
+	}
+}
+
+// issueAPIKeyRequest is the body of an API key request
+type issueAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// issuedAPIKey is an API key together with its plaintext key, which is only
+// returned when the key is issued or rotated
+type issuedAPIKey struct {
+	APIKey *models.APIKey `json:"api_key"`
+	Key    string         `json:"key"`
+}
+
+// IssueAPIKey godoc
+// @Summary Issue an API key
+// @Description Create an API key for a service with the given scopes (sessions:read, sessions:write, admin). The key is only returned in this response; only its hash is stored.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body issueAPIKeyRequest true "Key name, scopes and optional expiry"
+// @Success 201 {object} issuedAPIKey
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) IssueAPIKey(c *gin.Context) {
+	var req issueAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plaintext, err := h.keys.Issue(c.Request.Context(), req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		h.respondError(c, err, "Failed to issue API key")
+		return
+	}
+
+	c.JSON(http.StatusCreated, issuedAPIKey{APIKey: key, Key: plaintext})
+}
+
+// ListAPIKeys godoc
+// @Summary List API keys
+// @Description List issued API keys, revoked ones included, newest first. Keys themselves are never returned.
+// @Tags admin
+// @Produce json
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size" default(10)
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	pageNumber, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	page := repository.NewPage(pageNumber, pageSize)
+
+	keys, total, err := h.keys.List(c.Request.Context(), page)
+	if err != nil {
+		h.respondError(c, err, "Failed to list API keys")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"api_keys": keys,
+		"pagination": gin.H{
+			"page":        page.Number,
+			"page_size":   page.Size,
+			"total":       total,
+			"total_pages": page.TotalPages(total),
+		},
+	})
+}
+
+// RotateAPIKey godoc
+// @Summary Rotate an API key
+// @Description Replace an API key's key, keeping its name and scopes. The previous key stops working immediately and the new key is only returned in this response.
+// @Tags admin
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} issuedAPIKey
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/api-keys/{id}/rotate [post]
+func (h *APIKeyHandler) RotateAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	key, plaintext, err := h.keys.Rotate(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to rotate API key")
+		return
+	}
+
+	c.JSON(http.StatusOK, issuedAPIKey{APIKey: key, Key: plaintext})
+}
+
+// RevokeAPIKey godoc
+// @Summary Revoke an API key
+// @Description Disable an API key. It stays listed with revoked_at set.
+// @Tags admin
+// @Produce json
+// @Param id path int true "API key ID"
+// @Success 200 {object} models.APIKey
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	key, err := h.keys.Revoke(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to revoke API key")
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// respondError writes the response for an error returned by the API key
+// service. Unexpected errors are reported with fallback.
+func (h *APIKeyHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, apikeys.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+	case errors.Is(err, apikeys.ErrRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": "API key is revoked"})
+	case errors.Is(err, apikeys.ErrInvalidRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.log.Error(fallback, zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
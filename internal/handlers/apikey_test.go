@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/apikeys"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+func newAPIKeyRouter() (*gin.Engine, *apikeys.Service) {
+	gin.SetMode(gin.TestMode)
+
+	svc := apikeys.NewService(zap.NewNop(), repository.NewMemoryAPIKeyRepository())
+	h := NewAPIKeyHandler(zap.NewNop(), svc)
+	router := gin.New()
+	router.POST("/admin/api-keys", h.IssueAPIKey)
+	router.GET("/admin/api-keys", h.ListAPIKeys)
+	router.POST("/admin/api-keys/:id/rotate", h.RotateAPIKey)
+	router.DELETE("/admin/api-keys/:id", h.RevokeAPIKey)
+	return router, svc
+}
+
+func TestAPIKeyHandler(t *testing.T) {
+	router, svc := newAPIKeyRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/api-keys",
+		strings.NewReader(`{"name": "billing", "scopes": ["sessions:read"]}`)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), `"hash"`)
+
+	keys, _, err := svc.List(t.Context(), repository.NewPage(1, 10))
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	w, body := serve(router, http.MethodGet, "/admin/api-keys")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["api_keys"], 1)
+
+	w, body = serve(router, http.MethodPost, "/admin/api-keys/1/rotate")
+	require.Equal(t, http.StatusOK, w.Code)
+	plaintext, _ := body["key"].(string)
+	_, err = svc.Authenticate(t.Context(), plaintext)
+	assert.NoError(t, err)
+
+	w, _ = serve(router, http.MethodDelete, "/admin/api-keys/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, err = svc.Authenticate(t.Context(), plaintext)
+	assert.ErrorIs(t, err, apikeys.ErrInvalidKey)
+
+	w, _ = serve(router, http.MethodDelete, "/admin/api-keys/1")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w, _ = serve(router, http.MethodPost, "/admin/api-keys/2/rotate")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = serve(router, http.MethodDelete, "/admin/api-keys/abc")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestAPIKeyHandler_IssueRejectsUnknownScope(t *testing.T) {
+	router, _ := newAPIKeyRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/api-keys",
+		strings.NewReader(`{"name": "billing", "scopes": ["sessions:delete"]}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "sessions:delete")
+}
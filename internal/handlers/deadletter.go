@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultDeadLetterLimit is the number of dead letters listed or replayed by default
+	defaultDeadLetterLimit = 20
+	// maxDeadLetterLimit caps the number of dead letters handled per request
+	maxDeadLetterLimit = 500
+)
+
+// DeadLetterHandler inspects, replays and purges messages that failed every
+// handling attempt
+type DeadLetterHandler struct {
+	log   *zap.Logger
+	store messaging.DeadLetterStore
+}
+
+// NewDeadLetterHandler creates a new dead-letter handler.
+// store may be nil when RabbitMQ is not available.
+func NewDeadLetterHandler(log *zap.Logger, store messaging.DeadLetterStore) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		log:   log,
+		store: store,
+	}
+}
+
+// ListDeadLetters godoc
+// @Summary List dead-lettered messages
+// @Description Show messages that failed every handling attempt without removing them from the dead-letter queue
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param limit query int false "Maximum number of messages" default(20)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/dead-letters [get]
+func (h *DeadLetterHandler) ListDeadLetters(c *gin.Context) {
+	limit, ok := h.limit(c)
+	if !ok {
+		return
+	}
+
+	letters, err := h.store.DeadLetters(c.Request.Context(), limit)
+	if err != nil {
+		h.log.Error("Failed to list dead letters", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": letters,
+		"count":        len(letters),
+	})
+}
+
+// ReplayDeadLetters godoc
+// @Summary Replay dead-lettered messages
+// @Description Publish dead-lettered messages again with their original routing key and a fresh attempt count
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param limit query int false "Maximum number of messages" default(20)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/dead-letters/replay [post]
+func (h *DeadLetterHandler) ReplayDeadLetters(c *gin.Context) {
+	limit, ok := h.limit(c)
+	if !ok {
+		return
+	}
+
+	replayed, err := h.store.ReplayDeadLetters(c.Request.Context(), limit)
+	if err != nil {
+		h.log.Error("Failed to replay dead letters", zap.Int("replayed", replayed), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":    "Failed to replay dead letters",
+			"replayed": replayed,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"replayed": replayed})
+}
+
+// PurgeDeadLetters godoc
+// @Summary Purge dead-lettered messages
+// @Description Drop every message in the dead-letter queue
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/dead-letters [delete]
+func (h *DeadLetterHandler) PurgeDeadLetters(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	purged, err := h.store.PurgeDeadLetters(c.Request.Context())
+	if err != nil {
+		h.log.Error("Failed to purge dead letters", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge dead letters"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purged": purged})
+}
+
+// available responds with 503 when RabbitMQ is not available
+func (h *DeadLetterHandler) available(c *gin.Context) bool {
+	if h.store == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "RabbitMQ is not available"})
+		return false
+	}
+	return true
+}
+
+// limit parses the limit query parameter
+func (h *DeadLetterHandler) limit(c *gin.Context) (int, bool) {
+	if !h.available(c) {
+		return 0, false
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDeadLetterLimit)))
+	if err != nil || limit < 1 || limit > maxDeadLetterLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+		return 0, false
+	}
+	return limit, true
+}
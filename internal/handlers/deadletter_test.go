@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+	"go.uber.org/zap"
+)
+
+// fakeDeadLetterStore keeps dead letters in memory
+type fakeDeadLetterStore struct {
+	letters []messaging.DeadLetter
+	err     error
+}
+
+func (s *fakeDeadLetterStore) DeadLetters(ctx context.Context, limit int) ([]messaging.DeadLetter, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.letters[:min(limit, len(s.letters))], nil
+}
+
+func (s *fakeDeadLetterStore) ReplayDeadLetters(ctx context.Context, limit int) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := min(limit, len(s.letters))
+	s.letters = s.letters[n:]
+	return n, nil
+}
+
+func (s *fakeDeadLetterStore) PurgeDeadLetters(ctx context.Context) (int, error) {
+	n := len(s.letters)
+	s.letters = nil
+	return n, s.err
+}
+
+func newDeadLetterRouter(store messaging.DeadLetterStore) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	h := NewDeadLetterHandler(zap.NewNop(), store)
+	router := gin.New()
+	router.GET("/admin/dead-letters", h.ListDeadLetters)
+	router.POST("/admin/dead-letters/replay", h.ReplayDeadLetters)
+	router.DELETE("/admin/dead-letters", h.PurgeDeadLetters)
+	return router
+}
+
+func serve(router *gin.Engine, method, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	return w, body
+}
+
+func TestDeadLetterHandler(t *testing.T) {
+	store := &fakeDeadLetterStore{letters: []messaging.DeadLetter{
+		{MessageID: "1", RoutingKey: "dev_session.command.stop_session", Attempts: 5},
+		{MessageID: "2", RoutingKey: "dev_session.command.stop_session", Attempts: 5},
+		{MessageID: "3", RoutingKey: "dev_session.command.delete_session", Attempts: 5},
+	}}
+	router := newDeadLetterRouter(store)
+
+	w, body := serve(router, http.MethodGet, "/admin/dead-letters?limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), body["count"])
+	assert.Len(t, store.letters, 3, "listing must not remove messages")
+
+	w, body = serve(router, http.MethodPost, "/admin/dead-letters/replay?limit=1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), body["replayed"])
+	assert.Len(t, store.letters, 2)
+
+	w, body = serve(router, http.MethodDelete, "/admin/dead-letters")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), body["purged"])
+	assert.Empty(t, store.letters)
+}
+
+func TestDeadLetterHandler_InvalidLimit(t *testing.T) {
+	router := newDeadLetterRouter(&fakeDeadLetterStore{})
+
+	for _, limit := range []string{"0", "-1", "abc", "501"} {
+		w, _ := serve(router, http.MethodGet, "/admin/dead-letters?limit="+limit)
+		assert.Equal(t, http.StatusBadRequest, w.Code, limit)
+	}
+}
+
+func TestDeadLetterHandler_StoreError(t *testing.T) {
+	router := newDeadLetterRouter(&fakeDeadLetterStore{err: errors.New("channel closed")})
+
+	w, _ := serve(router, http.MethodPost, "/admin/dead-letters/replay")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestDeadLetterHandler_Unavailable(t *testing.T) {
+	router := newDeadLetterRouter(nil)
+
+	w, _ := serve(router, http.MethodGet, "/admin/dead-letters")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w, _ = serve(router, http.MethodDelete, "/admin/dead-letters")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
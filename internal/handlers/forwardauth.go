@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/hibernator"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/previewlinks"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Headers read and written by the forward-auth endpoint
+const (
+	// sessionTokenHeader carries a session token on requests to the session Ingress
+	sessionTokenHeader = "X-Session-Token"
+	// originalURLHeader is the URL of the request ingress-nginx is authenticating
+	originalURLHeader = "X-Original-URL"
+	// originalMethodHeader is the method of the request ingress-nginx is authenticating
+	originalMethodHeader = "X-Original-Method"
+
+	// Identity headers returned to ingress-nginx, which forwards them to the dev container
+	sessionIDHeader   = "X-Session-Id"
+	userIDHeader      = "X-Session-User-Id"
+	projectIDHeader   = "X-Session-Project-Id"
+	projectUUIDHeader = "X-Session-Project-Uuid"
+	previewLinkHeader = "X-Preview-Link-Id"
+)
+
+// defaultSessionCookie is the cookie the forward-auth endpoint keeps the
+// session token in when no name is configured
+const defaultSessionCookie = "dev_session_token"
+
+// ForwardAuthHandler answers the auth subrequests ingress-nginx makes before
+// routing a request to a session's dev container through its Ingress
+type ForwardAuthHandler struct {
+	log          *zap.Logger
+	repo         repository.SessionRepository
+	hibernator   *hibernator.Hibernator
+	links        *previewlinks.Service
+	cookieName   string
+	cookieSecure bool
+}
+
+// NewForwardAuthHandler creates a new forward-auth handler. Session tokens
+// are looked up in repo. hib records activity and wakes hibernated
+// sessions; it may be nil. links checks preview links; it is nil when they
+// are disabled. Tokens passed in the URL are kept in the cookieName cookie,
+// marked Secure when cookieSecure is set.
+func NewForwardAuthHandler(log *zap.Logger, repo repository.SessionRepository, hib *hibernator.Hibernator, links *previewlinks.Service, cookieName string, cookieSecure bool) *ForwardAuthHandler {
+	if cookieName == "" {
+		cookieName = defaultSessionCookie
+	}
+
+	return &ForwardAuthHandler{
+		log:          log,
+		repo:         repo,
+		hibernator:   hib,
+		links:        links,
+		cookieName:   cookieName,
+		cookieSecure: cookieSecure,
+	}
+}
+
+// Verify godoc
+// @Summary Verify a session token for ingress-nginx
+// @Description Forward-auth endpoint for the nginx.ingress.kubernetes.io/auth-url annotation. The session token is read from the X-Session-Token header, the token query parameter of this request or of the original request (X-Original-URL), or the session cookie, in that order. The session must live in the given namespace. On success the session's identity is returned in the X-Session-Id, X-Session-User-Id, X-Session-Project-Id and X-Session-Project-Uuid headers, and a token passed in the URL is stored in the session cookie. A preview link in place of the token only lets GET and HEAD requests (X-Original-Method) to the preview path through, never WebSocket upgrades or paths with ".." segments, asks for the link's password with basic auth, keeps its grant in the dev_preview_grant cookie and returns X-Preview-Link-Id instead of X-Session-User-Id.
+// @Tags auth
+// @Param namespace query string true "Namespace of the Ingress the request was made to"
+// @Param token query string false "Session token"
+// @Param X-Session-Token header string false "Session token"
+// @Success 200
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Router /auth/verify [get]
+func (h *ForwardAuthHandler) Verify(c *gin.Context) {
+	namespace := c.Query("namespace")
+	if namespace == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Namespace is required"})
+		return
+	}
+
+	token, fromURL := h.token(c)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing session token"})
+		return
+	}
+
+	if previewlinks.IsCredential(token) {
+		h.verifyPreviewLink(c, namespace, token)
+		return
+	}
+
+	session, err := h.repo.GetByToken(c.Request.Context(), token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return
+	}
+	if err != nil {
+		h.log.Error("Failed to load session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session"})
+		return
+	}
+
+	if session.Namespace != namespace {
+		h.log.Warn("Session token used outside its namespace",
+			zap.Uint("session_id", session.ID),
+			zap.String("namespace", namespace))
+		c.JSON(http.StatusForbidden, gin.H{"error": "Session token is not valid for this namespace"})
+		return
+	}
+
+	if !session.IsActive || session.IsExpired() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Session has expired"})
+		return
+	}
+
+	h.touch(c, session)
+
+	// Browsers only carry the token in the first URL, so keep it for the
+	// requests that follow. ingress-nginx passes the cookie on to the client.
+	if fromURL {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(h.cookieName, token, 0, "/", "", h.cookieSecure, true)
+	}
+
+	c.Header(sessionIDHeader, strconv.FormatUint(uint64(session.ID), 10))
+	c.Header(userIDHeader, strconv.Itoa(session.UserID))
+	c.Header(projectIDHeader, strconv.Itoa(session.ProjectID))
+	c.Header(projectUUIDHeader, session.ProjectUUID)
+	c.Status(http.StatusOK)
+}
+
+// verifyPreviewLink answers a request made with a preview link or the grant
+// a browser got for one. The grant is kept in its own cookie, next to the
+// session cookie.
+func (h *ForwardAuthHandler) verifyPreviewLink(c *gin.Context, namespace, token string) {
+	if h.links == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return
+	}
+
+	// Without the original method the request cannot be shown to only read
+	if !readOnly(c.GetHeader(originalMethodHeader), c.Request.Header) {
+		respondPreviewLinkError(c, previewlinks.ErrOutOfScope)
+		return
+	}
+	original, err := url.Parse(c.GetHeader(originalURLHeader))
+	if err != nil {
+		respondPreviewLinkError(c, previewlinks.ErrOutOfScope)
+		return
+	}
+	requestPath, ok := cleanPath(original.Path)
+	if !ok {
+		respondPreviewLinkError(c, previewlinks.ErrOutOfScope)
+		return
+	}
+
+	grant, _ := c.Cookie(previewGrantCookie)
+	_, password, _ := c.Request.BasicAuth()
+	access, err := h.links.Open(c.Request.Context(), token, grant, password, func(session *models.Session) error {
+		if session.Namespace != namespace {
+			return fmt.Errorf("%w: namespace %s", previewlinks.ErrOutOfScope, namespace)
+		}
+		if endpointOf(session, requestPath) != kubernetes.EndpointPreview {
+			return fmt.Errorf("%w: path %s", previewlinks.ErrOutOfScope, requestPath)
+		}
+		return nil
+	})
+	if err != nil {
+		if !respondPreviewLinkError(c, err) {
+			h.log.Error("Failed to open preview link", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open preview link"})
+		}
+		return
+	}
+
+	h.touch(c, access.Session)
+
+	if access.Grant != "" {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(previewGrantCookie, access.Grant, int(time.Until(access.Link.ExpiresAt).Seconds()), "/", "", h.cookieSecure, true)
+	}
+
+	session := access.Session
+	c.Header(sessionIDHeader, strconv.FormatUint(uint64(session.ID), 10))
+	c.Header(projectIDHeader, strconv.Itoa(session.ProjectID))
+	c.Header(projectUUIDHeader, session.ProjectUUID)
+	c.Header(previewLinkHeader, strconv.FormatUint(uint64(access.Link.ID), 10))
+	c.Status(http.StatusOK)
+}
+
+// touch records activity on the session and wakes it if it is hibernated
+func (h *ForwardAuthHandler) touch(c *gin.Context, session *models.Session) {
+	if h.hibernator == nil {
+		return
+	}
+	h.hibernator.Touch(c.Request.Context(), session)
+	if session.Status == models.StatusHibernated {
+		if err := h.hibernator.Wake(c.Request.Context(), session); err != nil {
+			h.log.Error("Failed to wake session", zap.Uint("session_id", session.ID), zap.Error(err))
+		}
+	}
+}
+
+// cleanPath returns the cleaned form of a decoded request path. ingress-nginx
+// passes the path as the client sent it but routes on the normalized one, so
+// a path with ".." segments could name a different endpoint than it appears
+// to and is refused.
+func cleanPath(requestPath string) (string, bool) {
+	if requestPath == "" {
+		return "", false
+	}
+	for _, segment := range strings.Split(requestPath, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	return path.Clean(requestPath), true
+}
+
+// endpointOf returns the endpoint of session that serves path, the one with
+// the longest path that path lies under, or "" when none does. A preview
+// served at the root so only gets the paths no other endpoint claims.
+func endpointOf(session *models.Session, path string) string {
+	endpoint, longest := "", -1
+	for _, name := range []string{kubernetes.EndpointPreview, kubernetes.EndpointChat, kubernetes.EndpointVscode} {
+		base := strings.TrimSuffix(endpointPath(session, name), "/")
+		if len(base) > longest && underPath(path, base) {
+			endpoint, longest = name, len(base)
+		}
+	}
+	return endpoint
+}
+
+// underPath reports whether path is base or lies below it
+func underPath(path, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return base == "" || path == base || strings.HasPrefix(path, base+"/")
+}
+
+// token returns the session token of the request being authenticated and
+// reports whether it was passed in the URL. A token in the URL wins over the
+// cookie, so a link with a rotated token replaces a stale cookie. The grant
+// of a preview link is only used when there is no session token.
+func (h *ForwardAuthHandler) token(c *gin.Context) (string, bool) {
+	if token := c.GetHeader(sessionTokenHeader); token != "" {
+		return token, false
+	}
+	if token := c.Query("token"); token != "" {
+		return token, true
+	}
+	if original, err := url.Parse(c.GetHeader(originalURLHeader)); err == nil {
+		if token := original.Query().Get("token"); token != "" {
+			return token, true
+		}
+	}
+	if token, err := c.Cookie(h.cookieName); err == nil && token != "" {
+		return token, false
+	}
+	if grant, err := c.Cookie(previewGrantCookie); err == nil && grant != "" {
+		return grant, false
+	}
+	return "", false
+}
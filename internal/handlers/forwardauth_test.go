@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+func newForwardAuthRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemorySessionRepository()
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, &models.Session{
+		UserID:      4,
+		ProjectID:   3,
+		ProjectUUID: testProjectUUID,
+		Namespace:   testProjectUUID,
+		TokenHash:   models.HashToken("tok"),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}, ""))
+	require.NoError(t, repo.Create(ctx, &models.Session{
+		ProjectUUID: "expired",
+		Namespace:   "expired",
+		TokenHash:   models.HashToken("expired"),
+		ExpiresAt:   time.Now().Add(-time.Hour),
+	}, ""))
+
+	h := NewForwardAuthHandler(zap.NewNop(), repo, nil, nil, "", true)
+	router := gin.New()
+	router.GET("/auth/verify", h.Verify)
+	return router
+}
+
+func verify(router *gin.Engine, target string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestForwardAuthHandler_Verify(t *testing.T) {
+	router := newForwardAuthRouter(t)
+	target := "/auth/verify?namespace=" + testProjectUUID
+
+	w := verify(router, target, http.Header{sessionTokenHeader: {"tok"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get("X-Session-Id"))
+	assert.Equal(t, "4", w.Header().Get("X-Session-User-Id"))
+	assert.Equal(t, "3", w.Header().Get("X-Session-Project-Id"))
+	assert.Equal(t, testProjectUUID, w.Header().Get("X-Session-Project-Uuid"))
+	assert.Empty(t, w.Header().Get("Set-Cookie"), "header tokens are not put in a cookie")
+
+	w = verify(router, target, http.Header{"Cookie": {defaultSessionCookie + "=tok"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = verify(router, target, http.Header{originalURLHeader: {"https://" + testProjectUUID + ".dev.example.com/vscode/?token=tok"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	cookie := w.Header().Get("Set-Cookie")
+	assert.Contains(t, cookie, defaultSessionCookie+"=tok")
+	assert.Contains(t, cookie, "HttpOnly")
+	assert.Contains(t, cookie, "Secure")
+
+	w = verify(router, target+"&token=tok", http.Header{"Cookie": {defaultSessionCookie + "=stale"}})
+	assert.Equal(t, http.StatusOK, w.Code, "a token in the URL wins over the cookie")
+}
+
+func TestForwardAuthHandler_Rejects(t *testing.T) {
+	router := newForwardAuthRouter(t)
+
+	w := verify(router, "/auth/verify?token=tok", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = verify(router, "/auth/verify?namespace="+testProjectUUID, nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = verify(router, "/auth/verify?namespace="+testProjectUUID+"&token=unknown", nil)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = verify(router, "/auth/verify?namespace=other&token=tok", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, "tokens only open their own session's Ingress")
+	assert.Empty(t, w.Header().Get("X-Session-Id"))
+
+	w = verify(router, "/auth/verify?namespace=expired&token=expired", nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
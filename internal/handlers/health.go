@@ -4,27 +4,42 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
 )
 
 // HealthHandler handles health check requests
-type HealthHandler struct{}
+type HealthHandler struct {
+	broker messaging.ConnectionState
+}
 
-// NewHealthHandler creates a new health handler
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+// NewHealthHandler creates a new health handler.
+// broker may be nil when RabbitMQ is not available.
+func NewHealthHandler(broker messaging.ConnectionState) *HealthHandler {
+	return &HealthHandler{broker: broker}
 }
 
 // Check godoc
 // @Summary Health check
-// @Description Check if the service is running
+// @Description Check if the service is running. The status is degraded while the RabbitMQ connection is being re-established.
 // @Tags health
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]interface{}
 // @Router /health [get]
 func (h *HealthHandler) Check(c *gin.Context) {
+	status := "ok"
+	rabbitmq := "disabled"
+	if h.broker != nil {
+		rabbitmq = "connected"
+		if !h.broker.Connected() {
+			rabbitmq = "disconnected"
+			status = "degraded"
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"status":  "ok",
-		"service": "paypilot_dev_session_service",
+		"status":   status,
+		"service":  "paypilot_dev_session_service",
+		"rabbitmq": rabbitmq,
 	})
 }
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+)
+
+// fakeConnectionState reports a fixed connection state
+type fakeConnectionState bool
+
+func (s fakeConnectionState) Connected() bool {
+	return bool(s)
+}
+
+func TestHealthHandler_Check(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		broker   messaging.ConnectionState
+		status   string
+		rabbitmq string
+	}{
+		{"without rabbitmq", nil, "ok", "disabled"},
+		{"connected", fakeConnectionState(true), "ok", "connected"},
+		{"reconnecting", fakeConnectionState(false), "degraded", "disconnected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/health", NewHealthHandler(tt.broker).Check)
+
+			w, body := serve(router, http.MethodGet, "/health")
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.status, body["status"])
+			assert.Equal(t, tt.rabbitmq, body["rabbitmq"])
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/previewlinks"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"go.uber.org/zap"
+)
+
+// PreviewLinkHandler creates, lists and revokes the shareable links to a
+// session's preview endpoint
+type PreviewLinkHandler struct {
+	log      *zap.Logger
+	sessions *sessions.Service
+	links    *previewlinks.Service
+}
+
+// NewPreviewLinkHandler creates a new preview link handler. Sessions are
+// loaded through svc, so callers only reach the links of their own
+// sessions. links is nil when no signing secret is configured, in which
+// case every request is answered with 503.
+func NewPreviewLinkHandler(log *zap.Logger, svc *sessions.Service, links *previewlinks.Service) *PreviewLinkHandler {
+	return &PreviewLinkHandler{
+		log:      log,
+		sessions: svc,
+		links:    links,
+	}
+}
+
+// createPreviewLinkRequest is the body of a preview link request
+type createPreviewLinkRequest struct {
+	ExpiresIn int    `json:"expires_in"` // Seconds the link is valid, the configured default when omitted
+	Password  string `json:"password"`
+	MaxUses   *int   `json:"max_uses"`
+}
+
+// createdPreviewLink is a preview link together with its token, which is
+// only returned when the link is created
+type createdPreviewLink struct {
+	PreviewLink *models.PreviewLink `json:"preview_link"`
+	Token       string              `json:"token"`
+	// URL opens the preview through the session Ingress, empty without one
+	URL string `json:"url"`
+	// ProxyPath opens the preview through the service's /s/ proxy
+	ProxyPath string `json:"proxy_path"`
+}
+
+// CreatePreviewLink godoc
+// @Summary Create a preview link
+// @Description Create a signed, time-limited link that opens the session's preview endpoint read-only (GET and HEAD) without a session token. The link may require a password, asked for with HTTP basic auth, and may be limited to a number of openings; a browser that opened it keeps access until the link expires or is revoked without using it up further. The token is only returned in this response.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param request body createPreviewLinkRequest false "Lifetime in seconds, password and maximum number of uses"
+// @Success 201 {object} createdPreviewLink
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/preview-links [post]
+func (h *PreviewLinkHandler) CreatePreviewLink(c *gin.Context) {
+	session, ok := h.session(c)
+	if !ok {
+		return
+	}
+
+	var req createPreviewLinkRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	link, token, err := h.links.Create(c.Request.Context(), session, previewlinks.Options{
+		TTL:      time.Duration(req.ExpiresIn) * time.Second,
+		Password: req.Password,
+		MaxUses:  req.MaxUses,
+	})
+	if err != nil {
+		h.respondError(c, err, "Failed to create preview link")
+		return
+	}
+
+	c.JSON(http.StatusCreated, createdPreviewLink{
+		PreviewLink: link,
+		Token:       token,
+		URL:         previewLinkURL(session.PreviewURL, token),
+		ProxyPath:   "/s/" + token + "/" + kubernetes.EndpointPreview + "/",
+	})
+}
+
+// ListPreviewLinks godoc
+// @Summary List a session's preview links
+// @Description List the preview links of a session, revoked and expired ones included, newest first. Link tokens are never returned.
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size" default(10)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/preview-links [get]
+func (h *PreviewLinkHandler) ListPreviewLinks(c *gin.Context) {
+	session, ok := h.session(c)
+	if !ok {
+		return
+	}
+
+	pageNumber, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	page := repository.NewPage(pageNumber, pageSize)
+
+	links, total, err := h.links.List(c.Request.Context(), session.ID, page)
+	if err != nil {
+		h.respondError(c, err, "Failed to list preview links")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"preview_links": links,
+		"pagination": gin.H{
+			"page":        page.Number,
+			"page_size":   page.Size,
+			"total":       total,
+			"total_pages": page.TotalPages(total),
+		},
+	})
+}
+
+// RevokePreviewLink godoc
+// @Summary Revoke a preview link
+// @Description Disable a preview link of a session. Browsers that already opened it lose access on their next request. It stays listed with revoked_at set.
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param link_id path int true "Preview link ID"
+// @Success 200 {object} models.PreviewLink
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/preview-links/{link_id} [delete]
+func (h *PreviewLinkHandler) RevokePreviewLink(c *gin.Context) {
+	linkID, err := strconv.ParseUint(c.Param("link_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid preview link ID"})
+		return
+	}
+
+	session, ok := h.session(c)
+	if !ok {
+		return
+	}
+
+	link, err := h.links.Revoke(c.Request.Context(), session.ID, uint(linkID))
+	if err != nil {
+		h.respondError(c, err, "Failed to revoke preview link")
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+// session loads the session in the path, answering the request itself when
+// preview links are disabled or the session cannot be loaded
+func (h *PreviewLinkHandler) session(c *gin.Context) (*models.Session, bool) {
+	if h.links == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Preview links are not configured"})
+		return nil, false
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return nil, false
+	}
+
+	session, err := h.sessions.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to load session")
+		return nil, false
+	}
+	return session, true
+}
+
+// respondError writes the response for an error returned by the session or
+// preview link service. Unexpected errors are reported with fallback.
+func (h *PreviewLinkHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, sessions.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	case errors.Is(err, previewlinks.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Preview link not found"})
+	case errors.Is(err, previewlinks.ErrInvalidRequest):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, previewlinks.ErrInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is no longer active"})
+	case errors.Is(err, previewlinks.ErrRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": "Preview link is already revoked"})
+	default:
+		h.log.Error(fallback, zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// readOnly reports whether a request with method and header only reads: GET
+// or HEAD, and not a WebSocket upgrade
+func readOnly(method string, header http.Header) bool {
+	return (method == http.MethodGet || method == http.MethodHead) && header.Get("Upgrade") == ""
+}
+
+// respondPreviewLinkError writes the response for a preview link that could
+// not be opened, on the proxy and forward-auth endpoints alike. It reports
+// whether err was such an error.
+func respondPreviewLinkError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, previewlinks.ErrInvalidLink):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid preview link"})
+	case errors.Is(err, previewlinks.ErrPasswordRequired):
+		c.Header("WWW-Authenticate", `Basic realm="Preview", charset="UTF-8"`)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Preview link password required"})
+	case errors.Is(err, previewlinks.ErrUnusable):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Preview link is revoked, expired or used up"})
+	case errors.Is(err, previewlinks.ErrOutOfScope):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Preview links only open the preview endpoint for reading"})
+	default:
+		return false
+	}
+	return true
+}
+
+// previewLinkURL adds a link token to a session's public preview URL
+func previewLinkURL(previewURL, token string) string {
+	if previewURL == "" {
+		return ""
+	}
+	u, err := url.Parse(previewURL)
+	if err != nil {
+		return ""
+	}
+	query := u.Query()
+	query.Set("token", token)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
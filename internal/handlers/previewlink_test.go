@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/previewlinks"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+// newPreviewLinkRouter serves the preview link, proxy and forward-auth
+// routes for one running session whose preview is served by upstream
+func newPreviewLinkRouter(t *testing.T, upstream *httptest.Server) *gin.Engine {
+	return newPreviewLinkRouterAt(t, upstream, "/preview")
+}
+
+// newPreviewLinkRouterAt is newPreviewLinkRouter for a session whose preview
+// is served at previewPath
+func newPreviewLinkRouterAt(t *testing.T, upstream *httptest.Server, previewPath string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemorySessionRepository()
+	require.NoError(t, repo.Create(context.Background(), &models.Session{
+		ProjectUUID: testProjectUUID,
+		Namespace:   testProjectUUID,
+		TokenHash:   models.HashToken("tok"),
+		Status:      models.StatusRunning,
+		ExpiresAt:   time.Now().Add(time.Hour),
+		PreviewURL:  "https://" + testProjectUUID + ".dev.example.com" + previewPath,
+		PreviewPath: previewPath,
+	}, ""))
+
+	rt := kubernetes.NewFakeRuntime()
+	_, err := rt.CreateDevContainer(context.Background(), testProjectUUID, 1, 2)
+	require.NoError(t, err)
+	rt.ServiceTargets = map[string]string{
+		kubernetes.EndpointPreview: upstream.URL,
+		kubernetes.EndpointVscode:  upstream.URL,
+	}
+
+	links, err := previewlinks.NewService(zap.NewNop(), repository.NewMemoryPreviewLinkRepository(), repo,
+		&config.PreviewLinksConfig{Secret: "secret"})
+	require.NoError(t, err)
+
+	h := NewPreviewLinkHandler(zap.NewNop(), sessions.NewService(zap.NewNop(), repo, nil, nil, nil), links)
+	proxy := NewProxyHandler(zap.NewNop(), repo, rt, nil, links)
+	forwardAuth := NewForwardAuthHandler(zap.NewNop(), repo, nil, links, "", true)
+
+	router := gin.New()
+	router.POST("/sessions/:id/preview-links", h.CreatePreviewLink)
+	router.GET("/sessions/:id/preview-links", h.ListPreviewLinks)
+	router.DELETE("/sessions/:id/preview-links/:link_id", h.RevokePreviewLink)
+	router.Any("/s/:token/:endpoint/*path", proxy.Proxy)
+	router.GET("/auth/verify", forwardAuth.Verify)
+	return router
+}
+
+// createPreviewLink creates a preview link for session 1 from body
+func createPreviewLink(t *testing.T, router *gin.Engine, body string) createdPreviewLink {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sessions/1/preview-links", strings.NewReader(body)))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var created createdPreviewLink
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	return created
+}
+
+func TestPreviewLinkHandler(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	router := newPreviewLinkRouter(t, upstream)
+
+	created := createPreviewLink(t, router, `{"expires_in": 600, "password": "hunter2", "max_uses": 3}`)
+	assert.True(t, strings.HasPrefix(created.Token, previewlinks.LinkPrefix))
+	assert.Equal(t, "https://"+testProjectUUID+".dev.example.com/preview?token="+created.Token, created.URL)
+	assert.Equal(t, "/s/"+created.Token+"/preview/", created.ProxyPath)
+
+	w, body := serve(router, http.MethodGet, "/sessions/1/preview-links")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, body["preview_links"], 1)
+	listed := body["preview_links"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, true, listed["password_protected"])
+	assert.EqualValues(t, 3, listed["max_uses"])
+	assert.NotContains(t, w.Body.String(), "password_hash")
+	assert.NotContains(t, w.Body.String(), created.Token)
+
+	w, _ = serve(router, http.MethodDelete, "/sessions/1/preview-links/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = serve(router, http.MethodDelete, "/sessions/1/preview-links/1")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w, _ = serve(router, http.MethodDelete, "/sessions/1/preview-links/2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = serve(router, http.MethodGet, "/sessions/2/preview-links")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sessions/1/preview-links", strings.NewReader(`{"max_uses": 0}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPreviewLinkHandler_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemorySessionRepository()
+	h := NewPreviewLinkHandler(zap.NewNop(), sessions.NewService(zap.NewNop(), repo, nil, nil, nil), nil)
+	router := gin.New()
+	router.GET("/sessions/:id/preview-links", h.ListPreviewLinks)
+
+	w, _ := serve(router, http.MethodGet, "/sessions/1/preview-links")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestProxyHandler_PreviewLink(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path+" "+r.Header.Get("Authorization"))
+	}))
+	defer upstream.Close()
+	router := newPreviewLinkRouter(t, upstream)
+	created := createPreviewLink(t, router, `{"password": "hunter2", "max_uses": 1}`)
+
+	// The reverse proxy needs a real connection
+	srv := httptest.NewServer(router)
+	defer srv.Close()
+	get := func(method, path string, password string, cookie *http.Cookie) (*http.Response, string) {
+		req, err := http.NewRequest(method, srv.URL+path, nil)
+		require.NoError(t, err)
+		if password != "" {
+			req.SetBasicAuth("", password)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	resp, _ := get(http.MethodGet, created.ProxyPath+"index.html", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+
+	resp, body := get(http.MethodGet, created.ProxyPath+"index.html", "hunter2", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/preview/index.html ", body, "the password is not passed to the dev container")
+	require.Len(t, resp.Cookies(), 1)
+	cookie := resp.Cookies()[0]
+	assert.Equal(t, previewGrantCookie, cookie.Name)
+	assert.Equal(t, "/s/"+created.Token+"/", cookie.Path)
+
+	// The only use is spent, but the grant keeps the page working
+	resp, _ = get(http.MethodGet, created.ProxyPath+"app.js", "", cookie)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = get(http.MethodGet, created.ProxyPath+"app.js", "hunter2", nil)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "a browser without the grant finds the link used up")
+
+	resp, _ = get(http.MethodPost, created.ProxyPath+"form", "", cookie)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "links are read-only")
+	resp, _ = get(http.MethodGet, "/s/"+created.Token+"/vscode/", "", cookie)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "links only open the preview")
+}
+
+func TestForwardAuthHandler_PreviewLink(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	router := newPreviewLinkRouter(t, upstream)
+	created := createPreviewLink(t, router, `{"max_uses": 1}`)
+	target := "/auth/verify?namespace=" + testProjectUUID
+	host := "https://" + testProjectUUID + ".dev.example.com"
+
+	w := verify(router, target, http.Header{originalURLHeader: {created.URL}, originalMethodHeader: {http.MethodGet}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get(sessionIDHeader))
+	assert.Equal(t, "1", w.Header().Get(previewLinkHeader))
+	assert.Empty(t, w.Header().Get(userIDHeader), "link visitors are not the session's user")
+	require.Len(t, w.Result().Cookies(), 1)
+	cookie := w.Result().Cookies()[0]
+	assert.Equal(t, previewGrantCookie, cookie.Name, "the owner's session cookie is left alone")
+	assert.True(t, strings.HasPrefix(cookie.Value, previewlinks.GrantPrefix))
+
+	// Later requests carry the grant in its cookie
+	grant := func(header http.Header) http.Header {
+		header.Set("Cookie", cookie.Name+"="+cookie.Value)
+		return header
+	}
+	w = verify(router, target, grant(http.Header{
+		originalURLHeader:    {host + "/preview/app.js"},
+		originalMethodHeader: {http.MethodGet},
+	}))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = verify(router, target, grant(http.Header{
+		originalURLHeader:    {host + "/vscode/"},
+		originalMethodHeader: {http.MethodGet},
+	}))
+	assert.Equal(t, http.StatusForbidden, w.Code, "links only open the preview path")
+
+	for _, escape := range []string{"/preview/../vscode/", "/preview/%2e%2e/vscode/", "/preview%2F..%2Fvscode/"} {
+		w = verify(router, target, grant(http.Header{
+			originalURLHeader:    {host + escape},
+			originalMethodHeader: {http.MethodGet},
+		}))
+		assert.Equal(t, http.StatusForbidden, w.Code, "dot segments do not leave the preview path: %s", escape)
+	}
+
+	w = verify(router, target, grant(http.Header{
+		originalURLHeader:    {host + "/preview/form"},
+		originalMethodHeader: {http.MethodPost},
+	}))
+	assert.Equal(t, http.StatusForbidden, w.Code, "links are read-only")
+
+	w = verify(router, target, grant(http.Header{originalURLHeader: {host + "/preview/"}}))
+	assert.Equal(t, http.StatusForbidden, w.Code, "requests of unknown method are refused")
+
+	w = verify(router, target, grant(http.Header{
+		originalURLHeader:    {host + "/preview/socket"},
+		originalMethodHeader: {http.MethodGet},
+		"Upgrade":            {"websocket"},
+	}))
+	assert.Equal(t, http.StatusForbidden, w.Code, "links do not open WebSockets")
+
+	w = verify(router, "/auth/verify?namespace=other", grant(http.Header{
+		originalURLHeader:    {host + "/preview/"},
+		originalMethodHeader: {http.MethodGet},
+	}))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = verify(router, target, http.Header{originalURLHeader: {created.URL}, originalMethodHeader: {http.MethodGet}})
+	assert.Equal(t, http.StatusForbidden, w.Code, "the link's only use is spent")
+}
+
+func TestForwardAuthHandler_PreviewLinkAtRoot(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+	router := newPreviewLinkRouterAt(t, upstream, "/")
+	created := createPreviewLink(t, router, `{}`)
+	target := "/auth/verify?namespace=" + testProjectUUID
+	host := "https://" + testProjectUUID + ".dev.example.com"
+
+	w := verify(router, target, http.Header{originalURLHeader: {created.URL}, originalMethodHeader: {http.MethodGet}})
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Len(t, w.Result().Cookies(), 1)
+	cookie := w.Result().Cookies()[0]
+
+	for path, want := range map[string]int{
+		"/":                http.StatusOK,
+		"/assets/app.js":   http.StatusOK,
+		"/vscode":          http.StatusForbidden,
+		"/vscode/":         http.StatusForbidden,
+		"/vscode/settings": http.StatusForbidden,
+		"/chat/history":    http.StatusForbidden,
+	} {
+		w = verify(router, target, http.Header{
+			originalURLHeader:    {host + path},
+			originalMethodHeader: {http.MethodGet},
+			"Cookie":             {cookie.Name + "=" + cookie.Value},
+		})
+		assert.Equal(t, want, w.Code, "a preview at the root does not open the other endpoints: %s", path)
+	}
+}
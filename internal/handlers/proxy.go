@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/hibernator"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/previewlinks"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+const (
+	// targetCacheTTL is how long a resolved service address is reused
+	targetCacheTTL = time.Minute
+	// proxyRetryAfter is the Retry-After value sent while a dev container starts
+	proxyRetryAfter = "5"
+	// previewGrantCookie keeps the grant of an opened preview link, scoped to
+	// the link's /s/<token>/ path on the proxy. On a session Ingress it is
+	// kept apart from the session cookie, so a link does not sign the
+	// session's owner out.
+	previewGrantCookie = "dev_preview_grant"
+)
+
+// cachedTarget is a resolved service address and when it must be looked up again
+type cachedTarget struct {
+	url     *url.URL
+	expires time.Time
+}
+
+// ProxyHandler reverse-proxies a session's preview, chat and VS Code
+// endpoints to the dev container services inside the cluster. Requests are
+// authenticated by the session token or preview link in the path.
+type ProxyHandler struct {
+	log        *zap.Logger
+	repo       repository.SessionRepository
+	runtime    kubernetes.Runtime
+	hibernator *hibernator.Hibernator
+	links      *previewlinks.Service
+
+	mu      sync.Mutex
+	targets map[string]cachedTarget
+}
+
+// NewProxyHandler creates a new proxy handler. Session tokens are looked up in repo.
+// runtime may be nil, in which case every request is answered with 503.
+// hib records activity and wakes hibernated sessions; it may be nil.
+// links checks preview links; it is nil when they are disabled.
+func NewProxyHandler(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, hib *hibernator.Hibernator, links *previewlinks.Service) *ProxyHandler {
+	return &ProxyHandler{
+		log:        log,
+		repo:       repo,
+		runtime:    runtime,
+		hibernator: hib,
+		links:      links,
+		targets:    make(map[string]cachedTarget),
+	}
+}
+
+// Proxy serves /s/:token/:endpoint/*path. HTTP requests and WebSocket
+// upgrades are forwarded to the session's endpoint service with the path
+// rewritten onto the endpoint's chart path, e.g. /s/<token>/vscode/static/x
+// becomes /vscode/static/x on the vscode service. A preview link in place of
+// the session token only opens the preview endpoint for reading.
+func (h *ProxyHandler) Proxy(c *gin.Context) {
+	if h.runtime == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Kubernetes integration is not available"})
+		return
+	}
+
+	endpoint := c.Param("endpoint")
+	if endpoint != kubernetes.EndpointPreview && endpoint != kubernetes.EndpointChat && endpoint != kubernetes.EndpointVscode {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown endpoint"})
+		return
+	}
+
+	token := c.Param("token")
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return
+	}
+
+	if previewlinks.IsCredential(token) {
+		h.previewLink(c, token, endpoint)
+		return
+	}
+
+	session, err := h.repo.GetByToken(c.Request.Context(), token)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return
+	}
+	if err != nil {
+		h.log.Error("Failed to load session", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session"})
+		return
+	}
+
+	if !session.IsActive || session.IsExpired() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Session has expired"})
+		return
+	}
+
+	h.forward(c, session, endpoint)
+}
+
+// previewLink forwards a request made with a preview link. The grant handed
+// out when the link is first opened is kept in a cookie under the link's
+// path, so the page's other requests do not count as uses.
+func (h *ProxyHandler) previewLink(c *gin.Context, token, endpoint string) {
+	if h.links == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid session token"})
+		return
+	}
+	if endpoint != kubernetes.EndpointPreview || !readOnly(c.Request.Method, c.Request.Header) {
+		respondPreviewLinkError(c, previewlinks.ErrOutOfScope)
+		return
+	}
+
+	grant, _ := c.Cookie(previewGrantCookie)
+	_, password, _ := c.Request.BasicAuth()
+	access, err := h.links.Open(c.Request.Context(), token, grant, password, nil)
+	if err != nil {
+		if !respondPreviewLinkError(c, err) {
+			h.log.Error("Failed to open preview link", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open preview link"})
+		}
+		return
+	}
+
+	if access.Grant != "" {
+		secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(previewGrantCookie, access.Grant, int(time.Until(access.Link.ExpiresAt).Seconds()),
+			"/s/"+token+"/", "", secure, true)
+	}
+
+	// The link's password is meant for this service, not the dev container
+	c.Request.Header.Del("Authorization")
+	h.forward(c, access.Session, endpoint)
+}
+
+// forward proxies the request to an authenticated session's endpoint
+func (h *ProxyHandler) forward(c *gin.Context, session *models.Session, endpoint string) {
+	if h.hibernator != nil {
+		h.hibernator.Touch(c.Request.Context(), session)
+		if session.Status == models.StatusHibernated {
+			if err := h.hibernator.Wake(c.Request.Context(), session); err != nil {
+				h.log.Error("Failed to wake session", zap.Uint("session_id", session.ID), zap.Error(err))
+			}
+		}
+	}
+
+	switch session.Status {
+	case models.StatusRunning:
+	case models.StatusPending, models.StatusProvisioning, models.StatusWaking, models.StatusHibernated:
+		c.Header("Retry-After", proxyRetryAfter)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Dev container is starting", "status": session.Status})
+		return
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": "Dev container is not running", "status": session.Status})
+		return
+	}
+
+	target, err := h.target(c.Request.Context(), session, endpoint)
+	if err != nil {
+		h.log.Error("Failed to resolve dev container service",
+			zap.Uint("session_id", session.ID),
+			zap.String("endpoint", endpoint),
+			zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Dev container endpoint is unavailable"})
+		return
+	}
+
+	basePath := endpointPath(session, endpoint)
+	trimmedBase := strings.TrimSuffix(basePath, "/")
+	publicPrefix := strings.TrimSuffix(strings.TrimSuffix(c.Request.URL.Path, c.Param("path")), "/")
+	cacheKey := targetKey(session, endpoint)
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Scheme = target.Scheme
+			pr.Out.URL.Host = target.Host
+			pr.Out.URL.Path = upstreamPath(basePath, c.Param("path"))
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = target.Host
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			// Keep redirects issued by the dev container behind the proxy
+			location := resp.Header.Get("Location")
+			if location == trimmedBase || strings.HasPrefix(location, trimmedBase+"/") {
+				resp.Header.Set("Location", publicPrefix+strings.TrimPrefix(location, trimmedBase))
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			h.log.Warn("Dev container proxy request failed",
+				zap.Uint("session_id", session.ID),
+				zap.String("endpoint", endpoint),
+				zap.Error(err))
+			// The service may have been recreated, resolve it again next time
+			h.forget(cacheKey)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// target returns the base URL of the session's endpoint service, using the cache when possible
+func (h *ProxyHandler) target(ctx context.Context, session *models.Session, endpoint string) (*url.URL, error) {
+	key := targetKey(session, endpoint)
+
+	h.mu.Lock()
+	cached, ok := h.targets[key]
+	h.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.url, nil
+	}
+
+	namespace := session.Namespace
+	if namespace == "" {
+		namespace = session.ProjectUUID
+	}
+
+	raw, err := h.runtime.GetServiceTarget(ctx, namespace, kubernetes.ReleaseName(session.ProjectUUID), endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service address %q: %w", raw, err)
+	}
+
+	h.mu.Lock()
+	h.targets[key] = cachedTarget{url: target, expires: time.Now().Add(targetCacheTTL)}
+	h.mu.Unlock()
+
+	return target, nil
+}
+
+// forget drops a cached service address
+func (h *ProxyHandler) forget(key string) {
+	h.mu.Lock()
+	delete(h.targets, key)
+	h.mu.Unlock()
+}
+
+// targetKey identifies a session endpoint in the target cache
+func targetKey(session *models.Session, endpoint string) string {
+	return session.ProjectUUID + "/" + endpoint
+}
+
+// endpointPath returns the chart path (service.<endpoint>.path) the endpoint is served under
+func endpointPath(session *models.Session, endpoint string) string {
+	var path string
+	switch endpoint {
+	case kubernetes.EndpointPreview:
+		path = session.PreviewPath
+	case kubernetes.EndpointChat:
+		path = session.ChatPath
+	case kubernetes.EndpointVscode:
+		path = session.VscodePath
+	}
+
+	if path == "" {
+		path = "/" + endpoint
+	}
+	return path
+}
+
+// upstreamPath joins the endpoint's base path with the remainder of the request path
+func upstreamPath(basePath string, rest string) string {
+	if rest == "" {
+		rest = "/"
+	}
+	return strings.TrimSuffix(basePath, "/") + rest
+}
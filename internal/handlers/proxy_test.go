@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+const testProjectUUID = "550e8400-e29b-41d4-a716-446655440000"
+
+// newProxyTestServer starts a gin server whose proxy route forwards to
+// upstream for the given session, skipping the token lookup
+func newProxyTestServer(t *testing.T, upstream *httptest.Server, session *models.Session) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+
+	rt := kubernetes.NewFakeRuntime()
+	_, err := rt.CreateDevContainer(context.Background(), testProjectUUID, 1, 2)
+	require.NoError(t, err)
+	rt.ServiceTargets = map[string]string{
+		kubernetes.EndpointPreview: upstream.URL,
+		kubernetes.EndpointVscode:  upstream.URL,
+	}
+
+	h := NewProxyHandler(zap.NewNop(), repository.NewMemorySessionRepository(), rt, nil, nil)
+	router := gin.New()
+	router.Any("/s/:token/:endpoint/*path", func(c *gin.Context) {
+		s := *session
+		h.forward(c, &s, c.Param("endpoint"))
+	})
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestProxyHandler_RewritesPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/preview/old" {
+			http.Redirect(w, r, "/preview/new", http.StatusFound)
+			return
+		}
+		fmt.Fprintf(w, "%s?%s", r.URL.Path, r.URL.RawQuery)
+	}))
+	defer upstream.Close()
+
+	session := &models.Session{ID: 1, ProjectUUID: testProjectUUID, Namespace: testProjectUUID, Status: "running", PreviewPath: "/preview"}
+	srv := newProxyTestServer(t, upstream, session)
+
+	resp, err := http.Get(srv.URL + "/s/tok/preview/app/index.html?v=1")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/preview/app/index.html?v=1", string(body))
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err = client.Get(srv.URL + "/s/tok/preview/old")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "/s/tok/preview/new", resp.Header.Get("Location"))
+}
+
+func TestProxyHandler_NotRunning(t *testing.T) {
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	defer upstream.Close()
+
+	session := &models.Session{ID: 1, ProjectUUID: testProjectUUID, Namespace: testProjectUUID, Status: "waking"}
+	srv := newProxyTestServer(t, upstream, session)
+
+	resp, err := http.Get(srv.URL + "/s/tok/preview/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, proxyRetryAfter, resp.Header.Get("Retry-After"))
+}
+
+func TestProxyHandler_WebSocketUpgrade(t *testing.T) {
+	// A minimal upgrade handler that echoes whatever it receives after the handshake
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "websocket" || r.URL.Path != "/vscode/socket" {
+			http.Error(w, "expected upgrade", http.StatusBadRequest)
+			return
+		}
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	defer upstream.Close()
+
+	session := &models.Session{ID: 1, ProjectUUID: testProjectUUID, Namespace: testProjectUUID, Status: "running", VscodePath: "/vscode"}
+	srv := newProxyTestServer(t, upstream, session)
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET /s/tok/vscode/socket HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n", srv.Listener.Addr())
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	echo := make([]byte, 4)
+	_, err = io.ReadFull(reader, echo)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(echo))
+}
+
+func TestUpstreamPath(t *testing.T) {
+	assert.Equal(t, "/preview/", upstreamPath("/preview", ""))
+	assert.Equal(t, "/preview/", upstreamPath("/preview/", "/"))
+	assert.Equal(t, "/chat/api/messages", upstreamPath("/chat", "/api/messages"))
+}
+
+func TestEndpointPath(t *testing.T) {
+	session := &models.Session{ChatPath: "/agents"}
+	assert.Equal(t, "/agents", endpointPath(session, kubernetes.EndpointChat))
+	assert.Equal(t, "/vscode", endpointPath(session, kubernetes.EndpointVscode))
+}
+
+func TestProxyHandler_Token(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	repo := repository.NewMemorySessionRepository()
+	expired := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("expired"), ExpiresAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, repo.Create(context.Background(), expired, ""))
+
+	h := NewProxyHandler(zap.NewNop(), repo, kubernetes.NewFakeRuntime(), nil, nil)
+	router := gin.New()
+	router.Any("/s/:token/:endpoint/*path", h.Proxy)
+
+	w, _ := serve(router, http.MethodGet, "/s/unknown/preview/")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w, _ = serve(router, http.MethodGet, "/s/expired/preview/")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
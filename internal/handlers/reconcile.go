@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/reconciler"
+	"go.uber.org/zap"
+)
+
+// ReconcileHandler runs reconciliation between sessions and Helm releases on demand
+type ReconcileHandler struct {
+	log        *zap.Logger
+	reconciler *reconciler.Reconciler
+}
+
+// NewReconcileHandler creates a new reconcile handler.
+// rec may be nil when the Kubernetes integration is disabled.
+func NewReconcileHandler(log *zap.Logger, rec *reconciler.Reconciler) *ReconcileHandler {
+	return &ReconcileHandler{
+		log:        log,
+		reconciler: rec,
+	}
+}
+
+// Reconcile godoc
+// @Summary Reconcile sessions with dev container releases
+// @Description Compare active session rows with live Helm releases, flag missing, failed and orphaned releases and repair them according to the reconciler policies. With dry_run (the default) the report lists the planned actions without applying them.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param dry_run query bool false "Only report findings" default(true)
+// @Success 200 {object} reconciler.Report
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/reconcile [post]
+func (h *ReconcileHandler) Reconcile(c *gin.Context) {
+	if h.reconciler == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Kubernetes integration is not available"})
+		return
+	}
+
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid dry_run parameter"})
+		return
+	}
+
+	report, err := h.reconciler.Reconcile(c.Request.Context(), dryRun)
+	if err != nil {
+		h.log.Error("Failed to reconcile sessions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -1,48 +1,62 @@
 package handlers
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"github.com/villageFlower/paypilot_dev_session_service/internal/database"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/hibernator"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
 	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
 	"go.uber.org/zap"
 )
 
 const (
 	// AlwaysOnSessionDuration defines how long always-on sessions remain valid (1 year)
-	AlwaysOnSessionDuration = 24 * 365 * time.Hour
+	AlwaysOnSessionDuration = models.AlwaysOnSessionDuration
 )
 
 // SessionHandler handles session-related requests
 type SessionHandler struct {
-	log       *zap.Logger
-	k8sClient *kubernetes.Client
+	log        *zap.Logger
+	repo       repository.SessionRepository
+	sessions   *sessions.Service
+	hibernator *hibernator.Hibernator
 }
 
-// NewSessionHandler creates a new session handler
-func NewSessionHandler(log *zap.Logger, k8sClient *kubernetes.Client) *SessionHandler {
+// NewSessionHandler creates a new session handler. Sessions are read from
+// repo and changed through svc. hib records activity and wakes hibernated
+// sessions; it is nil without a runtime.
+func NewSessionHandler(log *zap.Logger, repo repository.SessionRepository, svc *sessions.Service, hib *hibernator.Hibernator) *SessionHandler {
 	return &SessionHandler{
-		log:       log,
-		k8sClient: k8sClient,
+		log:        log,
+		repo:       repo,
+		sessions:   svc,
+		hibernator: hib,
 	}
 }
 
 // CreateSession godoc
 // @Summary Create a new development session
-// @Description Create a new dev session for a project in the no-code app generator
+// @Description Create a new dev session for a project in the no-code app generator. The response carries the session's access token, which is generated by the service and not shown again; a token in the body is ignored. Every session starts in "pending" status, a status in the body is ignored. When a dev container has to be provisioned the session is returned with 202 and moves through "provisioning" to "running" or "error" once provisioning finishes. Callers without the admin role create sessions for themselves; user_id defaults to the caller and may not name another user.
 // @Tags sessions
 // @Accept json
 // @Produce json
 // @Param session body models.Session true "Session information"
 // @Success 201 {object} models.Session
+// @Success 202 {object} models.Session "Session accepted, dev container is being provisioned"
 // @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /sessions [post]
 func (h *SessionHandler) CreateSession(c *gin.Context) {
 	var session models.Session
@@ -51,71 +65,47 @@ func (h *SessionHandler) CreateSession(c *gin.Context) {
 		return
 	}
 
-	// Generate token if not provided
-	if session.Token == "" {
-		session.Token = uuid.New().String()
+	var requested *int
+	if session.UserID != 0 {
+		requested = &session.UserID
 	}
-
-	// Set expiration time if not provided (1 year for always-on sessions)
-	if session.ExpiresAt.IsZero() {
-		session.ExpiresAt = time.Now().Add(AlwaysOnSessionDuration)
+	userID, ok := scopeUserID(c, requested)
+	if !ok {
+		return
+	}
+	if userID != nil {
+		session.UserID = *userID
 	}
 
 	// Set IP address and user agent from request
 	session.IPAddress = c.ClientIP()
 	session.UserAgent = c.Request.UserAgent()
 
-	// Set namespace from project UUID if not set
-	if session.Namespace == "" && session.ProjectUUID != "" {
-		session.Namespace = session.ProjectUUID
-	}
-
-	// Set default status if not provided
-	if session.Status == "" {
-		session.Status = "pending"
-	}
-
-	// Create the dev container in Kubernetes if k8s client is available
-	if h.k8sClient != nil && session.ProjectUUID != "" {
-		ctx := context.Background()
-		endpoints, err := h.k8sClient.CreateDevContainer(ctx, session.ProjectUUID, session.ProjectID, session.UserID)
-		if err != nil {
-			h.log.Error("Failed to create dev container", zap.Error(err))
-			session.Status = "error"
-		} else {
-			session.Status = "running"
-			session.ContainerName = "dev-session-" + session.ProjectUUID
-			// Populate service endpoints
-			if endpoints != nil {
-				session.IPAddress = endpoints.ClusterIP
-				session.PreviewURL = endpoints.PreviewURL
-				session.PreviewPath = endpoints.PreviewPath
-				session.ChatURL = endpoints.ChatURL
-				session.ChatPath = endpoints.ChatPath
-				session.VscodeURL = endpoints.VscodeURL
-				session.VscodePath = endpoints.VscodePath
-			}
-		}
+	provisioning, err := h.sessions.Create(c.Request.Context(), &session)
+	if err != nil {
+		h.respondError(c, err, "Failed to create session")
+		return
 	}
 
-	if err := database.DB.Create(&session).Error; err != nil {
-		h.log.Error("Failed to create session", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+	if !provisioning {
+		c.JSON(http.StatusCreated, session)
 		return
 	}
 
-	c.JSON(http.StatusCreated, session)
+	c.JSON(http.StatusAccepted, session)
 }
 
 // GetSession godoc
 // @Summary Get a dev session by ID
-// @Description Get dev session details by session ID
+// @Description Get dev session details by session ID. The access token is not included; rotate it to get a new one.
 // @Tags sessions
 // @Accept json
 // @Produce json
 // @Param id path int true "Session ID"
 // @Success 200 {object} models.Session
+// @Failure 401 {object} map[string]interface{}
 // @Failure 404 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /sessions/{id} [get]
 func (h *SessionHandler) GetSession(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -124,9 +114,9 @@ func (h *SessionHandler) GetSession(c *gin.Context) {
 		return
 	}
 
-	var session models.Session
-	if err := database.DB.First(&session, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	session, err := h.sessions.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to load session")
 		return
 	}
 
@@ -135,70 +125,250 @@ func (h *SessionHandler) GetSession(c *gin.Context) {
 
 // ListSessions godoc
 // @Summary List all dev sessions
-// @Description Get a list of all dev sessions with optional filtering
+// @Description Get a list of all dev sessions with optional filtering. Callers without the admin role only see their own sessions.
 // @Tags sessions
 // @Accept json
 // @Produce json
 // @Param user_id query int false "Filter by user ID"
 // @Param project_id query int false "Filter by project ID"
-// @Param status query string false "Filter by status (pending, running, stopped, error)"
+// @Param status query string false "Filter by status (pending, provisioning, running, stopping, stopped, hibernated, waking, error)"
 // @Param page query int false "Page number" default(1)
 // @Param page_size query int false "Page size" default(10)
 // @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /sessions [get]
 func (h *SessionHandler) ListSessions(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageNumber, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
-	userID := c.Query("user_id")
-	projectID := c.Query("project_id")
-	status := c.Query("status")
+	page := repository.NewPage(pageNumber, pageSize)
 
-	if page < 1 {
-		page = 1
+	userID, ok := optionalIntQuery(c, "user_id")
+	if !ok {
+		return
 	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 10
+	userID, ok = scopeUserID(c, userID)
+	if !ok {
+		return
+	}
+	projectID, ok := optionalIntQuery(c, "project_id")
+	if !ok {
+		return
 	}
 
-	offset := (page - 1) * pageSize
+	status := models.SessionStatus(c.Query("status"))
+	if status != "" && !status.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
 
-	query := database.DB.Model(&models.Session{})
-	if userID != "" {
-		query = query.Where("user_id = ?", userID)
+	filter := repository.SessionFilter{
+		UserID:    userID,
+		ProjectID: projectID,
+		Status:    status,
 	}
-	if projectID != "" {
-		query = query.Where("project_id = ?", projectID)
+
+	sessions, total, err := h.repo.List(c.Request.Context(), filter, page)
+	if err != nil {
+		h.respondError(c, err, "Failed to list sessions")
+		return
 	}
-	if status != "" {
-		query = query.Where("status = ?", status)
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions": sessions,
+		"pagination": gin.H{
+			"page":        page.Number,
+			"page_size":   page.Size,
+			"total":       total,
+			"total_pages": page.TotalPages(total),
+		},
+	})
+}
+
+// GetSessionHistory godoc
+// @Summary Get a dev session's status history
+// @Description Get every status change of a session, newest first, with the reason and the actor that made it
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size" default(10)
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/history [get]
+func (h *SessionHandler) GetSessionHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
 	}
 
-	var sessions []models.Session
-	var total int64
+	pageNumber, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	page := repository.NewPage(pageNumber, pageSize)
 
-	query.Count(&total)
-	query.Limit(pageSize).Offset(offset).Find(&sessions)
+	if _, err := h.sessions.Get(c.Request.Context(), uint(id)); err != nil {
+		h.respondError(c, err, "Failed to load session")
+		return
+	}
+
+	history, total, err := h.repo.StatusHistory(c.Request.Context(), uint(id), page)
+	if err != nil {
+		h.respondError(c, err, "Failed to load session history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"history": history,
+		"pagination": gin.H{
+			"page":        page.Number,
+			"page_size":   page.Size,
+			"total":       total,
+			"total_pages": page.TotalPages(total),
+		},
+	})
+}
+
+// projectSession is a session in a project's history, including when it was deleted
+type projectSession struct {
+	models.Session
+	DeletedAt *time.Time `json:"deleted_at"`
+}
+
+// ListProjectSessions godoc
+// @Summary List a project's session history
+// @Description Get every session a project has had, deleted ones included, newest first. Deleted sessions carry their deletion time in deleted_at. Callers without the admin role only see their own sessions.
+// @Tags sessions
+// @Produce json
+// @Param project_uuid path string true "Project UUID"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size" default(10)
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /projects/{project_uuid}/sessions [get]
+func (h *SessionHandler) ListProjectSessions(c *gin.Context) {
+	pageNumber, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "10"))
+	page := repository.NewPage(pageNumber, pageSize)
+
+	userID, ok := scopeUserID(c, nil)
+	if !ok {
+		return
+	}
+
+	history, total, err := h.repo.History(c.Request.Context(), c.Param("project_uuid"), userID, page)
+	if err != nil {
+		h.respondError(c, err, "Failed to list sessions")
+		return
+	}
+
+	sessions := make([]projectSession, 0, len(history))
+	for _, session := range history {
+		entry := projectSession{Session: session}
+		if session.DeletedAt.Valid {
+			entry.DeletedAt = &session.DeletedAt.Time
+		}
+		sessions = append(sessions, entry)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"sessions": sessions,
 		"pagination": gin.H{
-			"page":        page,
-			"page_size":   pageSize,
+			"page":        page.Number,
+			"page_size":   page.Size,
 			"total":       total,
-			"total_pages": (total + int64(pageSize) - 1) / int64(pageSize),
+			"total_pages": page.TotalPages(total),
 		},
 	})
 }
 
+// RestoreSession godoc
+// @Summary Restore a deleted dev session
+// @Description Undelete a soft-deleted session. Its dev container was removed on deletion, so a new one is provisioned when Kubernetes integration is available and the session is returned in "pending" status.
+// @Tags admin
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.Session
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /admin/sessions/{id}/restore [post]
+func (h *SessionHandler) RestoreSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.sessions.Restore(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to restore session")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// optionalIntQuery parses an optional integer query parameter. If it is not
+// a number a 400 response is written and false is returned.
+func optionalIntQuery(c *gin.Context, name string) (*int, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, true
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+		return nil, false
+	}
+	return &parsed, true
+}
+
+// scopeUserID returns the user whose sessions a request may list or
+// create, given the user it asked for. Users without the admin role are
+// limited to themselves; if they asked for another user a 403 response is
+// written and false is returned. Admins, services calling with an API key
+// and requests served without authentication keep requested.
+func scopeUserID(c *gin.Context, requested *int) (*int, bool) {
+	principal, ok := auth.FromContext(c.Request.Context())
+	if !ok || principal.AllUsers() {
+		return requested, true
+	}
+
+	if requested != nil && *requested != principal.UserID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Sessions of other users are not accessible"})
+		return nil, false
+	}
+	return &principal.UserID, true
+}
+
 // DeleteSession godoc
 // @Summary Delete a dev session
-// @Description Delete a dev session by ID (also stops the associated container)
+// @Description Delete a dev session by ID (also removes the associated container). If the container cannot be removed, the session is kept in stopping and the delete can be retried.
 // @Tags sessions
 // @Accept json
 // @Produce json
 // @Param id path int true "Session ID"
 // @Success 204
+// @Failure 401 {object} map[string]interface{}
 // @Failure 404 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /sessions/{id} [delete]
 func (h *SessionHandler) DeleteSession(c *gin.Context) {
 	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
@@ -207,33 +377,276 @@ func (h *SessionHandler) DeleteSession(c *gin.Context) {
 		return
 	}
 
-	var session models.Session
-	if err := database.DB.First(&session, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	if _, err := h.sessions.Delete(c.Request.Context(), uint(id)); err != nil {
+		h.respondError(c, err, "Failed to delete session")
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// StopSession godoc
+// @Summary Stop a dev session
+// @Description Scale the session's dev container to zero. The workspace volume is kept so the session can be started again.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.Session
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/stop [post]
+func (h *SessionHandler) StopSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
 		return
 	}
 
-	// Delete from Kubernetes if k8s client is available
-	if h.k8sClient != nil && session.ProjectUUID != "" {
-		ctx := context.Background()
-		if err := h.k8sClient.DeleteDevContainer(ctx, session.ProjectUUID); err != nil {
-			h.log.Error("Failed to delete dev container from Kubernetes", zap.Error(err))
-			// Continue with DB deletion even if K8s deletion fails
+	session, err := h.sessions.Stop(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to stop dev container")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// StartSession godoc
+// @Summary Start a stopped dev session
+// @Description Scale the dev container of a stopped or errored session back up, wait for it to become ready and refresh its endpoints. Other sessions are answered with 409; hibernated sessions wake when one of their endpoints is opened.
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.Session
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/start [post]
+func (h *SessionHandler) StartSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.sessions.Start(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to start dev container")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// RestartSession godoc
+// @Summary Restart a dev session
+// @Description Replace the session's dev container pod and wait for the new pod to become ready
+// @Tags sessions
+// @Accept json
+// @Produce json
+// @Param id path int true "Session ID"
+// @Success 200 {object} models.Session
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/restart [post]
+func (h *SessionHandler) RestartSession(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.sessions.Restart(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to restart dev container")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// RotateSessionToken godoc
+// @Summary Rotate a dev session's access token
+// @Description Give the session a new access token for the dev container proxy. The new token is only returned in this response. The replaced token keeps working for overlap_seconds (at most 86400) so clients can switch over, and stops working at once without it; a token replaced by an earlier rotation always stops working.
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param overlap_seconds query int false "Seconds the replaced token keeps working" default(0)
+// @Success 200 {object} models.Session
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 500 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/token/rotate [post]
+func (h *SessionHandler) RotateSessionToken(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	overlap, err := strconv.Atoi(c.DefaultQuery("overlap_seconds", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid overlap_seconds"})
+		return
+	}
+
+	session, err := h.sessions.RotateToken(c.Request.Context(), uint(id), time.Duration(overlap)*time.Second)
+	if err != nil {
+		h.respondError(c, err, "Failed to rotate session token")
+		return
+	}
+
+	c.JSON(http.StatusOK, session)
+}
+
+// OpenEndpoint godoc
+// @Summary Open a dev session endpoint
+// @Description Record activity on the session and redirect to its preview, chat or vscode endpoint. A hibernated session is woken up and reported as "waking" with 202 until its dev container is running again.
+// @Tags sessions
+// @Produce json
+// @Param id path int true "Session ID"
+// @Param endpoint path string true "Endpoint (preview, chat, vscode)"
+// @Success 202 {object} models.Session "Session is waking up, retry after the Retry-After interval"
+// @Success 302
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
+// @Router /sessions/{id}/open/{endpoint} [get]
+func (h *SessionHandler) OpenEndpoint(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	session, err := h.sessions.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		h.respondError(c, err, "Failed to load session")
+		return
+	}
+
+	var target string
+	switch c.Param("endpoint") {
+	case "preview":
+		target = session.PreviewURL
+	case "chat":
+		target = session.ChatURL
+	case "vscode":
+		target = session.VscodeURL
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Endpoint must be one of preview, chat, vscode"})
+		return
+	}
+
+	if !session.IsActive || session.IsExpired() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is no longer active"})
+		return
+	}
+
+	if h.hibernator != nil {
+		h.hibernator.Touch(c.Request.Context(), session)
+		if !h.wakeIfHibernated(c, session) {
+			return
 		}
 	}
 
-	if err := database.DB.Delete(&session).Error; err != nil {
-		h.log.Error("Failed to delete session", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete session"})
+	if session.Status == models.StatusWaking {
+		c.Header("Retry-After", "5")
+		c.JSON(http.StatusAccepted, session)
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	if target == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Endpoint is not available yet"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, target)
+}
+
+// wakeIfHibernated starts waking a hibernated session. If the wake-up
+// cannot be queued an error response is written and false is returned.
+func (h *SessionHandler) wakeIfHibernated(c *gin.Context, session *models.Session) bool {
+	if session.Status != models.StatusHibernated {
+		return true
+	}
+
+	if err := h.hibernator.Wake(c.Request.Context(), session); err != nil {
+		h.log.Error("Failed to wake session", zap.Uint("session_id", session.ID), zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session could not be woken up, please retry later"})
+		return false
+	}
+
+	return true
+}
+
+// respondError writes the response for an error returned by the session
+// service. Unexpected errors are reported with fallback.
+func (h *SessionHandler) respondError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, sessions.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+	case errors.Is(err, kubernetes.ErrReleaseNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dev container not found"})
+	case errors.Is(err, sessions.ErrNoDevContainer):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Session has no dev container"})
+	case errors.Is(err, sessions.ErrInvalidOverlap):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "overlap_seconds must be between 0 and 86400"})
+	case errors.Is(err, sessions.ErrExists):
+		c.JSON(http.StatusConflict, gin.H{"error": "A session already exists for this project"})
+	case errors.Is(err, sessions.ErrInactive):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is no longer active"})
+	case errors.Is(err, sessions.ErrProvisioning):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is still being provisioned"})
+	case errors.Is(err, sessions.ErrWaking):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is waking up"})
+	case errors.Is(err, sessions.ErrStopping):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is stopping"})
+	case errors.Is(err, models.ErrInvalidTransition), errors.Is(err, repository.ErrStaleStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session status does not allow this operation"})
+	case errors.Is(err, repository.ErrNotDeleted):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is not deleted"})
+	case errors.Is(err, sessions.ErrStopped):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is stopped, start it instead"})
+	case errors.Is(err, sessions.ErrNotStopped):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is not stopped"})
+	case errors.Is(err, sessions.ErrHibernated):
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is hibernated, open it to wake it"})
+	case errors.Is(err, sessions.ErrRuntimeUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Kubernetes integration is not available"})
+	case errors.Is(err, sessions.ErrUnavailable):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Session provisioning is unavailable, please retry later"})
+	default:
+		h.log.Error(fallback, zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
 }
 
 // GetOrCreateSessionByProjectUUID godoc
 // @Summary Get or create a dev session by project UUID
-// @Description Get an existing session for a project UUID, or create a new one if it doesn't exist
+// @Description Get an existing session for a project UUID, or create a new one if it doesn't exist. An expired session is torn down and renewed with a new token. The access token is only included when the session is created or renewed. Concurrent requests for the same project are serialized across replicas and all return the same session. Callers without the admin role get or create sessions for themselves; a project whose session belongs to another user is answered with 409.
 // @Tags sessions
 // @Accept json
 // @Produce json
@@ -241,8 +654,14 @@ func (h *SessionHandler) DeleteSession(c *gin.Context) {
 // @Param user_id query int false "User ID"
 // @Param project_id query int false "Project ID"
 // @Success 200 {object} models.Session
+// @Success 202 {object} models.Session "Session created or waking up, dev container is being provisioned or started"
 // @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Failure 403 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
 // @Failure 500 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Security BearerAuth
 // @Router /sessions/project/{project_uuid} [get]
 func (h *SessionHandler) GetOrCreateSessionByProjectUUID(c *gin.Context) {
 	projectUUID := c.Param("project_uuid")
@@ -251,31 +670,25 @@ func (h *SessionHandler) GetOrCreateSessionByProjectUUID(c *gin.Context) {
 		return
 	}
 
-	// Try to find existing session
-	var session models.Session
-	err := database.DB.Where("project_uuid = ? AND is_active = ?", projectUUID, true).First(&session).Error
-
-	if err == nil {
-		// Session exists, return it
-		h.log.Info("Found existing session", zap.String("project_uuid", projectUUID))
-		c.JSON(http.StatusOK, session)
-		return
-	}
-
-	// Session doesn't exist, create a new one
-	h.log.Info("Creating new session for project", zap.String("project_uuid", projectUUID))
-
 	// Get user_id and project_id from query params or use defaults
 	userID := 0
 	projectID := 0
 
+	var requested *int
 	if userIDStr := c.Query("user_id"); userIDStr != "" {
 		if parsed, err := strconv.Atoi(userIDStr); err == nil {
-			userID = parsed
+			requested = &parsed
 		} else {
 			h.log.Warn("Invalid user_id parameter", zap.String("value", userIDStr), zap.Error(err))
 		}
 	}
+	scoped, ok := scopeUserID(c, requested)
+	if !ok {
+		return
+	}
+	if scoped != nil {
+		userID = *scoped
+	}
 
 	if projectIDStr := c.Query("project_id"); projectIDStr != "" {
 		if parsed, err := strconv.Atoi(projectIDStr); err == nil {
@@ -285,53 +698,44 @@ func (h *SessionHandler) GetOrCreateSessionByProjectUUID(c *gin.Context) {
 		}
 	}
 
-	// Create new session
-	session = models.Session{
+	// Used when the project has no session yet
+	template := &models.Session{
 		UserID:      userID,
 		ProjectID:   projectID,
 		ProjectUUID: projectUUID,
-		Token:       uuid.New().String(),
-		ExpiresAt:   time.Now().Add(AlwaysOnSessionDuration),
-		Namespace:   projectUUID, // Use project UUID as namespace
-		Status:      "pending",
 		IPAddress:   c.ClientIP(),
 		UserAgent:   c.Request.UserAgent(),
 		IsActive:    true,
 	}
 
-	// Create the dev container in Kubernetes if k8s client is available
-	if h.k8sClient != nil {
-		ctx := context.Background()
-		endpoints, err := h.k8sClient.CreateDevContainer(ctx, projectUUID, projectID, userID)
-		if err != nil {
-			h.log.Error("Failed to create dev container", zap.Error(err))
-			session.Status = "error"
-		} else {
-			session.Status = "running"
-			session.ContainerName = "dev-session-" + projectUUID
-			// Populate service endpoints
-			if endpoints != nil {
-				session.IPAddress = endpoints.ClusterIP
-				session.PreviewURL = endpoints.PreviewURL
-				session.PreviewPath = endpoints.PreviewPath
-				session.ChatURL = endpoints.ChatURL
-				session.ChatPath = endpoints.ChatPath
-				session.VscodeURL = endpoints.VscodeURL
-				session.VscodePath = endpoints.VscodePath
+	session, existing, provisioning, err := h.sessions.GetOrCreate(c.Request.Context(), template)
+	if err != nil {
+		h.respondError(c, err, "Failed to get or create session")
+		return
+	}
+
+	if existing {
+		h.log.Info("Found existing session", zap.String("project_uuid", projectUUID))
+		if h.hibernator != nil {
+			h.hibernator.Touch(c.Request.Context(), session)
+			if !h.wakeIfHibernated(c, session) {
+				return
 			}
 		}
-	}
 
-	// Save to database
-	if err := database.DB.Create(&session).Error; err != nil {
-		h.log.Error("Failed to create session", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		if session.Status == models.StatusWaking {
+			c.JSON(http.StatusAccepted, session)
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
 		return
 	}
 
-	h.log.Info("Session created successfully",
-		zap.String("project_uuid", projectUUID),
-		zap.Uint("session_id", session.ID))
+	if !provisioning {
+		c.JSON(http.StatusOK, session)
+		return
+	}
 
-	c.JSON(http.StatusOK, session)
+	c.JSON(http.StatusAccepted, session)
 }
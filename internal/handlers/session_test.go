@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/sessions"
+	"go.uber.org/zap"
+)
+
+// newSessionRouter serves the session routes that work without a runtime
+// from repo, behind middleware
+func newSessionRouter(repo repository.SessionRepository, middleware ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	svc := sessions.NewService(zap.NewNop(), repo, nil, nil, nil)
+	h := NewSessionHandler(zap.NewNop(), repo, svc, nil)
+	router := gin.New()
+	router.Use(middleware...)
+	router.GET("/sessions", h.ListSessions)
+	router.GET("/sessions/:id", h.GetSession)
+	router.GET("/sessions/:id/history", h.GetSessionHistory)
+	router.GET("/sessions/project/:project_uuid", h.GetOrCreateSessionByProjectUUID)
+	router.GET("/projects/:project_uuid/sessions", h.ListProjectSessions)
+	router.POST("/sessions/:id/token/rotate", h.RotateSessionToken)
+	router.POST("/admin/sessions/:id/restore", h.RestoreSession)
+	return router
+}
+
+func TestSessionHandler_GetSession(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	require.NoError(t, repo.Create(context.Background(), &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("tok")}, ""))
+	router := newSessionRouter(repo)
+
+	w, body := serve(router, http.MethodGet, "/sessions/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, testProjectUUID, body["project_uuid"])
+	assert.NotContains(t, body, "token")
+	assert.NotContains(t, w.Body.String(), models.HashToken("tok"))
+
+	w, _ = serve(router, http.MethodGet, "/sessions/2")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, _ = serve(router, http.MethodGet, "/sessions/abc")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestSessionHandler_ListSessions(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	for _, s := range []models.Session{
+		{UserID: 1, ProjectUUID: "a", TokenHash: models.HashToken("a")},
+		{UserID: 2, ProjectUUID: "b", TokenHash: models.HashToken("b")},
+		{UserID: 1, ProjectUUID: "c", TokenHash: models.HashToken("c")},
+	} {
+		require.NoError(t, repo.Create(context.Background(), &s, ""))
+	}
+	router := newSessionRouter(repo)
+
+	w, body := serve(router, http.MethodGet, "/sessions?user_id=1&page_size=1&page=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	sessions := body["sessions"].([]interface{})
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "c", sessions[0].(map[string]interface{})["project_uuid"])
+	assert.Equal(t, map[string]interface{}{
+		"page":        float64(2),
+		"page_size":   float64(1),
+		"total":       float64(2),
+		"total_pages": float64(2),
+	}, body["pagination"])
+
+	w, body = serve(router, http.MethodGet, "/sessions?user_id=me")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Invalid user_id", body["error"])
+
+	w, body = serve(router, http.MethodGet, "/sessions?status=sleeping")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "Invalid status", body["error"])
+}
+
+func TestSessionHandler_GetSessionHistory(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("tok")}
+	require.NoError(t, repo.Create(ctx, session, ""))
+	change := repository.StatusChange{Reason: "dev container installation started", Actor: models.ActorProvisioner}
+	require.NoError(t, repo.Transition(ctx, session, models.StatusProvisioning, change, ""))
+	router := newSessionRouter(repo)
+
+	w, body := serve(router, http.MethodGet, "/sessions/1/history")
+	require.Equal(t, http.StatusOK, w.Code)
+	history := body["history"].([]interface{})
+	require.Len(t, history, 1)
+	entry := history[0].(map[string]interface{})
+	assert.Equal(t, "pending", entry["from_status"])
+	assert.Equal(t, "provisioning", entry["to_status"])
+	assert.Equal(t, "dev container installation started", entry["reason"])
+	assert.Equal(t, "provisioner", entry["actor"])
+	assert.Equal(t, float64(1), body["pagination"].(map[string]interface{})["total"])
+
+	w, _ = serve(router, http.MethodGet, "/sessions/2/history")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSessionHandler_ProjectHistoryAndRestore(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	ctx := context.Background()
+
+	deleted := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("a")}
+	require.NoError(t, repo.Create(ctx, deleted, ""))
+	require.NoError(t, repo.Delete(ctx, deleted, ""))
+	require.NoError(t, repo.Create(ctx, &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("b")}, ""))
+	router := newSessionRouter(repo)
+
+	w, body := serve(router, http.MethodGet, "/projects/"+testProjectUUID+"/sessions")
+	require.Equal(t, http.StatusOK, w.Code)
+	history := body["sessions"].([]interface{})
+	require.Len(t, history, 2)
+	assert.Nil(t, history[0].(map[string]interface{})["deleted_at"])
+	assert.NotNil(t, history[1].(map[string]interface{})["deleted_at"])
+
+	w, _ = serve(router, http.MethodPost, "/admin/sessions/1/restore")
+	assert.Equal(t, http.StatusConflict, w.Code, "the project already has a live session")
+
+	w, _ = serve(router, http.MethodPost, "/admin/sessions/2/restore")
+	assert.Equal(t, http.StatusConflict, w.Code, "the session is not deleted")
+
+	w, _ = serve(router, http.MethodPost, "/admin/sessions/3/restore")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// authenticateAs stands in for the authentication middleware
+func authenticateAs(principal *auth.Principal) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(auth.WithPrincipal(c.Request.Context(), principal))
+	}
+}
+
+func TestSessionHandler_CallerSeesOwnSessions(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	for _, s := range []models.Session{
+		{UserID: 1, ProjectUUID: "a", TokenHash: models.HashToken("a")},
+		{UserID: 2, ProjectUUID: "b", TokenHash: models.HashToken("b")},
+	} {
+		require.NoError(t, repo.Create(context.Background(), &s, ""))
+	}
+	router := newSessionRouter(repo, authenticateAs(&auth.Principal{UserID: 1}))
+
+	w, body := serve(router, http.MethodGet, "/sessions")
+	require.Equal(t, http.StatusOK, w.Code)
+	sessions := body["sessions"].([]interface{})
+	require.Len(t, sessions, 1)
+	assert.Equal(t, "a", sessions[0].(map[string]interface{})["project_uuid"])
+
+	w, _ = serve(router, http.MethodGet, "/sessions?user_id=2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w, _ = serve(router, http.MethodGet, "/sessions/1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	w, _ = serve(router, http.MethodGet, "/sessions/2")
+	assert.Equal(t, http.StatusNotFound, w.Code, "another user's session is hidden")
+	w, _ = serve(router, http.MethodGet, "/sessions/2/history")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w, body = serve(router, http.MethodGet, "/projects/b/sessions")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, body["sessions"])
+
+	w, _ = serve(router, http.MethodGet, "/sessions/project/c?user_id=2")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w, _ = serve(router, http.MethodGet, "/sessions/project/b")
+	assert.Equal(t, http.StatusConflict, w.Code, "another user's project is not taken over")
+	w, body = serve(router, http.MethodGet, "/sessions/project/c")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(1), body["user_id"], "new sessions belong to the caller")
+
+	admin := newSessionRouter(repo, authenticateAs(&auth.Principal{UserID: 3, Admin: true}))
+	w, body = serve(admin, http.MethodGet, "/sessions")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, body["sessions"], 3)
+	w, _ = serve(admin, http.MethodGet, "/sessions/2")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSessionHandler_RotateSessionToken(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	require.NoError(t, repo.Create(context.Background(), &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("tok")}, ""))
+	router := newSessionRouter(repo)
+
+	w, _ := serve(router, http.MethodPost, "/sessions/1/token/rotate?overlap_seconds=abc")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = serve(router, http.MethodPost, "/sessions/1/token/rotate?overlap_seconds=86401")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w, body := serve(router, http.MethodPost, "/sessions/1/token/rotate?overlap_seconds=60")
+	require.Equal(t, http.StatusOK, w.Code)
+	token, _ := body["token"].(string)
+	assert.NotEmpty(t, token)
+	assert.NotNil(t, body["token_rotated_at"])
+
+	for _, token := range []string{"tok", token} {
+		_, err := repo.GetByToken(context.Background(), token)
+		assert.NoError(t, err)
+	}
+
+	w, _ = serve(router, http.MethodPost, "/sessions/2/token/rotate")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestSessionHandler_StartSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	svc := sessions.NewService(zap.NewNop(), repo, rt, nil, nil)
+	h := NewSessionHandler(zap.NewNop(), repo, svc, nil)
+	router := gin.New()
+	router.POST("/sessions/:id/start", h.StartSession)
+
+	// One session per status, each with its own dev container
+	paths := map[models.SessionStatus][]models.SessionStatus{
+		models.StatusPending:    nil,
+		models.StatusRunning:    {models.StatusProvisioning, models.StatusRunning},
+		models.StatusHibernated: {models.StatusProvisioning, models.StatusRunning, models.StatusHibernated},
+		models.StatusStopped:    {models.StatusProvisioning, models.StatusRunning, models.StatusStopping, models.StatusStopped},
+	}
+	ids := map[models.SessionStatus]uint{}
+	for status, path := range paths {
+		projectUUID := uuid.New().String()
+		_, err := rt.CreateDevContainer(ctx, projectUUID, 1, 2)
+		require.NoError(t, err)
+		require.NoError(t, rt.StopDevContainer(ctx, projectUUID))
+
+		session := &models.Session{ProjectUUID: projectUUID, TokenHash: models.HashToken(projectUUID)}
+		require.NoError(t, repo.Create(ctx, session, ""))
+		for _, next := range path {
+			require.NoError(t, repo.Transition(ctx, session, next, repository.StatusChange{}, ""))
+		}
+		ids[status] = session.ID
+	}
+
+	start := func(status models.SessionStatus) (int, map[string]interface{}) {
+		w, body := serve(router, http.MethodPost, fmt.Sprintf("/sessions/%d/start", ids[status]))
+		return w.Code, body
+	}
+
+	for _, status := range []models.SessionStatus{models.StatusPending, models.StatusRunning, models.StatusHibernated} {
+		code, _ := start(status)
+		assert.Equal(t, http.StatusConflict, code, status)
+
+		stored, err := repo.Get(ctx, ids[status])
+		require.NoError(t, err)
+		release, _ := rt.Release(kubernetes.ReleaseName(stored.ProjectUUID))
+		assert.Equal(t, "stopped", release.Status, "%s: the dev container is not touched", status)
+		assert.Equal(t, status, stored.Status)
+	}
+
+	_, body := start(models.StatusHibernated)
+	assert.Equal(t, "Session is hibernated, open it to wake it", body["error"])
+
+	code, body := start(models.StatusStopped)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, string(models.StatusRunning), body["status"])
+}
@@ -0,0 +1,226 @@
+package hibernator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/provisioner"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultInterval is used when no hibernation interval is configured
+	defaultInterval = time.Minute
+	// defaultIdleTimeout is used when no idle timeout is configured
+	defaultIdleTimeout = 30 * time.Minute
+	// defaultBatchSize is used when no per-run limit is configured
+	defaultBatchSize = 50
+	// touchResolution limits how often activity is written for a busy session
+	touchResolution = time.Minute
+)
+
+// ErrNotHibernated is returned by Wake for sessions that are not hibernated
+var ErrNotHibernated = errors.New("session is not hibernated")
+
+// Hibernator scales idle dev containers to zero and wakes them again when
+// their endpoints are requested
+type Hibernator struct {
+	log         *zap.Logger
+	repo        repository.SessionRepository
+	runtime     kubernetes.Runtime
+	provisioner *provisioner.Provisioner
+	cfg         *config.HibernationConfig
+}
+
+// New creates a hibernator that finds and updates sessions in repo.
+// Wake-ups are queued on prov.
+func New(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, prov *provisioner.Provisioner, cfg *config.HibernationConfig) *Hibernator {
+	return &Hibernator{
+		log:         log,
+		repo:        repo,
+		runtime:     runtime,
+		provisioner: prov,
+		cfg:         cfg,
+	}
+}
+
+// Run hibernates idle sessions on the configured interval until ctx is cancelled
+func (h *Hibernator) Run(ctx context.Context) {
+	interval := time.Duration(h.cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	h.log.Info("Hibernator started",
+		zap.Duration("interval", interval),
+		zap.Duration("idle_timeout", h.idleTimeout()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.log.Info("Stopping hibernator")
+			return
+		case <-ticker.C:
+			if _, err := h.Hibernate(ctx); err != nil {
+				h.log.Error("Hibernation run failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Hibernate scales running sessions that have been idle for longer than the
+// idle timeout to zero, at most batch_size per call. It returns the number
+// of sessions hibernated.
+func (h *Hibernator) Hibernate(ctx context.Context) (int, error) {
+	limit := h.cfg.BatchSize
+	if limit <= 0 {
+		limit = defaultBatchSize
+	}
+
+	cutoff := time.Now().Add(-h.idleTimeout())
+
+	sessions, err := h.repo.ListIdle(ctx, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load idle sessions: %w", err)
+	}
+
+	hibernated := 0
+	for i := range sessions {
+		if ctx.Err() != nil {
+			break
+		}
+
+		session := &sessions[i]
+		idleSince := session.IdleSince()
+
+		// Claim the session before scaling it, so it is never scaled down
+		// while marked running. The claim fails if the session changed status
+		// or was requested since it was listed.
+		change := repository.StatusChange{
+			Reason: fmt.Sprintf("idle since %s", idleSince.UTC().Format(time.RFC3339)),
+			Actor:  models.ActorHibernator,
+		}
+		listed := session.LastAccessedAt
+		err := h.repo.Update(ctx, session, models.StatusHibernated, change, events.SessionHibernated, func(stored *models.Session) error {
+			if !sameTime(stored.LastAccessedAt, listed) {
+				return fmt.Errorf("%w: session %d was requested since it was listed", repository.ErrStaleStatus, stored.ID)
+			}
+			return nil
+		})
+		if errors.Is(err, repository.ErrStaleStatus) || errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			h.log.Error("Failed to mark session hibernated",
+				zap.Uint("session_id", session.ID),
+				zap.Error(err))
+			continue
+		}
+
+		if err := h.runtime.StopDevContainer(ctx, session.ProjectUUID); err != nil {
+			h.log.Error("Failed to hibernate dev container",
+				zap.Uint("session_id", session.ID),
+				zap.Error(err))
+
+			// The dev container is still up, so hand the session back unless a
+			// request already woke it
+			change := repository.StatusChange{Reason: fmt.Sprintf("failed to scale down: %v", err), Actor: models.ActorHibernator}
+			err := h.repo.Update(context.WithoutCancel(ctx), session, models.StatusRunning, change, events.StatusEvent(models.StatusRunning), nil)
+			if err != nil && !errors.Is(err, repository.ErrStaleStatus) && !errors.Is(err, repository.ErrNotFound) {
+				h.log.Error("Failed to reset session status", zap.Uint("session_id", session.ID), zap.Error(err))
+			}
+			continue
+		}
+
+		h.log.Info("Session hibernated",
+			zap.Uint("session_id", session.ID),
+			zap.String("project_uuid", session.ProjectUUID),
+			zap.Time("idle_since", idleSince))
+		hibernated++
+	}
+
+	return hibernated, nil
+}
+
+// Touch records that the session's endpoints were just requested. Writes
+// are skipped if the session was already touched within the last minute.
+func (h *Hibernator) Touch(ctx context.Context, session *models.Session) {
+	now := time.Now()
+	if session.LastAccessedAt != nil && now.Sub(*session.LastAccessedAt) < touchResolution {
+		return
+	}
+
+	if err := h.repo.Touch(ctx, session.ID, now); err != nil {
+		h.log.Warn("Failed to record session activity",
+			zap.Uint("session_id", session.ID),
+			zap.Error(err))
+		return
+	}
+
+	session.LastAccessedAt = &now
+}
+
+// Wake moves a hibernated session to waking and queues its dev container to
+// be started. A session that is already waking is left alone.
+func (h *Hibernator) Wake(ctx context.Context, session *models.Session) error {
+	if session.Status == models.StatusWaking {
+		return nil
+	}
+	if session.Status != models.StatusHibernated {
+		return ErrNotHibernated
+	}
+
+	// Claim the wake-up so concurrent requests queue a single start
+	change := repository.StatusChange{Reason: "endpoint requested", Actor: models.ActorHibernator}
+	err := h.repo.Update(ctx, session, models.StatusWaking, change, events.SessionWaking, nil)
+	if errors.Is(err, repository.ErrStaleStatus) {
+		// Another request claimed it first
+		session.Status = models.StatusWaking
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mark session waking: %w", err)
+	}
+
+	h.log.Info("Waking hibernated session",
+		zap.Uint("session_id", session.ID),
+		zap.String("project_uuid", session.ProjectUUID))
+
+	if err := h.provisioner.EnqueueStart(*session); err != nil {
+		// Hand the session back so the next request can try again
+		change := repository.StatusChange{Reason: fmt.Sprintf("failed to queue start: %v", err), Actor: models.ActorHibernator}
+		resetErr := h.repo.Update(ctx, session, models.StatusHibernated, change, events.SessionHibernated, nil)
+		if resetErr != nil {
+			h.log.Error("Failed to reset session status", zap.Uint("session_id", session.ID), zap.Error(resetErr))
+		}
+		return errors.Join(fmt.Errorf("failed to queue start: %w", err), resetErr)
+	}
+
+	return nil
+}
+
+// sameTime reports whether a and b are both unset or the same instant
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// idleTimeout returns how long a session may go unused before it is hibernated
+func (h *Hibernator) idleTimeout() time.Duration {
+	if h.cfg.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return time.Duration(h.cfg.IdleTimeout) * time.Second
+}
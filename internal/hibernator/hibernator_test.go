@@ -0,0 +1,167 @@
+package hibernator
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/provisioner"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/worker"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const testProjectUUID = "550e8400-e29b-41d4-a716-446655440000"
+
+// newIdleSession installs a running dev container and stores a running
+// session for it that was last requested an hour ago
+func newIdleSession(t *testing.T, repo *repository.MemorySessionRepository, rt *kubernetes.FakeRuntime) *models.Session {
+	ctx := context.Background()
+	_, err := rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+
+	accessed := time.Now().Add(-time.Hour)
+	session := &models.Session{
+		ProjectUUID:    testProjectUUID,
+		Namespace:      testProjectUUID,
+		TokenHash:      models.HashToken("tok"),
+		ExpiresAt:      time.Now().Add(time.Hour),
+		LastAccessedAt: &accessed,
+	}
+	require.NoError(t, repo.Create(ctx, session, ""))
+	require.NoError(t, repo.Transition(ctx, session, models.StatusProvisioning, repository.StatusChange{}, ""))
+	require.NoError(t, repo.Transition(ctx, session, models.StatusRunning, repository.StatusChange{}, ""))
+	return session
+}
+
+// touchedRepository records a request to every idle session right after
+// listing it, as a request racing the hibernator would
+type touchedRepository struct {
+	*repository.MemorySessionRepository
+	t *testing.T
+}
+
+func (r touchedRepository) ListIdle(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error) {
+	sessions, err := r.MemorySessionRepository.ListIdle(ctx, cutoff, limit)
+	for _, session := range sessions {
+		require.NoError(r.t, r.Touch(ctx, session.ID, time.Now()))
+	}
+	return sessions, err
+}
+
+func newTestHibernator(cfg *config.HibernationConfig) *Hibernator {
+	return New(zap.NewNop(), repository.NewMemorySessionRepository(), kubernetes.NewFakeRuntime(), nil, cfg)
+}
+
+func TestHibernator_WakeOnlyHibernatedSessions(t *testing.T) {
+	ctx := context.Background()
+	h := newTestHibernator(&config.HibernationConfig{})
+
+	assert.ErrorIs(t, h.Wake(ctx, &models.Session{ID: 1, Status: "running"}), ErrNotHibernated)
+	assert.ErrorIs(t, h.Wake(ctx, &models.Session{ID: 1, Status: "stopped"}), ErrNotHibernated)
+
+	// A wake-up already in progress is not queued again
+	waking := &models.Session{ID: 1, Status: "waking"}
+	assert.NoError(t, h.Wake(ctx, waking))
+	assert.Equal(t, models.StatusWaking, waking.Status)
+}
+
+func TestHibernator_TouchIsThrottled(t *testing.T) {
+	h := newTestHibernator(&config.HibernationConfig{})
+
+	// A session touched seconds ago is not written again, so no database is needed
+	recent := time.Now().Add(-10 * time.Second)
+	session := &models.Session{ID: 1, LastAccessedAt: &recent}
+	h.Touch(context.Background(), session)
+	assert.Equal(t, recent, *session.LastAccessedAt)
+}
+
+func TestHibernator_IdleTimeout(t *testing.T) {
+	h := newTestHibernator(&config.HibernationConfig{IdleTimeout: 600})
+	assert.Equal(t, 10*time.Minute, h.idleTimeout())
+
+	h.cfg.IdleTimeout = 0
+	assert.Equal(t, defaultIdleTimeout, h.idleTimeout())
+}
+
+func TestHibernator_HibernateAndWake(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	session := newIdleSession(t, repo, rt)
+
+	pool := worker.NewPool(zap.NewNop(), 1, 1)
+	pool.Start()
+	defer pool.Shutdown(ctx)
+	h := New(zap.NewNop(), repo, rt, provisioner.New(zap.NewNop(), repo, rt, pool), &config.HibernationConfig{})
+
+	hibernated, err := h.Hibernate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, hibernated)
+
+	release, _ := rt.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.Equal(t, "stopped", release.Status)
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusHibernated, stored.Status)
+
+	require.NoError(t, h.Wake(ctx, stored))
+	assert.Equal(t, models.StatusWaking, stored.Status)
+
+	require.Eventually(t, func() bool {
+		stored, err := repo.Get(ctx, session.ID)
+		return err == nil && stored.Status == models.StatusRunning
+	}, time.Second, 10*time.Millisecond)
+	release, _ = rt.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.Equal(t, "running", release.Status)
+
+	history, _, err := repo.StatusHistory(ctx, session.ID, repository.NewPage(1, 10))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(history), 3)
+	assert.Equal(t, models.StatusRunning, history[0].ToStatus)
+	assert.Equal(t, models.ActorProvisioner, history[0].Actor)
+	assert.Equal(t, models.StatusWaking, history[1].ToStatus)
+	assert.Equal(t, models.StatusHibernated, history[2].ToStatus)
+	assert.Equal(t, models.ActorHibernator, history[2].Actor)
+}
+
+func TestHibernator_HibernateSkipsSessionRequestedSinceListed(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	session := newIdleSession(t, repo, rt)
+
+	h := New(zap.NewNop(), touchedRepository{repo, t}, rt, nil, &config.HibernationConfig{})
+	hibernated, err := h.Hibernate(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, hibernated)
+
+	release, _ := rt.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.Equal(t, "running", release.Status, "a session in use is not scaled down")
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusRunning, stored.Status)
+}
+
+func TestHibernator_HibernateHandsBackSessionOnScaleFailure(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	session := newIdleSession(t, repo, rt)
+	rt.ScaleErr = errors.New("cluster unreachable")
+
+	h := New(zap.NewNop(), repo, rt, nil, &config.HibernationConfig{})
+	hibernated, err := h.Hibernate(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, hibernated)
+
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusRunning, stored.Status, "the dev container is still up")
+}
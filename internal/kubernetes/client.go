@@ -2,53 +2,213 @@ package kubernetes
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
-	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
 )
 
-// Client handles Kubernetes operations for dev containers
+const (
+	// defaultHelmTimeout bounds how long install and upgrade wait for resources to become ready
+	defaultHelmTimeout = 5 * time.Minute
+	// helmDriver is the storage backend for Helm release records
+	helmDriver = "secret"
+	// readyPollInterval is how often a Deployment is checked while waiting for it to become ready
+	readyPollInterval = 2 * time.Second
+	// restartedAtAnnotation is the pod template annotation kubectl uses to roll a Deployment
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+)
+
+// Client handles Kubernetes operations for dev containers.
+// It drives the dev-session-template chart through the Helm Go SDK and reads
+// cluster state through client-go. Cluster connections are established lazily
+// on first use, so constructing a Client never requires a reachable cluster.
 type Client struct {
 	log       *zap.Logger
 	helmChart string // Path to the Helm chart template
+	timeout   time.Duration
+	settings  *cli.EnvSettings
+	ingress   IngressOptions
+
+	mu    sync.Mutex
+	chart *chart.Chart
+	kube  clientset.Interface
+}
+
+var _ Runtime = (*Client)(nil)
+
+// ClientOption configures optional Client settings
+type ClientOption func(*Client)
+
+// WithKubeconfig sets the kubeconfig file used to reach the cluster
+func WithKubeconfig(path string) ClientOption {
+	return func(c *Client) {
+		if path != "" {
+			c.settings.KubeConfig = path
+		}
+	}
+}
+
+// WithTimeout sets how long Helm waits for a release to become ready
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithIngress renders a per-session Ingress into every release
+func WithIngress(opts IngressOptions) ClientOption {
+	return func(c *Client) {
+		c.ingress = opts
+	}
 }
 
 // NewClient creates a new Kubernetes client
-func NewClient(log *zap.Logger, helmChartPath string) (*Client, error) {
+func NewClient(log *zap.Logger, helmChartPath string, opts ...ClientOption) (*Client, error) {
 	// Default Helm chart path if not provided
 	if helmChartPath == "" {
 		helmChartPath = "./helm/dev-session-template"
 	}
 
-	return &Client{
+	c := &Client{
 		log:       log,
 		helmChart: helmChartPath,
-	}, nil
+		timeout:   defaultHelmTimeout,
+		settings:  cli.New(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c, nil
+}
+
+// loadChart loads the dev session chart from disk, caching it after the first call
+func (c *Client) loadChart() (*chart.Chart, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.chart != nil {
+		return c.chart, nil
+	}
+
+	chrt, err := loader.Load(c.helmChart)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load helm chart %s: %w", c.helmChart, err)
+	}
+
+	c.chart = chrt
+	return chrt, nil
 }
 
-// ServiceEndpoints holds the service endpoint information
-type ServiceEndpoints struct {
-	PreviewURL  string
-	PreviewPath string
-	ChatURL     string
-	ChatPath    string
-	VscodeURL   string
-	VscodePath  string
-	ClusterIP   string
+// clientset returns the client-go clientset, creating it on first use
+func (c *Client) clientset() (clientset.Interface, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.kube != nil {
+		return c.kube, nil
+	}
+
+	restConfig, err := c.settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
+	}
+
+	kube, err := clientset.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
+	}
+
+	c.kube = kube
+	return kube, nil
+}
+
+// actionConfig returns a Helm action configuration scoped to the namespace
+func (c *Client) actionConfig(namespace string) (*action.Configuration, error) {
+	cfg := new(action.Configuration)
+	debugLog := func(format string, v ...interface{}) {
+		c.log.Debug(fmt.Sprintf(format, v...), zap.String("namespace", namespace))
+	}
+
+	if err := cfg.Init(c.settings.RESTClientGetter(), namespace, helmDriver, debugLog); err != nil {
+		return nil, fmt.Errorf("failed to initialize helm: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// chartValues builds the chart values for a project's dev container
+func chartValues(projectUUID string, projectID int, userID int, ingress IngressOptions) map[string]interface{} {
+	return map[string]interface{}{
+		"project": map[string]interface{}{
+			"uuid": projectUUID,
+			"id":   projectID,
+		},
+		"user": map[string]interface{}{
+			"id": userID,
+		},
+		// The namespace is created by ensureNamespace before the release is
+		// installed, since Helm stores the release record inside it.
+		"namespace": map[string]interface{}{
+			"create": false,
+		},
+		"ingress": ingress.values(projectUUID),
+	}
+}
+
+// ensureNamespace creates the project namespace if it does not exist yet
+func (c *Client) ensureNamespace(ctx context.Context, projectUUID string, projectID int, userID int) error {
+	kube, err := c.clientset()
+	if err != nil {
+		return err
+	}
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: projectUUID,
+			Labels: map[string]string{
+				"app.kubernetes.io/managed-by": "paypilot-dev-session-service",
+				"project.uuid":                 projectUUID,
+				"project.id":                   fmt.Sprintf("%d", projectID),
+				"user.id":                      fmt.Sprintf("%d", userID),
+			},
+		},
+	}
+
+	_, err = kube.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create namespace %s: %w", projectUUID, err)
+	}
+
+	return nil
 }
 
 // CreateDevContainer creates a new dev container in the specified namespace using Helm
 func (c *Client) CreateDevContainer(ctx context.Context, projectUUID string, projectID int, userID int) (*ServiceEndpoints, error) {
-	// Validate input to prevent command injection
-	if !isValidProjectUUID(projectUUID) {
-		return nil, fmt.Errorf("invalid project UUID format: %s", projectUUID)
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return nil, err
 	}
 
-	releaseName := fmt.Sprintf("dev-session-%s", projectUUID)
+	releaseName := ReleaseName(projectUUID)
 
 	c.log.Info("Creating dev container with Helm",
 		zap.String("release", releaseName),
@@ -56,184 +216,473 @@ func (c *Client) CreateDevContainer(ctx context.Context, projectUUID string, pro
 		zap.Int("project_id", projectID),
 		zap.Int("user_id", userID))
 
-	// Build Helm install command
-	args := []string{
-		"install", releaseName,
-		c.helmChart,
-		"--set", fmt.Sprintf("project.uuid=%s", projectUUID),
-		"--set", fmt.Sprintf("project.id=%d", projectID),
-		"--set", fmt.Sprintf("user.id=%d", userID),
-		"--create-namespace",
-		"--wait",
-		"--timeout", "5m",
+	chrt, err := c.loadChart()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.ensureNamespace(ctx, projectUUID, projectID, userID); err != nil {
+		return nil, err
+	}
+
+	cfg, err := c.actionConfig(projectUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := action.NewStatus(cfg).Run(releaseName); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrReleaseExists, releaseName)
+	} else if !errors.Is(err, driver.ErrReleaseNotFound) {
+		return nil, fmt.Errorf("failed to check helm release %s: %w", releaseName, err)
 	}
 
-	cmd := exec.CommandContext(ctx, "helm", args...)
-	output, err := cmd.CombinedOutput()
+	install := action.NewInstall(cfg)
+	install.ReleaseName = releaseName
+	install.Namespace = projectUUID
+	install.Wait = true
+	install.Timeout = c.timeout
+
+	rel, err := install.RunWithContext(ctx, chrt, chartValues(projectUUID, projectID, userID, c.ingress))
 	if err != nil {
 		c.log.Error("Failed to install Helm chart",
-			zap.Error(err),
-			zap.String("output", string(output)))
-		return nil, fmt.Errorf("helm install failed: %w, output: %s", err, string(output))
+			zap.String("release", releaseName),
+			zap.Error(err))
+		return nil, fmt.Errorf("helm install %s failed: %w", releaseName, err)
 	}
 
 	c.log.Info("Helm chart installed successfully",
 		zap.String("release", releaseName),
-		zap.String("output", string(output)))
+		zap.Int("revision", rel.Version))
 
 	// Get service endpoints
 	endpoints, err := c.GetServiceEndpoints(ctx, projectUUID, releaseName)
 	if err != nil {
 		c.log.Warn("Failed to get service endpoints", zap.Error(err))
 		// Return default endpoints even if we can't fetch them
-		endpoints = &ServiceEndpoints{
-			PreviewPath: "/preview",
-			ChatPath:    "/chat",
-			VscodePath:  "/vscode",
-		}
+		endpoints = defaultEndpoints()
 	}
 
 	return endpoints, nil
 }
 
-// isValidProjectUUID validates that a project UUID contains only safe characters
-func isValidProjectUUID(uuid string) bool {
-	// UUID format: 8-4-4-4-12 hex digits with hyphens
-	match, _ := regexp.MatchString(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$`, uuid)
-	return match
-}
-
-// GetServiceEndpoints retrieves the service endpoints for a dev session
+// GetServiceEndpoints retrieves the service endpoints for a dev session.
+// Public URLs come from the release's Ingress when it has one; the internal
+// URLs always point at the load balancer ClusterIP.
 func (c *Client) GetServiceEndpoints(ctx context.Context, namespace string, releaseName string) (*ServiceEndpoints, error) {
-	// Get ClusterIP of the load balancer service
-	serviceName := fmt.Sprintf("%s-dev-session-template-lb", releaseName)
+	kube, err := c.clientset()
+	if err != nil {
+		return nil, err
+	}
 
-	cmd := exec.CommandContext(ctx, "kubectl", "get", "service", serviceName,
-		"-n", namespace,
-		"-o", "jsonpath={.spec.clusterIP}")
+	endpoints := defaultEndpoints()
 
-	output, err := cmd.CombinedOutput()
-	clusterIP := strings.TrimSpace(string(output))
+	// Resources are found by label rather than by name because the chart
+	// truncates long resource names.
+	instance := "app.kubernetes.io/instance=" + releaseName
+	ingresses, err := kube.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{LabelSelector: instance})
+	if err != nil {
+		c.log.Warn("Failed to list ingresses", zap.String("release", releaseName), zap.Error(err))
+	} else if len(ingresses.Items) > 0 {
+		applyIngress(endpoints, &ingresses.Items[0])
+	}
 
-	endpoints := &ServiceEndpoints{
-		PreviewPath: "/preview",
-		ChatPath:    "/chat",
-		VscodePath:  "/vscode",
+	services, err := kube.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{LabelSelector: instance + ",service-type=loadbalancer"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services for %s: %w", releaseName, err)
 	}
 
-	if err != nil || clusterIP == "" || clusterIP == "<none>" {
-		c.log.Warn("Failed to get ClusterIP, using placeholder", zap.Error(err))
-		endpoints.ClusterIP = ""
-		// Don't construct URLs if we don't have a valid IP
-		return endpoints, nil
+	var clusterIP string
+	if len(services.Items) > 0 {
+		clusterIP = services.Items[0].Spec.ClusterIP
 	}
 
-	endpoints.ClusterIP = clusterIP
-	endpoints.PreviewURL = fmt.Sprintf("http://%s/preview", clusterIP)
-	endpoints.ChatURL = fmt.Sprintf("http://%s/chat", clusterIP)
-	endpoints.VscodeURL = fmt.Sprintf("http://%s/vscode", clusterIP)
+	if clusterIP == "" || clusterIP == corev1.ClusterIPNone {
+		c.log.Warn("Failed to get ClusterIP, using placeholder", zap.String("release", releaseName))
+		// Don't construct internal URLs if we don't have a valid IP
+		return endpoints, nil
+	}
 
+	endpoints.setClusterIP(clusterIP)
 	return endpoints, nil
 }
 
+// GetServiceTarget returns the cluster DNS address of the service behind one
+// of a release's endpoints, for example http://<service>.<namespace>.svc:3000
+func (c *Client) GetServiceTarget(ctx context.Context, namespace string, releaseName string, endpoint string) (string, error) {
+	if err := validateEndpoint(endpoint); err != nil {
+		return "", err
+	}
+
+	kube, err := c.clientset()
+	if err != nil {
+		return "", err
+	}
+
+	selector := fmt.Sprintf("app.kubernetes.io/instance=%s,service-type=%s", releaseName, endpoint)
+	services, err := kube.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", fmt.Errorf("failed to list %s services for %s: %w", endpoint, releaseName, err)
+	}
+
+	if len(services.Items) == 0 || len(services.Items[0].Spec.Ports) == 0 {
+		return "", fmt.Errorf("%w: %s has no %s service", ErrReleaseNotFound, releaseName, endpoint)
+	}
+
+	svc := services.Items[0]
+	return fmt.Sprintf("http://%s.%s.svc:%d", svc.Name, svc.Namespace, svc.Spec.Ports[0].Port), nil
+}
+
 // DeleteDevContainer deletes a dev container from Kubernetes using Helm
 func (c *Client) DeleteDevContainer(ctx context.Context, projectUUID string) error {
-	releaseName := fmt.Sprintf("dev-session-%s", projectUUID)
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	releaseName := ReleaseName(projectUUID)
 
 	c.log.Info("Deleting dev container with Helm",
 		zap.String("release", releaseName),
 		zap.String("namespace", projectUUID))
 
-	// Uninstall Helm release
-	cmd := exec.CommandContext(ctx, "helm", "uninstall", releaseName, "-n", projectUUID)
-	output, err := cmd.CombinedOutput()
+	cfg, err := c.actionConfig(projectUUID)
 	if err != nil {
+		return err
+	}
+
+	uninstall := action.NewUninstall(cfg)
+	uninstall.Wait = true
+	uninstall.Timeout = c.timeout
+
+	if _, err := uninstall.Run(releaseName); err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			return fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+		}
 		c.log.Error("Failed to uninstall Helm chart",
-			zap.Error(err),
-			zap.String("output", string(output)))
-		return fmt.Errorf("helm uninstall failed: %w", err)
+			zap.String("release", releaseName),
+			zap.Error(err))
+		return fmt.Errorf("helm uninstall %s failed: %w", releaseName, err)
 	}
 
 	c.log.Info("Helm chart uninstalled successfully", zap.String("release", releaseName))
 
-	// Optionally delete the namespace
-	// Note: This is commented out as namespace deletion might be handled separately
-	// cmd = exec.CommandContext(ctx, "kubectl", "delete", "namespace", projectUUID)
-	// cmd.Run()
+	// The namespace is left in place as its deletion might be handled separately
 
 	return nil
 }
 
-// HelmStatus represents the Helm release status JSON structure
-type HelmStatus struct {
-	Info struct {
-		Status string `json:"status"`
-	} `json:"info"`
-}
-
 // GetContainerStatus returns the status of a dev container
 func (c *Client) GetContainerStatus(ctx context.Context, namespace string, releaseName string) (string, error) {
-	// Query Helm release status
-	cmd := exec.CommandContext(ctx, "helm", "status", releaseName, "-n", namespace, "-o", "json")
-	output, err := cmd.CombinedOutput()
+	cfg, err := c.actionConfig(namespace)
 	if err != nil {
-		c.log.Error("Failed to get Helm release status",
-			zap.Error(err),
-			zap.String("output", string(output)))
 		return "error", err
 	}
 
-	// Parse JSON output
-	var status HelmStatus
-	if err := json.Unmarshal(output, &status); err != nil {
-		c.log.Error("Failed to parse Helm status JSON", zap.Error(err))
-		return "unknown", err
+	rel, err := action.NewStatus(cfg).Run(releaseName)
+	if err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) {
+			return "error", fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+		}
+		c.log.Error("Failed to get Helm release status",
+			zap.String("release", releaseName),
+			zap.Error(err))
+		return "error", fmt.Errorf("helm status %s failed: %w", releaseName, err)
 	}
 
-	// Map Helm status to our status values
-	switch strings.ToLower(status.Info.Status) {
-	case "deployed":
-		return "running", nil
-	case "pending-install", "pending-upgrade", "pending-rollback":
-		return "pending", nil
-	case "failed":
-		return "error", nil
-	case "uninstalling", "uninstalled":
+	if rel.Info == nil {
+		return "unknown", nil
+	}
+
+	status := sessionStatus(rel.Info.Status)
+	if status == "running" && c.scaledToZero(ctx, namespace, releaseName) {
 		return "stopped", nil
+	}
+
+	return status, nil
+}
+
+// scaledToZero reports whether the release's Deployment has been stopped.
+// Lookup failures are treated as not stopped.
+func (c *Client) scaledToZero(ctx context.Context, namespace string, releaseName string) bool {
+	kube, err := c.clientset()
+	if err != nil {
+		return false
+	}
+
+	dep, err := c.deployment(ctx, kube, namespace, releaseName)
+	if err != nil {
+		c.log.Debug("Failed to get deployment", zap.String("release", releaseName), zap.Error(err))
+		return false
+	}
+
+	return dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0
+}
+
+// ListReleases returns the dev container releases in all namespaces
+func (c *Client) ListReleases(ctx context.Context) ([]Release, error) {
+	// An empty namespace makes the secret driver read release records cluster-wide
+	cfg, err := c.actionConfig("")
+	if err != nil {
+		return nil, err
+	}
+
+	list := action.NewList(cfg)
+	list.AllNamespaces = true
+	list.Filter = "^" + releasePrefix
+	list.StateMask = action.ListDeployed | action.ListFailed |
+		action.ListPendingInstall | action.ListPendingUpgrade | action.ListPendingRollback
+
+	rels, err := list.Run()
+	if err != nil {
+		c.log.Error("Failed to list Helm releases", zap.Error(err))
+		return nil, fmt.Errorf("helm list failed: %w", err)
+	}
+
+	releases := make([]Release, 0, len(rels))
+	for _, rel := range rels {
+		projectUUID, ok := ProjectUUIDFromRelease(rel.Name)
+		if !ok {
+			continue
+		}
+
+		status := "unknown"
+		if rel.Info != nil {
+			status = sessionStatus(rel.Info.Status)
+		}
+
+		releases = append(releases, Release{
+			Name:        rel.Name,
+			Namespace:   rel.Namespace,
+			ProjectUUID: projectUUID,
+			ProjectID:   intValue(rel.Config, "project", "id"),
+			UserID:      intValue(rel.Config, "user", "id"),
+			Status:      status,
+		})
+	}
+
+	return releases, nil
+}
+
+// intValue reads a nested numeric chart value, returning 0 if it is missing.
+// Values decoded from a stored release are float64 rather than int.
+func intValue(values map[string]interface{}, section string, key string) int {
+	nested, ok := values[section].(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	switch v := nested[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
 	default:
-		return "unknown", nil
+		return 0
+	}
+}
+
+// sessionStatus maps a Helm release status to our status values
+func sessionStatus(status release.Status) string {
+	switch release.Status(strings.ToLower(string(status))) {
+	case release.StatusDeployed:
+		return "running"
+	case release.StatusPendingInstall, release.StatusPendingUpgrade, release.StatusPendingRollback:
+		return "pending"
+	case release.StatusFailed:
+		return "error"
+	case release.StatusUninstalling, release.StatusUninstalled:
+		return "stopped"
+	default:
+		return "unknown"
 	}
 }
 
 // UpdateContainer updates the configuration of a running dev container
 func (c *Client) UpdateContainer(ctx context.Context, projectUUID string, projectID int, userID int) error {
-	releaseName := fmt.Sprintf("dev-session-%s", projectUUID)
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	releaseName := ReleaseName(projectUUID)
 
 	c.log.Info("Updating dev container with Helm",
 		zap.String("release", releaseName),
 		zap.String("namespace", projectUUID))
 
-	// Build Helm upgrade command
-	args := []string{
-		"upgrade", releaseName,
-		c.helmChart,
-		"-n", projectUUID,
-		"--set", fmt.Sprintf("project.uuid=%s", projectUUID),
-		"--set", fmt.Sprintf("project.id=%d", projectID),
-		"--set", fmt.Sprintf("user.id=%d", userID),
-		"--wait",
-		"--timeout", "5m",
+	chrt, err := c.loadChart()
+	if err != nil {
+		return err
 	}
 
-	cmd := exec.CommandContext(ctx, "helm", args...)
-	output, err := cmd.CombinedOutput()
+	cfg, err := c.actionConfig(projectUUID)
 	if err != nil {
+		return err
+	}
+
+	upgrade := action.NewUpgrade(cfg)
+	upgrade.Namespace = projectUUID
+	upgrade.Wait = true
+	upgrade.Timeout = c.timeout
+
+	if _, err := upgrade.RunWithContext(ctx, releaseName, chrt, chartValues(projectUUID, projectID, userID, c.ingress)); err != nil {
+		if errors.Is(err, driver.ErrReleaseNotFound) || errors.Is(err, driver.ErrNoDeployedReleases) {
+			return fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+		}
 		c.log.Error("Failed to upgrade Helm chart",
-			zap.Error(err),
-			zap.String("output", string(output)))
-		return fmt.Errorf("helm upgrade failed: %w", err)
+			zap.String("release", releaseName),
+			zap.Error(err))
+		return fmt.Errorf("helm upgrade %s failed: %w", releaseName, err)
 	}
 
 	c.log.Info("Helm chart upgraded successfully", zap.String("release", releaseName))
 	return nil
 }
+
+// StopDevContainer scales the dev container Deployment to zero replicas.
+// The release, services and workspace PVC are left in place.
+func (c *Client) StopDevContainer(ctx context.Context, projectUUID string) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	c.log.Info("Stopping dev container",
+		zap.String("release", ReleaseName(projectUUID)),
+		zap.String("namespace", projectUUID))
+
+	_, err := c.scale(ctx, projectUUID, 0)
+	return err
+}
+
+// StartDevContainer scales the dev container Deployment back to one replica,
+// waits for it to become ready and returns the refreshed service endpoints
+func (c *Client) StartDevContainer(ctx context.Context, projectUUID string) (*ServiceEndpoints, error) {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return nil, err
+	}
+
+	releaseName := ReleaseName(projectUUID)
+
+	c.log.Info("Starting dev container",
+		zap.String("release", releaseName),
+		zap.String("namespace", projectUUID))
+
+	dep, err := c.scale(ctx, projectUUID, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.waitForReady(ctx, projectUUID, dep.Name); err != nil {
+		return nil, err
+	}
+
+	return c.GetServiceEndpoints(ctx, projectUUID, releaseName)
+}
+
+// RestartDevContainer rolls the dev container pod the same way
+// `kubectl rollout restart` does and waits for the new pod to become ready
+func (c *Client) RestartDevContainer(ctx context.Context, projectUUID string) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	releaseName := ReleaseName(projectUUID)
+
+	c.log.Info("Restarting dev container",
+		zap.String("release", releaseName),
+		zap.String("namespace", projectUUID))
+
+	kube, err := c.clientset()
+	if err != nil {
+		return err
+	}
+
+	dep, err := c.deployment(ctx, kube, projectUUID, releaseName)
+	if err != nil {
+		return err
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`,
+		restartedAtAnnotation, time.Now().Format(time.RFC3339))
+
+	_, err = kube.AppsV1().Deployments(projectUUID).Patch(ctx, dep.Name, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to restart deployment %s: %w", dep.Name, err)
+	}
+
+	return c.waitForReady(ctx, projectUUID, dep.Name)
+}
+
+// deployment finds the dev container Deployment of a release by its instance label
+func (c *Client) deployment(ctx context.Context, kube clientset.Interface, namespace string, releaseName string) (*appsv1.Deployment, error) {
+	selector := fmt.Sprintf("app.kubernetes.io/instance=%s", releaseName)
+	deployments, err := kube.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments for %s: %w", releaseName, err)
+	}
+
+	if len(deployments.Items) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	}
+
+	return &deployments.Items[0], nil
+}
+
+// scale sets the replica count of a project's dev container Deployment
+func (c *Client) scale(ctx context.Context, projectUUID string, replicas int32) (*appsv1.Deployment, error) {
+	kube, err := c.clientset()
+	if err != nil {
+		return nil, err
+	}
+
+	dep, err := c.deployment(ctx, kube, projectUUID, ReleaseName(projectUUID))
+	if err != nil {
+		return nil, err
+	}
+
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas)
+	dep, err = kube.AppsV1().Deployments(projectUUID).Patch(ctx, dep.Name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		c.log.Error("Failed to scale deployment",
+			zap.String("namespace", projectUUID),
+			zap.Int32("replicas", replicas),
+			zap.Error(err))
+		return nil, fmt.Errorf("failed to scale deployment in %s: %w", projectUUID, err)
+	}
+
+	return dep, nil
+}
+
+// waitForReady waits until every replica of the Deployment runs the latest pod template
+func (c *Client) waitForReady(ctx context.Context, namespace string, name string) error {
+	kube, err := c.clientset()
+	if err != nil {
+		return err
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, readyPollInterval, c.timeout, true, func(ctx context.Context) (bool, error) {
+		dep, err := kube.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return deploymentReady(dep), nil
+	})
+	if err != nil {
+		return fmt.Errorf("deployment %s did not become ready: %w", name, err)
+	}
+
+	return nil
+}
+
+// deploymentReady reports whether a Deployment has finished rolling out
+func deploymentReady(dep *appsv1.Deployment) bool {
+	want := int32(1)
+	if dep.Spec.Replicas != nil {
+		want = *dep.Spec.Replicas
+	}
+
+	return dep.Status.ObservedGeneration >= dep.Generation &&
+		dep.Status.UpdatedReplicas == want &&
+		dep.Status.AvailableReplicas == want &&
+		dep.Status.Replicas == want
+}
@@ -1,10 +1,18 @@
 package kubernetes
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func TestNewClient(t *testing.T) {
@@ -118,3 +126,166 @@ func TestIsValidProjectUUID(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionStatus(t *testing.T) {
+	tests := []struct {
+		status release.Status
+		want   string
+	}{
+		{release.StatusDeployed, "running"},
+		{release.StatusPendingInstall, "pending"},
+		{release.StatusPendingUpgrade, "pending"},
+		{release.StatusFailed, "error"},
+		{release.StatusUninstalled, "stopped"},
+		{release.StatusSuperseded, "unknown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			assert.Equal(t, tt.want, sessionStatus(tt.status))
+		})
+	}
+}
+
+func TestChartValues(t *testing.T) {
+	values := chartValues("550e8400-e29b-41d4-a716-446655440000", 7, 9, IngressOptions{})
+
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", values["project"].(map[string]interface{})["uuid"])
+	assert.Equal(t, 7, values["project"].(map[string]interface{})["id"])
+	assert.Equal(t, 9, values["user"].(map[string]interface{})["id"])
+	assert.Equal(t, false, values["namespace"].(map[string]interface{})["create"])
+	assert.Equal(t, false, values["ingress"].(map[string]interface{})["enabled"])
+}
+
+func TestProjectUUIDFromRelease(t *testing.T) {
+	projectUUID, ok := ProjectUUIDFromRelease("dev-session-550e8400-e29b-41d4-a716-446655440000")
+	assert.True(t, ok)
+	assert.Equal(t, "550e8400-e29b-41d4-a716-446655440000", projectUUID)
+
+	_, ok = ProjectUUIDFromRelease("ingress-nginx")
+	assert.False(t, ok)
+
+	_, ok = ProjectUUIDFromRelease("dev-session-not-a-uuid")
+	assert.False(t, ok)
+}
+
+func TestIntValue(t *testing.T) {
+	values := map[string]interface{}{
+		"project": map[string]interface{}{"id": float64(7)},
+		"user":    map[string]interface{}{"id": 9},
+	}
+
+	assert.Equal(t, 7, intValue(values, "project", "id"))
+	assert.Equal(t, 9, intValue(values, "user", "id"))
+	assert.Equal(t, 0, intValue(values, "project", "uuid"))
+	assert.Equal(t, 0, intValue(nil, "project", "id"))
+}
+
+// newScaleTestClient returns a Client backed by a fake clientset holding a
+// ready dev container Deployment with the given replica count
+func newScaleTestClient(t *testing.T, replicas int32) (*Client, *fake.Clientset) {
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dev-session-550e8400-e29b-41d4-a716-446655440000-dev-session-template",
+			Namespace: testProjectUUID,
+			Labels:    map[string]string{"app.kubernetes.io/instance": ReleaseName(testProjectUUID)},
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          1,
+			UpdatedReplicas:   1,
+			AvailableReplicas: 1,
+		},
+	}
+
+	kube := fake.NewClientset(dep)
+	client, err := NewClient(zap.NewNop(), "", WithTimeout(time.Second))
+	require.NoError(t, err)
+	client.kube = kube
+
+	return client, kube
+}
+
+func TestClient_StopAndStartDevContainer(t *testing.T) {
+	ctx := context.Background()
+	client, kube := newScaleTestClient(t, 1)
+	releaseName := ReleaseName(testProjectUUID)
+
+	require.NoError(t, client.StopDevContainer(ctx, testProjectUUID))
+	dep, err := client.deployment(ctx, kube, testProjectUUID, releaseName)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), *dep.Spec.Replicas)
+	assert.True(t, client.scaledToZero(ctx, testProjectUUID, releaseName))
+
+	endpoints, err := client.StartDevContainer(ctx, testProjectUUID)
+	require.NoError(t, err)
+	assert.Equal(t, "/preview", endpoints.PreviewPath)
+	assert.False(t, client.scaledToZero(ctx, testProjectUUID, releaseName))
+}
+
+func TestClient_RestartDevContainer(t *testing.T) {
+	ctx := context.Background()
+	client, kube := newScaleTestClient(t, 1)
+
+	require.NoError(t, client.RestartDevContainer(ctx, testProjectUUID))
+	dep, err := client.deployment(ctx, kube, testProjectUUID, ReleaseName(testProjectUUID))
+	require.NoError(t, err)
+	assert.NotEmpty(t, dep.Spec.Template.Annotations[restartedAtAnnotation])
+}
+
+func TestClient_ScaleMissingDeployment(t *testing.T) {
+	client, err := NewClient(zap.NewNop(), "")
+	require.NoError(t, err)
+	client.kube = fake.NewClientset()
+
+	assert.ErrorIs(t, client.StopDevContainer(context.Background(), testProjectUUID), ErrReleaseNotFound)
+}
+
+func TestDeploymentReady(t *testing.T) {
+	one := int32(1)
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &one},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 2,
+			Replicas:           1,
+			UpdatedReplicas:    1,
+			AvailableReplicas:  1,
+		},
+	}
+	assert.True(t, deploymentReady(dep))
+
+	// An old pod is still terminating during a rollout
+	dep.Status.Replicas = 2
+	assert.False(t, deploymentReady(dep))
+
+	dep.Status.Replicas = 1
+	dep.Status.ObservedGeneration = 1
+	assert.False(t, deploymentReady(dep))
+}
+
+func TestClient_GetServiceTarget(t *testing.T) {
+	releaseName := ReleaseName(testProjectUUID)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "dev-session-550e8400-e29b-41d4-a716-446655440000-dev-session-vscode",
+			Namespace: testProjectUUID,
+			Labels: map[string]string{
+				"app.kubernetes.io/instance": releaseName,
+				"service-type":               EndpointVscode,
+			},
+		},
+		Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 8080}}},
+	}
+
+	client, err := NewClient(zap.NewNop(), "")
+	require.NoError(t, err)
+	client.kube = fake.NewClientset(svc)
+
+	target, err := client.GetServiceTarget(context.Background(), testProjectUUID, releaseName, EndpointVscode)
+	require.NoError(t, err)
+	assert.Equal(t, "http://"+svc.Name+"."+testProjectUUID+".svc:8080", target)
+
+	_, err = client.GetServiceTarget(context.Background(), testProjectUUID, releaseName, EndpointPreview)
+	assert.ErrorIs(t, err, ErrReleaseNotFound)
+}
@@ -0,0 +1,309 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// FakeRelease is the in-memory record FakeRuntime keeps for a dev container
+type FakeRelease struct {
+	Name      string
+	Namespace string
+	ProjectID int
+	UserID    int
+	Status    string
+	Revision  int
+	Restarts  int
+	Endpoints ServiceEndpoints
+}
+
+// FakeRuntime is an in-memory Runtime for tests and local development.
+// It tracks releases in a map and never talks to a cluster. The *Err fields
+// let tests force a specific operation to fail.
+type FakeRuntime struct {
+	mu       sync.Mutex
+	releases map[string]*FakeRelease
+	nextIP   int
+
+	CreateErr error
+	UpdateErr error
+	DeleteErr error
+	ScaleErr  error
+	StatusErr error
+	ListErr   error
+
+	// Ingress gives releases public URLs on a per-session host, as the chart's Ingress would
+	Ingress IngressOptions
+
+	// ServiceTargets overrides the address returned by GetServiceTarget per
+	// endpoint, e.g. to point the proxy at an httptest server
+	ServiceTargets map[string]string
+}
+
+var _ Runtime = (*FakeRuntime)(nil)
+
+// NewFakeRuntime creates an empty in-memory runtime
+func NewFakeRuntime() *FakeRuntime {
+	return &FakeRuntime{
+		releases: make(map[string]*FakeRelease),
+	}
+}
+
+// CreateDevContainer records a new running release for the project
+func (f *FakeRuntime) CreateDevContainer(ctx context.Context, projectUUID string, projectID int, userID int) (*ServiceEndpoints, error) {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.CreateErr != nil {
+		return nil, f.CreateErr
+	}
+
+	releaseName := ReleaseName(projectUUID)
+	if _, ok := f.releases[releaseName]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrReleaseExists, releaseName)
+	}
+
+	f.nextIP++
+	endpoints := defaultEndpoints()
+	if host := f.Ingress.Host(projectUUID); host != "" {
+		endpoints.setHost(host, f.Ingress.TLS)
+	}
+	endpoints.setClusterIP(fmt.Sprintf("10.96.%d.%d", f.nextIP/256, f.nextIP%256))
+
+	f.releases[releaseName] = &FakeRelease{
+		Name:      releaseName,
+		Namespace: projectUUID,
+		ProjectID: projectID,
+		UserID:    userID,
+		Status:    "running",
+		Revision:  1,
+		Endpoints: *endpoints,
+	}
+
+	result := *endpoints
+	return &result, nil
+}
+
+// UpdateContainer bumps the revision of an existing release
+func (f *FakeRuntime) UpdateContainer(ctx context.Context, projectUUID string, projectID int, userID int) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.UpdateErr != nil {
+		return f.UpdateErr
+	}
+
+	rel, ok := f.releases[ReleaseName(projectUUID)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReleaseNotFound, ReleaseName(projectUUID))
+	}
+
+	rel.ProjectID = projectID
+	rel.UserID = userID
+	rel.Revision++
+	return nil
+}
+
+// DeleteDevContainer removes the release for the project
+func (f *FakeRuntime) DeleteDevContainer(ctx context.Context, projectUUID string) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.DeleteErr != nil {
+		return f.DeleteErr
+	}
+
+	releaseName := ReleaseName(projectUUID)
+	if _, ok := f.releases[releaseName]; !ok {
+		return fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	}
+
+	delete(f.releases, releaseName)
+	return nil
+}
+
+// StopDevContainer marks the release as stopped
+func (f *FakeRuntime) StopDevContainer(ctx context.Context, projectUUID string) error {
+	return f.scale(projectUUID, "stopped")
+}
+
+// StartDevContainer marks the release as running and returns its endpoints
+func (f *FakeRuntime) StartDevContainer(ctx context.Context, projectUUID string) (*ServiceEndpoints, error) {
+	if err := f.scale(projectUUID, "running"); err != nil {
+		return nil, err
+	}
+	return f.GetServiceEndpoints(ctx, projectUUID, ReleaseName(projectUUID))
+}
+
+// RestartDevContainer counts a restart of the release
+func (f *FakeRuntime) RestartDevContainer(ctx context.Context, projectUUID string) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ScaleErr != nil {
+		return f.ScaleErr
+	}
+
+	rel, ok := f.releases[ReleaseName(projectUUID)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReleaseNotFound, ReleaseName(projectUUID))
+	}
+
+	rel.Restarts++
+	rel.Status = "running"
+	return nil
+}
+
+// scale sets the status of a release the way scaling its Deployment would
+func (f *FakeRuntime) scale(projectUUID string, status string) error {
+	if err := validateProjectUUID(projectUUID); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ScaleErr != nil {
+		return f.ScaleErr
+	}
+
+	rel, ok := f.releases[ReleaseName(projectUUID)]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrReleaseNotFound, ReleaseName(projectUUID))
+	}
+
+	rel.Status = status
+	return nil
+}
+
+// GetContainerStatus returns the recorded status of a release
+func (f *FakeRuntime) GetContainerStatus(ctx context.Context, namespace string, releaseName string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.StatusErr != nil {
+		return "error", f.StatusErr
+	}
+
+	rel, ok := f.releases[releaseName]
+	if !ok || rel.Namespace != namespace {
+		return "error", fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	}
+
+	return rel.Status, nil
+}
+
+// GetServiceEndpoints returns the recorded endpoints of a release
+func (f *FakeRuntime) GetServiceEndpoints(ctx context.Context, namespace string, releaseName string) (*ServiceEndpoints, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rel, ok := f.releases[releaseName]
+	if !ok || rel.Namespace != namespace {
+		return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	}
+
+	result := rel.Endpoints
+	return &result, nil
+}
+
+// ListReleases returns all recorded releases ordered by name
+func (f *FakeRuntime) ListReleases(ctx context.Context) ([]Release, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.ListErr != nil {
+		return nil, f.ListErr
+	}
+
+	releases := make([]Release, 0, len(f.releases))
+	for _, rel := range f.releases {
+		projectUUID, _ := ProjectUUIDFromRelease(rel.Name)
+		releases = append(releases, Release{
+			Name:        rel.Name,
+			Namespace:   rel.Namespace,
+			ProjectUUID: projectUUID,
+			ProjectID:   rel.ProjectID,
+			UserID:      rel.UserID,
+			Status:      rel.Status,
+		})
+	}
+
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Name < releases[j].Name
+	})
+
+	return releases, nil
+}
+
+// GetServiceTarget returns the override from ServiceTargets, or a cluster DNS
+// style address for the release's service
+func (f *FakeRuntime) GetServiceTarget(ctx context.Context, namespace string, releaseName string, endpoint string) (string, error) {
+	if err := validateEndpoint(endpoint); err != nil {
+		return "", err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rel, ok := f.releases[releaseName]
+	if !ok || rel.Namespace != namespace {
+		return "", fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseName)
+	}
+
+	if target, ok := f.ServiceTargets[endpoint]; ok {
+		return target, nil
+	}
+
+	return fmt.Sprintf("http://%s-%s.%s.svc:%d", releaseName, endpoint, namespace, fakeServicePorts[endpoint]), nil
+}
+
+// fakeServicePorts mirrors the default service ports in the chart values
+var fakeServicePorts = map[string]int{
+	EndpointPreview: 3000,
+	EndpointChat:    3001,
+	EndpointVscode:  8080,
+}
+
+// Release returns a copy of the named release, if it exists
+func (f *FakeRuntime) Release(releaseName string) (FakeRelease, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rel, ok := f.releases[releaseName]
+	if !ok {
+		return FakeRelease{}, false
+	}
+	return *rel, true
+}
+
+// SetStatus overrides the status of a release, e.g. to simulate a failure
+func (f *FakeRuntime) SetStatus(releaseName string, status string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	rel, ok := f.releases[releaseName]
+	if !ok {
+		return false
+	}
+	rel.Status = status
+	return true
+}
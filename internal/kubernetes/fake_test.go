@@ -0,0 +1,141 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testProjectUUID = "550e8400-e29b-41d4-a716-446655440000"
+
+func TestFakeRuntime_Lifecycle(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime()
+	releaseName := ReleaseName(testProjectUUID)
+
+	endpoints, err := rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+	assert.NotEmpty(t, endpoints.ClusterIP)
+	assert.Equal(t, "http://"+endpoints.ClusterIP+"/preview", endpoints.PreviewURL)
+
+	_, err = rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	assert.ErrorIs(t, err, ErrReleaseExists)
+
+	status, err := rt.GetContainerStatus(ctx, testProjectUUID, releaseName)
+	require.NoError(t, err)
+	assert.Equal(t, "running", status)
+
+	got, err := rt.GetServiceEndpoints(ctx, testProjectUUID, releaseName)
+	require.NoError(t, err)
+	assert.Equal(t, endpoints, got)
+
+	require.NoError(t, rt.UpdateContainer(ctx, testProjectUUID, 3, 4))
+	rel, ok := rt.Release(releaseName)
+	require.True(t, ok)
+	assert.Equal(t, 2, rel.Revision)
+	assert.Equal(t, 3, rel.ProjectID)
+
+	require.NoError(t, rt.DeleteDevContainer(ctx, testProjectUUID))
+	_, ok = rt.Release(releaseName)
+	assert.False(t, ok)
+
+	assert.ErrorIs(t, rt.DeleteDevContainer(ctx, testProjectUUID), ErrReleaseNotFound)
+	_, err = rt.GetContainerStatus(ctx, testProjectUUID, releaseName)
+	assert.ErrorIs(t, err, ErrReleaseNotFound)
+}
+
+func TestFakeRuntime_InvalidProjectUUID(t *testing.T) {
+	rt := NewFakeRuntime()
+
+	_, err := rt.CreateDevContainer(context.Background(), "not-a-uuid; rm -rf /", 1, 2)
+	assert.ErrorIs(t, err, ErrInvalidProjectUUID)
+}
+
+func TestFakeRuntime_InjectedErrors(t *testing.T) {
+	rt := NewFakeRuntime()
+	rt.CreateErr = errors.New("boom")
+
+	_, err := rt.CreateDevContainer(context.Background(), testProjectUUID, 1, 2)
+	assert.EqualError(t, err, "boom")
+}
+
+func TestFakeRuntime_ListReleases(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime()
+
+	other := "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	_, err := rt.CreateDevContainer(ctx, other, 5, 6)
+	require.NoError(t, err)
+	_, err = rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+	rt.SetStatus(ReleaseName(other), "error")
+
+	releases, err := rt.ListReleases(ctx)
+	require.NoError(t, err)
+	require.Len(t, releases, 2)
+
+	assert.Equal(t, Release{
+		Name:        ReleaseName(testProjectUUID),
+		Namespace:   testProjectUUID,
+		ProjectUUID: testProjectUUID,
+		ProjectID:   1,
+		UserID:      2,
+		Status:      "running",
+	}, releases[0])
+	assert.Equal(t, other, releases[1].ProjectUUID)
+	assert.Equal(t, "error", releases[1].Status)
+}
+
+func TestFakeRuntime_StopStartRestart(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime()
+	releaseName := ReleaseName(testProjectUUID)
+
+	created, err := rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, rt.StopDevContainer(ctx, testProjectUUID))
+	status, err := rt.GetContainerStatus(ctx, testProjectUUID, releaseName)
+	require.NoError(t, err)
+	assert.Equal(t, "stopped", status)
+
+	endpoints, err := rt.StartDevContainer(ctx, testProjectUUID)
+	require.NoError(t, err)
+	assert.Equal(t, created, endpoints)
+
+	require.NoError(t, rt.RestartDevContainer(ctx, testProjectUUID))
+	rel, ok := rt.Release(releaseName)
+	require.True(t, ok)
+	assert.Equal(t, "running", rel.Status)
+	assert.Equal(t, 1, rel.Restarts)
+
+	require.NoError(t, rt.DeleteDevContainer(ctx, testProjectUUID))
+	assert.ErrorIs(t, rt.StopDevContainer(ctx, testProjectUUID), ErrReleaseNotFound)
+}
+
+func TestFakeRuntime_GetServiceTarget(t *testing.T) {
+	ctx := context.Background()
+	rt := NewFakeRuntime()
+	releaseName := ReleaseName(testProjectUUID)
+
+	_, err := rt.GetServiceTarget(ctx, testProjectUUID, releaseName, EndpointChat)
+	assert.ErrorIs(t, err, ErrReleaseNotFound)
+
+	_, err = rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+
+	target, err := rt.GetServiceTarget(ctx, testProjectUUID, releaseName, EndpointChat)
+	require.NoError(t, err)
+	assert.Equal(t, "http://"+releaseName+"-chat."+testProjectUUID+".svc:3001", target)
+
+	rt.ServiceTargets = map[string]string{EndpointChat: "http://127.0.0.1:9999"}
+	target, err = rt.GetServiceTarget(ctx, testProjectUUID, releaseName, EndpointChat)
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:9999", target)
+
+	_, err = rt.GetServiceTarget(ctx, testProjectUUID, releaseName, "admin")
+	assert.ErrorIs(t, err, ErrUnknownEndpoint)
+}
@@ -0,0 +1,127 @@
+package kubernetes
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+const (
+	// hostPlaceholder is replaced by the project UUID in IngressOptions.HostTemplate
+	hostPlaceholder = "{uuid}"
+	// defaultTLSSecretName is used when TLS is enabled without a secret name
+	defaultTLSSecretName = "dev-session-tls"
+	// clusterIssuerAnnotation asks cert-manager to issue the Ingress certificate
+	clusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+)
+
+// IngressOptions configures the per-session Ingress rendered by the chart.
+// Each session gets its own hostname built from HostTemplate.
+type IngressOptions struct {
+	Enabled       bool
+	ClassName     string
+	HostTemplate  string // e.g. {uuid}.dev.example.com
+	TLS           bool
+	TLSSecretName string // Secret in the project namespace holding the certificate
+	ClusterIssuer string // Optional cert-manager ClusterIssuer
+	// AuthURL is the forward-auth endpoint ingress-nginx verifies requests
+	// with; empty leaves the Ingress unauthenticated
+	AuthURL string
+}
+
+// Host returns the session hostname for a project, or "" if no Ingress is rendered
+func (o IngressOptions) Host(projectUUID string) string {
+	if !o.Enabled || o.HostTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(o.HostTemplate, hostPlaceholder, projectUUID)
+}
+
+// values returns the chart's ingress values for a project
+func (o IngressOptions) values(projectUUID string) map[string]interface{} {
+	host := o.Host(projectUUID)
+	if host == "" {
+		return map[string]interface{}{"enabled": false}
+	}
+
+	annotations := map[string]interface{}{}
+	tls := []interface{}{}
+	if o.TLS {
+		secretName := o.TLSSecretName
+		if secretName == "" {
+			secretName = defaultTLSSecretName
+		}
+		tls = append(tls, map[string]interface{}{
+			"hosts":      []interface{}{host},
+			"secretName": secretName,
+		})
+		if o.ClusterIssuer != "" {
+			annotations[clusterIssuerAnnotation] = o.ClusterIssuer
+		}
+	}
+
+	return map[string]interface{}{
+		"enabled":     true,
+		"className":   o.ClassName,
+		"annotations": annotations,
+		"hosts":       []interface{}{map[string]interface{}{"host": host}},
+		"tls":         tls,
+		"auth": map[string]interface{}{
+			"enabled": o.AuthURL != "",
+			"url":     o.AuthURL,
+		},
+	}
+}
+
+// applyIngress points the public endpoint URLs at the Ingress host and takes
+// the endpoint paths from its rules. Backends are matched on the -preview,
+// -chat and -vscode service name suffixes the chart uses.
+func applyIngress(endpoints *ServiceEndpoints, ing *networkingv1.Ingress) {
+	for _, rule := range ing.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+
+		if rule.HTTP != nil {
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service == nil || path.Path == "" {
+					continue
+				}
+				switch name := path.Backend.Service.Name; {
+				case strings.HasSuffix(name, "-"+EndpointPreview):
+					endpoints.PreviewPath = path.Path
+				case strings.HasSuffix(name, "-"+EndpointChat):
+					endpoints.ChatPath = path.Path
+				case strings.HasSuffix(name, "-"+EndpointVscode):
+					endpoints.VscodePath = path.Path
+				}
+			}
+		}
+
+		tls := false
+		for _, entry := range ing.Spec.TLS {
+			if slices.Contains(entry.Hosts, rule.Host) {
+				tls = true
+				break
+			}
+		}
+
+		endpoints.setHost(rule.Host, tls)
+		return
+	}
+}
+
+// setHost sets the public endpoint URLs to the given Ingress host
+func (e *ServiceEndpoints) setHost(host string, tls bool) {
+	scheme := "http"
+	if tls {
+		scheme = "https"
+	}
+
+	e.Host = host
+	e.PreviewURL = fmt.Sprintf("%s://%s%s", scheme, host, e.PreviewPath)
+	e.ChatURL = fmt.Sprintf("%s://%s%s", scheme, host, e.ChatPath)
+	e.VscodeURL = fmt.Sprintf("%s://%s%s", scheme, host, e.VscodePath)
+}
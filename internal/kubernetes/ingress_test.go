@@ -0,0 +1,153 @@
+package kubernetes
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestIngressOptions_Values(t *testing.T) {
+	opts := IngressOptions{
+		Enabled:       true,
+		ClassName:     "nginx",
+		HostTemplate:  "{uuid}.dev.example.com",
+		TLS:           true,
+		ClusterIssuer: "letsencrypt",
+	}
+
+	assert.Equal(t, testProjectUUID+".dev.example.com", opts.Host(testProjectUUID))
+
+	values := opts.values(testProjectUUID)
+	assert.Equal(t, true, values["enabled"])
+	assert.Equal(t, "nginx", values["className"])
+	assert.Equal(t, map[string]interface{}{clusterIssuerAnnotation: "letsencrypt"}, values["annotations"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"host": testProjectUUID + ".dev.example.com"}}, values["hosts"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"hosts":      []interface{}{testProjectUUID + ".dev.example.com"},
+		"secretName": defaultTLSSecretName,
+	}}, values["tls"])
+	assert.Equal(t, map[string]interface{}{"enabled": false, "url": ""}, values["auth"])
+
+	opts.AuthURL = "http://dev-session-service.default.svc/api/v1/auth/verify"
+	assert.Equal(t, map[string]interface{}{"enabled": true, "url": opts.AuthURL}, opts.values(testProjectUUID)["auth"])
+
+	opts.Enabled = false
+	assert.Empty(t, opts.Host(testProjectUUID))
+	assert.Equal(t, map[string]interface{}{"enabled": false}, opts.values(testProjectUUID))
+}
+
+func TestClient_GetServiceEndpointsFromIngress(t *testing.T) {
+	releaseName := ReleaseName(testProjectUUID)
+	host := testProjectUUID + ".dev.example.com"
+	labels := map[string]string{"app.kubernetes.io/instance": releaseName}
+	prefix := networkingv1.PathTypePrefix
+
+	backend := func(name string) networkingv1.IngressBackend {
+		return networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: name}}
+	}
+
+	ing := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: releaseName, Namespace: testProjectUUID, Labels: labels},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{host}, SecretName: defaultTLSSecretName}},
+			Rules: []networkingv1.IngressRule{{
+				Host: host,
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{
+						{Path: "/app", PathType: &prefix, Backend: backend(releaseName + "-preview")},
+						{Path: "/chat", PathType: &prefix, Backend: backend(releaseName + "-chat")},
+						{Path: "/vscode", PathType: &prefix, Backend: backend(releaseName + "-vscode")},
+					},
+				}},
+			}},
+		},
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      releaseName + "-lb",
+			Namespace: testProjectUUID,
+			Labels:    map[string]string{"app.kubernetes.io/instance": releaseName, "service-type": "loadbalancer"},
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+
+	client, err := NewClient(zap.NewNop(), "")
+	require.NoError(t, err)
+	client.kube = fake.NewClientset(ing, svc)
+
+	endpoints, err := client.GetServiceEndpoints(context.Background(), testProjectUUID, releaseName)
+	require.NoError(t, err)
+
+	assert.Equal(t, host, endpoints.Host)
+	assert.Equal(t, "https://"+host+"/app", endpoints.PreviewURL)
+	assert.Equal(t, "https://"+host+"/chat", endpoints.ChatURL)
+	assert.Equal(t, "https://"+host+"/vscode", endpoints.VscodeURL)
+	assert.Equal(t, "/app", endpoints.PreviewPath)
+
+	assert.Equal(t, "10.96.0.10", endpoints.ClusterIP)
+	assert.Equal(t, "http://10.96.0.10/app", endpoints.InternalPreviewURL)
+	assert.Equal(t, "http://10.96.0.10/vscode", endpoints.InternalVscodeURL)
+}
+
+func TestClient_GetServiceEndpointsWithoutIngress(t *testing.T) {
+	releaseName := ReleaseName(testProjectUUID)
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      releaseName + "-lb",
+			Namespace: testProjectUUID,
+			Labels:    map[string]string{"app.kubernetes.io/instance": releaseName, "service-type": "loadbalancer"},
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: "10.96.0.10"},
+	}
+
+	client, err := NewClient(zap.NewNop(), "")
+	require.NoError(t, err)
+	client.kube = fake.NewClientset(svc)
+
+	endpoints, err := client.GetServiceEndpoints(context.Background(), testProjectUUID, releaseName)
+	require.NoError(t, err)
+
+	assert.Empty(t, endpoints.Host)
+	assert.Equal(t, "http://10.96.0.10/preview", endpoints.PreviewURL)
+	assert.Equal(t, endpoints.PreviewURL, endpoints.InternalPreviewURL)
+}
+
+func TestFakeRuntime_Ingress(t *testing.T) {
+	rt := NewFakeRuntime()
+	rt.Ingress = IngressOptions{Enabled: true, HostTemplate: "{uuid}.dev.example.com"}
+
+	endpoints, err := rt.CreateDevContainer(context.Background(), testProjectUUID, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "http://"+testProjectUUID+".dev.example.com/chat", endpoints.ChatURL)
+	assert.Equal(t, "http://"+endpoints.ClusterIP+"/chat", endpoints.InternalChatURL)
+}
+
+func TestChart_IngressKeepsPathPrefixes(t *testing.T) {
+	chart, err := loader.Load("../../helm/dev-session-template")
+	require.NoError(t, err)
+
+	opts := IngressOptions{Enabled: true, ClassName: "nginx", HostTemplate: "{uuid}.dev.example.com", AuthURL: "http://auth/verify"}
+	values, err := chartutil.ToRenderValues(chart, chartValues(testProjectUUID, 1, 2, opts),
+		chartutil.ReleaseOptions{Name: ReleaseName(testProjectUUID), Namespace: testProjectUUID}, nil)
+	require.NoError(t, err)
+	rendered, err := engine.Render(chart, values)
+	require.NoError(t, err)
+
+	// Requests reach the services with their path prefix, as through the proxy
+	ingress := rendered["dev-session-template/templates/ingress.yaml"]
+	assert.NotContains(t, ingress, "rewrite-target")
+	for _, path := range []string{"/preview", "/chat", "/vscode"} {
+		assert.Contains(t, ingress, "- path: "+path+"\n          pathType: Prefix")
+	}
+}
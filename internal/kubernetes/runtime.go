@@ -0,0 +1,157 @@
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// releasePrefix is prepended to the project UUID to form a release name
+const releasePrefix = "dev-session-"
+
+var (
+	// ErrInvalidProjectUUID is returned when a project UUID is not a well-formed UUID
+	ErrInvalidProjectUUID = errors.New("invalid project UUID")
+	// ErrReleaseNotFound is returned when no dev container exists for a project
+	ErrReleaseNotFound = errors.New("dev container release not found")
+	// ErrReleaseExists is returned when a dev container already exists for a project
+	ErrReleaseExists = errors.New("dev container release already exists")
+	// ErrUnknownEndpoint is returned for endpoints other than preview, chat and vscode
+	ErrUnknownEndpoint = errors.New("unknown dev container endpoint")
+)
+
+// Endpoints served by every dev container, matching the chart's service-type labels
+const (
+	EndpointPreview = "preview"
+	EndpointChat    = "chat"
+	EndpointVscode  = "vscode"
+)
+
+// validateEndpoint wraps ErrUnknownEndpoint with the offending value
+func validateEndpoint(endpoint string) error {
+	switch endpoint {
+	case EndpointPreview, EndpointChat, EndpointVscode:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownEndpoint, endpoint)
+	}
+}
+
+// Runtime manages the lifecycle of dev containers.
+// SessionHandler depends on this interface so the Helm backed Client can be
+// swapped for FakeRuntime in tests and local development.
+type Runtime interface {
+	// CreateDevContainer installs a dev container for the project and returns its endpoints
+	CreateDevContainer(ctx context.Context, projectUUID string, projectID int, userID int) (*ServiceEndpoints, error)
+	// UpdateContainer upgrades an existing dev container with the latest chart and values
+	UpdateContainer(ctx context.Context, projectUUID string, projectID int, userID int) error
+	// DeleteDevContainer removes the dev container for the project
+	DeleteDevContainer(ctx context.Context, projectUUID string) error
+	// StopDevContainer scales the dev container to zero, keeping its workspace volume
+	StopDevContainer(ctx context.Context, projectUUID string) error
+	// StartDevContainer scales a stopped dev container back up and returns its endpoints
+	StartDevContainer(ctx context.Context, projectUUID string) (*ServiceEndpoints, error)
+	// RestartDevContainer replaces the dev container's pod
+	RestartDevContainer(ctx context.Context, projectUUID string) error
+	// GetContainerStatus returns the session status (pending, running, stopped, error, unknown) of a release
+	GetContainerStatus(ctx context.Context, namespace string, releaseName string) (string, error)
+	// GetServiceEndpoints returns the endpoints exposed by a release
+	GetServiceEndpoints(ctx context.Context, namespace string, releaseName string) (*ServiceEndpoints, error)
+	// ListReleases returns every dev container release in the cluster
+	ListReleases(ctx context.Context) ([]Release, error)
+	// GetServiceTarget returns the in-cluster base URL of a release's preview, chat or vscode service
+	GetServiceTarget(ctx context.Context, namespace string, releaseName string, endpoint string) (string, error)
+}
+
+// Release describes a dev container release found in the cluster
+type Release struct {
+	Name        string
+	Namespace   string
+	ProjectUUID string
+	ProjectID   int
+	UserID      int
+	Status      string // pending, running, stopped, error, unknown
+}
+
+// ServiceEndpoints holds the service endpoint information.
+// The public URLs use the session's Ingress host when it has one and fall
+// back to the load balancer ClusterIP otherwise.
+type ServiceEndpoints struct {
+	PreviewURL  string
+	PreviewPath string
+	ChatURL     string
+	ChatPath    string
+	VscodeURL   string
+	VscodePath  string
+	ClusterIP   string
+
+	// Host is the session's Ingress hostname, empty without an Ingress
+	Host string
+	// Internal URLs reach the load balancer ClusterIP from inside the cluster
+	InternalPreviewURL string
+	InternalChatURL    string
+	InternalVscodeURL  string
+}
+
+// ReleaseName returns the Helm release name used for a project's dev container
+func ReleaseName(projectUUID string) string {
+	return releasePrefix + projectUUID
+}
+
+// ProjectUUIDFromRelease extracts the project UUID from a dev container
+// release name. It returns false for releases this service does not manage.
+func ProjectUUIDFromRelease(releaseName string) (string, bool) {
+	projectUUID, ok := strings.CutPrefix(releaseName, releasePrefix)
+	if !ok || !isValidProjectUUID(projectUUID) {
+		return "", false
+	}
+	return projectUUID, true
+}
+
+var projectUUIDPattern = regexp.MustCompile(`^[a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12}$`)
+
+// isValidProjectUUID validates that a project UUID contains only safe characters
+func isValidProjectUUID(uuid string) bool {
+	// UUID format: 8-4-4-4-12 hex digits with hyphens
+	return projectUUIDPattern.MatchString(uuid)
+}
+
+// validateProjectUUID wraps ErrInvalidProjectUUID with the offending value
+func validateProjectUUID(projectUUID string) error {
+	if !isValidProjectUUID(projectUUID) {
+		return fmt.Errorf("%w: %q", ErrInvalidProjectUUID, projectUUID)
+	}
+	return nil
+}
+
+// defaultEndpoints returns the endpoint paths used when no address is known yet
+func defaultEndpoints() *ServiceEndpoints {
+	return &ServiceEndpoints{
+		PreviewPath: "/preview",
+		ChatPath:    "/chat",
+		VscodePath:  "/vscode",
+	}
+}
+
+// endpointsForIP builds the endpoints served by the load balancer service at the given IP
+func endpointsForIP(clusterIP string) *ServiceEndpoints {
+	endpoints := defaultEndpoints()
+	endpoints.setClusterIP(clusterIP)
+	return endpoints
+}
+
+// setClusterIP sets the internal URLs, and the public ones too while no Ingress host is known
+func (e *ServiceEndpoints) setClusterIP(clusterIP string) {
+	e.ClusterIP = clusterIP
+	e.InternalPreviewURL = fmt.Sprintf("http://%s%s", clusterIP, e.PreviewPath)
+	e.InternalChatURL = fmt.Sprintf("http://%s%s", clusterIP, e.ChatPath)
+	e.InternalVscodeURL = fmt.Sprintf("http://%s%s", clusterIP, e.VscodePath)
+
+	if e.Host == "" {
+		e.PreviewURL = e.InternalPreviewURL
+		e.ChatURL = e.InternalChatURL
+		e.VscodeURL = e.InternalVscodeURL
+	}
+}
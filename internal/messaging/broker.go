@@ -0,0 +1,65 @@
+package messaging
+
+import (
+	"context"
+	"strings"
+)
+
+// Broker publishes, consumes and answers messages on a topic exchange.
+// *RabbitMQ talks to a RabbitMQ server; *MemoryBus routes messages in
+// process for tests and local runs.
+type Broker interface {
+	Publisher
+	Replier
+	ConnectionState
+	DeadLetterStore
+
+	// Consume delivers messages routed to the service queue to handler until
+	// ctx is done. A message whose handler fails is retried and eventually
+	// dead-lettered.
+	Consume(ctx context.Context, handler func(context.Context, Message) error) error
+	// Request publishes a message with a reply-to address and waits for the
+	// reply carrying its correlation ID
+	Request(ctx context.Context, routingKey string, body []byte) (Message, error)
+	// Close releases the broker's resources
+	Close() error
+}
+
+var (
+	_ Broker = (*RabbitMQ)(nil)
+	_ Broker = (*MemoryBus)(nil)
+)
+
+// MatchTopic reports whether a routing key matches a topic exchange binding
+// pattern. Words are separated by dots; * matches exactly one word and #
+// matches zero or more words.
+func MatchTopic(pattern, routingKey string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(routingKey, "."))
+}
+
+// matchWords matches the remaining pattern words against the remaining key words
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// Try every possible number of words for #
+			for i := 0; i <= len(key); i++ {
+				if matchWords(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		key = key[1:]
+	}
+	return len(key) == 0
+}
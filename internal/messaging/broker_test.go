@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		routingKey string
+		match      bool
+	}{
+		{"dev_session.command.create", "dev_session.command.create", true},
+		{"dev_session.command.create", "dev_session.command.delete", false},
+		{"dev_session.command.*", "dev_session.command.create", true},
+		{"dev_session.command.*", "dev_session.command", false},
+		{"dev_session.command.*", "dev_session.command.create.now", false},
+		{"dev_session.*.create", "dev_session.command.create", true},
+		{"dev_session.command.#", "dev_session.command", true},
+		{"dev_session.command.#", "dev_session.command.create", true},
+		{"dev_session.command.#", "dev_session.command.create.now", true},
+		{"dev_session.command.#", "dev_session.running", false},
+		{"#.running", "dev_session.running", true},
+		{"#.running", "running", true},
+		{"dev_session.#.now", "dev_session.command.create.now", true},
+		{"dev_session.#.now", "dev_session.now", true},
+		{"dev_session.#.now", "dev_session.command.create", false},
+		{"#", "anything.at.all", true},
+		{"#", "", true},
+		{"*", "one", true},
+		{"*", "one.two", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.routingKey, func(t *testing.T) {
+			assert.Equal(t, tt.match, MatchTopic(tt.pattern, tt.routingKey))
+		})
+	}
+}
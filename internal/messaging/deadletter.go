@@ -0,0 +1,171 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"go.uber.org/zap"
+)
+
+// DeadLetter is a message that failed every handling attempt
+type DeadLetter struct {
+	MessageID      string     `json:"message_id,omitempty"`
+	RoutingKey     string     `json:"routing_key"`
+	CorrelationID  string     `json:"correlation_id,omitempty"`
+	ReplyTo        string     `json:"reply_to,omitempty"`
+	Attempts       int        `json:"attempts"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeadLetteredAt *time.Time `json:"dead_lettered_at,omitempty"`
+	Body           string     `json:"body"`
+}
+
+// DeadLetterStore inspects, replays and purges dead-lettered messages.
+// *RabbitMQ and *MemoryBus implement it.
+type DeadLetterStore interface {
+	DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+	ReplayDeadLetters(ctx context.Context, limit int) (int, error)
+	PurgeDeadLetters(ctx context.Context) (int, error)
+}
+
+// newDeadLetter describes a delivery from the dead-letter queue
+func newDeadLetter(msg amqp091.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageID:     msg.MessageId,
+		RoutingKey:    originalRoutingKey(msg),
+		CorrelationID: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		Attempts:      attempts(msg.Headers),
+		Body:          string(msg.Body),
+	}
+	if lastError, ok := msg.Headers[headerLastError].(string); ok {
+		letter.LastError = lastError
+	}
+	if value, ok := msg.Headers[headerDeadLetteredAt].(string); ok {
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			letter.DeadLetteredAt = &at
+		}
+	}
+	return letter
+}
+
+// DeadLetters returns up to limit dead-lettered messages without removing them
+// from the queue
+func (r *RabbitMQ) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	conn, _, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+
+	// A separate channel keeps the fetched messages unacked until they are
+	// returned and away from the consumer's channel
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer channel.Close()
+
+	letters := []DeadLetter{}
+	var lastTag uint64
+	for len(letters) < limit {
+		msg, ok, err := channel.Get(deadLetterQueueName(r.config.Queue), false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, newDeadLetter(msg))
+		lastTag = msg.DeliveryTag
+	}
+
+	if lastTag != 0 {
+		if err := channel.Nack(lastTag, true, true); err != nil {
+			return nil, fmt.Errorf("failed to return dead letters: %w", err)
+		}
+	}
+
+	return letters, nil
+}
+
+// ReplayDeadLetters publishes up to limit dead-lettered messages to the
+// exchange again with their original routing key and a fresh attempt count.
+// It returns the number of messages replayed.
+func (r *RabbitMQ) ReplayDeadLetters(ctx context.Context, limit int) (int, error) {
+	conn, _, err := r.current()
+	if err != nil {
+		return 0, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer channel.Close()
+
+	// A dead letter is only acked once the broker confirms its replay
+	if err := channel.Confirm(false); err != nil {
+		return 0, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	replayed := 0
+	for replayed < limit {
+		msg, ok, err := channel.Get(deadLetterQueueName(r.config.Queue), false)
+		if err != nil {
+			return replayed, fmt.Errorf("failed to read dead-letter queue: %w", err)
+		}
+		if !ok {
+			break
+		}
+
+		headers := amqp091.Table{}
+		for key, value := range msg.Headers {
+			headers[key] = value
+		}
+		delete(headers, headerAttempts)
+		delete(headers, headerLastError)
+		delete(headers, headerOriginalRoutingKey)
+		delete(headers, headerDeadLetteredAt)
+
+		routingKey := originalRoutingKey(msg)
+		confirmCtx, cancel := context.WithTimeout(ctx, confirmTimeout)
+		err = publishConfirmed(confirmCtx, channel, r.config.Exchange, routingKey, republished(msg, headers))
+		cancel()
+		if err != nil {
+			msg.Nack(false, true)
+			return replayed, fmt.Errorf("failed to replay message: %w", err)
+		}
+		if err := msg.Ack(false); err != nil {
+			return replayed, fmt.Errorf("failed to ack replayed message: %w", err)
+		}
+		replayed++
+
+		r.log.Info("Dead letter replayed",
+			zap.String("routing_key", routingKey),
+			zap.String("message_id", msg.MessageId))
+	}
+
+	return replayed, nil
+}
+
+// PurgeDeadLetters drops every dead-lettered message and returns how many were dropped
+func (r *RabbitMQ) PurgeDeadLetters(ctx context.Context) (int, error) {
+	conn, _, err := r.current()
+	if err != nil {
+		return 0, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer channel.Close()
+
+	purged, err := channel.QueuePurge(deadLetterQueueName(r.config.Queue), false)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge dead-letter queue: %w", err)
+	}
+
+	r.log.Info("Dead-letter queue purged", zap.Int("messages", purged))
+
+	return purged, nil
+}
@@ -0,0 +1,328 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// memoryQueueSize is the number of messages the service queue buffers
+	memoryQueueSize = 1024
+	// memorySubscriptionSize is the number of messages a subscription buffers
+	// before further messages are dropped
+	memorySubscriptionSize = 256
+)
+
+// memoryMessage is a queued message together with its delivery history
+type memoryMessage struct {
+	Message
+	attempts       int
+	lastError      string
+	deadLetteredAt time.Time
+}
+
+// subscription receives copies of the messages matching its pattern
+type subscription struct {
+	pattern string
+	ch      chan Message
+}
+
+// MemoryBus is an in-process Broker that behaves like the topic exchange
+// and queues declared on RabbitMQ: the service queue receives messages
+// whose routing key matches rabbitmq.commands, failed messages are retried
+// with the configured backoff and then dead-lettered, and replies are routed
+// to the reply-to address of a pending Request.
+type MemoryBus struct {
+	config      *config.RabbitMQConfig
+	retryPolicy retryPolicy
+	log         *zap.Logger
+
+	queue chan memoryMessage
+	done  chan struct{}
+
+	mu            sync.Mutex
+	subscriptions map[*subscription]struct{}
+	replyQueues   map[string]chan Message
+	deadLetters   []memoryMessage
+	closeOnce     sync.Once
+}
+
+// NewMemoryBus creates an in-process message bus
+func NewMemoryBus(cfg *config.RabbitMQConfig, log *zap.Logger) *MemoryBus {
+	return &MemoryBus{
+		config:        cfg,
+		retryPolicy:   newRetryPolicy(&cfg.Retry),
+		log:           log,
+		queue:         make(chan memoryMessage, memoryQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: map[*subscription]struct{}{},
+		replyQueues:   map[string]chan Message{},
+	}
+}
+
+// Publish routes a message to the service queue if its routing key matches
+// the command binding, and to every matching subscription
+func (b *MemoryBus) Publish(ctx context.Context, routingKey string, body []byte) error {
+	return b.publish(ctx, Message{
+		Body:       append([]byte(nil), body...),
+		RoutingKey: routingKey,
+	})
+}
+
+// publish routes a message
+func (b *MemoryBus) publish(ctx context.Context, msg Message) error {
+	if b.closed() {
+		return ErrClosed
+	}
+
+	b.mu.Lock()
+	for sub := range b.subscriptions {
+		if !MatchTopic(sub.pattern, msg.RoutingKey) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			b.log.Warn("Subscription is full, dropping message",
+				zap.String("pattern", sub.pattern),
+				zap.String("routing_key", msg.RoutingKey))
+		}
+	}
+	b.mu.Unlock()
+
+	if !MatchTopic(b.config.CommandRoutingKey(), msg.RoutingKey) {
+		return nil
+	}
+
+	return b.enqueue(ctx, memoryMessage{Message: msg})
+}
+
+// enqueue adds a message to the service queue
+func (b *MemoryBus) enqueue(ctx context.Context, msg memoryMessage) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.done:
+		return ErrClosed
+	case b.queue <- msg:
+		return nil
+	}
+}
+
+// Subscribe returns a channel receiving a copy of every message published
+// with a routing key matching pattern, like a queue bound to the exchange.
+// The returned function removes the subscription.
+func (b *MemoryBus) Subscribe(pattern string) (<-chan Message, func()) {
+	sub := &subscription{pattern: pattern, ch: make(chan Message, memorySubscriptionSize)}
+
+	b.mu.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub.ch, func() {
+		b.mu.Lock()
+		delete(b.subscriptions, sub)
+		b.mu.Unlock()
+	}
+}
+
+// Reply delivers body to the pending Request waiting on the message's
+// reply-to address. Like the default exchange, it drops replies nobody is
+// waiting for.
+func (b *MemoryBus) Reply(ctx context.Context, msg Message, body []byte) error {
+	if msg.ReplyTo == "" {
+		return nil
+	}
+	if b.closed() {
+		return ErrClosed
+	}
+
+	b.mu.Lock()
+	replies, ok := b.replyQueues[msg.ReplyTo]
+	b.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case replies <- Message{Body: append([]byte(nil), body...), RoutingKey: msg.ReplyTo, CorrelationID: msg.CorrelationID}:
+	default:
+	}
+	return nil
+}
+
+// Request publishes a message with a private reply-to address and waits for
+// the reply carrying its correlation ID
+func (b *MemoryBus) Request(ctx context.Context, routingKey string, body []byte) (Message, error) {
+	replyTo := "amq.gen-" + uuid.New().String()
+	correlationID := uuid.New().String()
+	replies := make(chan Message, 1)
+
+	b.mu.Lock()
+	b.replyQueues[replyTo] = replies
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.replyQueues, replyTo)
+		b.mu.Unlock()
+	}()
+
+	err := b.publish(ctx, Message{
+		Body:          append([]byte(nil), body...),
+		RoutingKey:    routingKey,
+		MessageID:     uuid.New().String(),
+		ReplyTo:       replyTo,
+		CorrelationID: correlationID,
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-b.done:
+			return Message{}, ErrClosed
+		case reply := <-replies:
+			if reply.CorrelationID == correlationID {
+				return reply, nil
+			}
+		}
+	}
+}
+
+// Consume delivers messages from the service queue to handler until ctx is
+// done or the bus is closed
+func (b *MemoryBus) Consume(ctx context.Context, handler func(context.Context, Message) error) error {
+	b.log.Info("Started consuming messages from in-memory queue", zap.String("queue", b.config.Queue))
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.log.Info("Stopping message consumer")
+			return ctx.Err()
+		case <-b.done:
+			return ErrClosed
+		case msg := <-b.queue:
+			if err := handler(ctx, msg.Message); err != nil {
+				b.retry(msg, err)
+			}
+		}
+	}
+}
+
+// retry requeues a failed message after the retry delay, or dead-letters it
+// once it has used up its attempts
+func (b *MemoryBus) retry(msg memoryMessage, handlerErr error) {
+	msg.attempts++
+	msg.lastError = handlerErr.Error()
+
+	if msg.attempts >= b.retryPolicy.maxAttempts {
+		msg.deadLetteredAt = time.Now().UTC()
+
+		b.mu.Lock()
+		b.deadLetters = append(b.deadLetters, msg)
+		b.mu.Unlock()
+
+		b.log.Warn("Message dead-lettered",
+			zap.String("routing_key", msg.RoutingKey),
+			zap.Int("attempts", msg.attempts),
+			zap.Error(handlerErr))
+		return
+	}
+
+	delay := b.retryPolicy.delay(msg.attempts)
+	b.log.Warn("Message handling failed, retry scheduled",
+		zap.String("routing_key", msg.RoutingKey),
+		zap.Int("attempt", msg.attempts),
+		zap.Duration("delay", delay),
+		zap.Error(handlerErr))
+
+	time.AfterFunc(delay, func() {
+		if err := b.enqueue(context.Background(), msg); err != nil {
+			b.log.Warn("Failed to requeue message", zap.Error(err))
+		}
+	})
+}
+
+// DeadLetters returns up to limit dead-lettered messages without removing them
+func (b *MemoryBus) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	letters := []DeadLetter{}
+	for _, msg := range b.deadLetters[:min(limit, len(b.deadLetters))] {
+		deadLetteredAt := msg.deadLetteredAt
+		letters = append(letters, DeadLetter{
+			MessageID:      msg.MessageID,
+			RoutingKey:     msg.RoutingKey,
+			CorrelationID:  msg.CorrelationID,
+			ReplyTo:        msg.ReplyTo,
+			Attempts:       msg.attempts,
+			LastError:      msg.lastError,
+			DeadLetteredAt: &deadLetteredAt,
+			Body:           string(msg.Body),
+		})
+	}
+	return letters, nil
+}
+
+// ReplayDeadLetters publishes up to limit dead-lettered messages again with
+// a fresh attempt count and returns the number replayed
+func (b *MemoryBus) ReplayDeadLetters(ctx context.Context, limit int) (int, error) {
+	b.mu.Lock()
+	n := min(limit, len(b.deadLetters))
+	replay := append([]memoryMessage(nil), b.deadLetters[:n]...)
+	b.deadLetters = b.deadLetters[n:]
+	b.mu.Unlock()
+
+	for i, msg := range replay {
+		if err := b.publish(ctx, msg.Message); err != nil {
+			// Keep the messages that were not replayed
+			b.mu.Lock()
+			b.deadLetters = append(replay[i:], b.deadLetters...)
+			b.mu.Unlock()
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// PurgeDeadLetters drops every dead-lettered message and returns how many were dropped
+func (b *MemoryBus) PurgeDeadLetters(ctx context.Context) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	purged := len(b.deadLetters)
+	b.deadLetters = nil
+	return purged, nil
+}
+
+// Connected reports whether the bus is open
+func (b *MemoryBus) Connected() bool {
+	return !b.closed()
+}
+
+// Close stops consumers and pending requests. Queued messages are discarded.
+func (b *MemoryBus) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+	return nil
+}
+
+// closed reports whether Close has been called
+func (b *MemoryBus) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,153 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+func newMemoryBus(t *testing.T, maxAttempts int) *MemoryBus {
+	t.Helper()
+
+	bus := NewMemoryBus(&config.RabbitMQConfig{
+		Queue:    "paypilot_queue",
+		Commands: "dev_session.command.#",
+		Retry:    config.RabbitMQRetryConfig{MaxAttempts: maxAttempts},
+	}, zap.NewNop())
+	t.Cleanup(func() { bus.Close() })
+	return bus
+}
+
+// consume runs handler on the bus until the test ends
+func consume(t *testing.T, bus *MemoryBus, handler func(context.Context, Message) error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go bus.Consume(ctx, handler)
+}
+
+func TestMemoryBus_RoutesCommandsToQueue(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	received := make(chan Message, 2)
+	consume(t, bus, func(ctx context.Context, msg Message) error {
+		received <- msg
+		return nil
+	})
+
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.running", []byte(`{}`)))
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.command.create", []byte(`{"type":"create_session"}`)))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "dev_session.command.create", msg.RoutingKey)
+		assert.JSONEq(t, `{"type":"create_session"}`, string(msg.Body))
+	case <-time.After(time.Second):
+		t.Fatal("command was not delivered")
+	}
+	assert.Empty(t, received, "events must not be routed to the command queue")
+}
+
+func TestMemoryBus_Subscribe(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	events, cancel := bus.Subscribe("dev_session.*")
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.running", []byte(`{}`)))
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.command.create", []byte(`{}`)))
+
+	require.Len(t, events, 1)
+	assert.Equal(t, "dev_session.running", (<-events).RoutingKey)
+
+	cancel()
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.stopped", []byte(`{}`)))
+	assert.Empty(t, events)
+}
+
+func TestMemoryBus_RequestReply(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	consume(t, bus, func(ctx context.Context, msg Message) error {
+		assert.NotEmpty(t, msg.MessageID)
+		return bus.Reply(ctx, msg, []byte(`{"ok":true}`))
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	reply, err := bus.Request(ctx, "dev_session.command.create", []byte(`{}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"ok":true}`, string(reply.Body))
+	assert.NotEmpty(t, reply.CorrelationID)
+}
+
+func TestMemoryBus_RequestTimesOut(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := bus.Request(ctx, "dev_session.command.create", []byte(`{}`))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMemoryBus_DeadLetters(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	handled := make(chan struct{}, 2)
+	fail := true
+	consume(t, bus, func(ctx context.Context, msg Message) error {
+		defer func() { handled <- struct{}{} }()
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	require.NoError(t, bus.Publish(context.Background(), "dev_session.command.create", []byte(`{}`)))
+	<-handled
+
+	letters, err := bus.DeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "dev_session.command.create", letters[0].RoutingKey)
+	assert.Equal(t, 1, letters[0].Attempts)
+	assert.Equal(t, "boom", letters[0].LastError)
+	assert.NotNil(t, letters[0].DeadLetteredAt)
+
+	fail = false
+	replayed, err := bus.ReplayDeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, 1, replayed)
+	<-handled
+
+	letters, err = bus.DeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestMemoryBus_PurgeDeadLetters(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+	bus.retry(memoryMessage{Message: Message{RoutingKey: "dev_session.command.create"}}, errors.New("boom"))
+
+	purged, err := bus.PurgeDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+}
+
+func TestMemoryBus_Closed(t *testing.T) {
+	bus := newMemoryBus(t, 1)
+
+	assert.True(t, bus.Connected())
+	assert.NoError(t, bus.Close())
+	assert.NoError(t, bus.Close())
+	assert.False(t, bus.Connected())
+	assert.ErrorIs(t, bus.Publish(context.Background(), "dev_session.command.create", nil), ErrClosed)
+	assert.ErrorIs(t, bus.Consume(context.Background(), nil), ErrClosed)
+}
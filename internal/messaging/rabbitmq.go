@@ -2,46 +2,209 @@ package messaging
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rabbitmq/amqp091-go"
 	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
 	"go.uber.org/zap"
 )
 
-// RabbitMQ represents a RabbitMQ connection
+// Publisher publishes messages with a routing key and returns once the
+// broker has taken responsibility for them. *RabbitMQ implements it.
+type Publisher interface {
+	Publish(ctx context.Context, routingKey string, body []byte) error
+}
+
+// Message is a consumed message together with the properties needed to answer it
+type Message struct {
+	Body          []byte
+	RoutingKey    string
+	MessageID     string
+	ReplyTo       string
+	CorrelationID string
+}
+
+// Replier answers consumed messages on their reply-to queue. *RabbitMQ implements it.
+type Replier interface {
+	Reply(ctx context.Context, msg Message, body []byte) error
+}
+
+// ConnectionState reports whether the broker connection is up. *RabbitMQ implements it.
+type ConnectionState interface {
+	Connected() bool
+}
+
+const (
+	// directReplyTo is RabbitMQ's pseudo-queue for replies to requests
+	directReplyTo = "amq.rabbitmq.reply-to"
+	// reconnectInitialDelay is the wait before the first reconnection attempt
+	reconnectInitialDelay = time.Second
+	// reconnectMaxDelay caps the wait between reconnection attempts
+	reconnectMaxDelay = 30 * time.Second
+	// confirmTimeout bounds the wait for the broker to confirm a retried or
+	// replayed message before the original is acked
+	confirmTimeout = 10 * time.Second
+)
+
+var (
+	// ErrNotConnected is returned while the connection is being re-established
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+	// ErrClosed is returned once the connection has been closed
+	ErrClosed = errors.New("RabbitMQ connection closed")
+)
+
+// RabbitMQ represents a RabbitMQ connection. A lost connection or channel is
+// re-established in the background with exponential backoff; the exchange,
+// queues and bindings are declared again and consumers resume.
 type RabbitMQ struct {
+	config      *config.RabbitMQConfig
+	retryPolicy retryPolicy
+	log         *zap.Logger
+
+	mu      sync.RWMutex
 	conn    *amqp091.Connection
 	channel *amqp091.Channel
-	config  *config.RabbitMQConfig
-	log     *zap.Logger
+	ready   chan struct{} // closed while connected, replaced when the connection is lost
+
+	done      chan struct{} // closed by Close
+	closeOnce sync.Once
 }
 
-// NewRabbitMQ creates a new RabbitMQ instance
-func NewRabbitMQ(cfg *config.RabbitMQConfig, log *zap.Logger) (*RabbitMQ, error) {
-	url := cfg.GetRabbitMQURL()
+// NewRabbitMQ creates a new RabbitMQ instance and connects it. If the broker
+// cannot be reached, connecting is retried in the background with the same
+// backoff as a lost connection; until then the instance reports that it is
+// not connected and consumers wait for the connection.
+func NewRabbitMQ(cfg *config.RabbitMQConfig, log *zap.Logger) *RabbitMQ {
+	rmq := &RabbitMQ{
+		config:      cfg,
+		retryPolicy: newRetryPolicy(&cfg.Retry),
+		log:         log,
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if err := rmq.connect(); err != nil {
+		log.Warn("Failed to connect to RabbitMQ, retrying in the background", zap.Error(err))
+		go rmq.reconnect()
+		return rmq
+	}
+
+	log.Info("RabbitMQ connection established",
+		zap.String("exchange", cfg.Exchange),
+		zap.String("queue", cfg.Queue))
 
-	conn, err := amqp091.Dial(url)
+	return rmq
+}
+
+// connect dials the broker, declares the topology and starts watching the
+// new connection
+func (r *RabbitMQ) connect() error {
+	conn, err := amqp091.Dial(r.config.GetRabbitMQURL())
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return fmt.Errorf("failed to connect to RabbitMQ: %w", err)
 	}
 
 	channel, err := conn.Channel()
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
+		return fmt.Errorf("failed to open channel: %w", err)
 	}
 
-	rmq := &RabbitMQ{
-		conn:    conn,
-		channel: channel,
-		config:  cfg,
-		log:     log,
+	if err := declareTopology(channel, r.config, r.retryPolicy); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// Publisher confirms let Publish report whether the broker stored a message
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed() {
+		// Closed while connecting
+		conn.Close()
+		return ErrClosed
+	}
+
+	r.conn = conn
+	r.channel = channel
+	close(r.ready)
+
+	go r.watch(conn, channel)
+
+	return nil
+}
+
+// watch waits for the connection or its channel to close and reconnects
+func (r *RabbitMQ) watch(conn *amqp091.Connection, channel *amqp091.Channel) {
+	connClosed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+	channelClosed := channel.NotifyClose(make(chan *amqp091.Error, 1))
+
+	var reason *amqp091.Error
+	select {
+	case <-r.done:
+		return
+	case reason = <-connClosed:
+	case reason = <-channelClosed:
+		// The channel died on its own, start over with a fresh connection
+		conn.Close()
+	}
+
+	r.mu.Lock()
+	r.ready = make(chan struct{})
+	r.mu.Unlock()
+
+	if r.closed() {
+		return
+	}
+
+	r.log.Warn("RabbitMQ connection lost, reconnecting", zap.Error(reason))
+	r.reconnect()
+}
+
+// reconnect retries connect with exponential backoff until it succeeds or
+// the connection is closed. It also makes the first connection when the
+// broker was down at startup.
+func (r *RabbitMQ) reconnect() {
+	delay := reconnectInitialDelay
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-time.After(delay):
+		}
+
+		err := r.connect()
+		if err == nil {
+			r.log.Info("RabbitMQ connection established",
+				zap.String("exchange", r.config.Exchange),
+				zap.String("queue", r.config.Queue))
+			return
+		}
+		if errors.Is(err, ErrClosed) {
+			return
+		}
+
+		delay = min(delay*2, reconnectMaxDelay)
+		r.log.Warn("Failed to connect to RabbitMQ",
+			zap.Duration("retry_in", delay),
+			zap.Error(err))
 	}
+}
 
+// declareTopology declares the exchange, the command queue with its bindings
+// and the retry and dead-letter queues
+func declareTopology(channel *amqp091.Channel, cfg *config.RabbitMQConfig, policy retryPolicy) error {
 	// Declare exchange
-	err = channel.ExchangeDeclare(
+	err := channel.ExchangeDeclare(
 		cfg.Exchange, // name
 		"topic",      // type
 		true,         // durable
@@ -51,8 +214,7 @@ func NewRabbitMQ(cfg *config.RabbitMQConfig, log *zap.Logger) (*RabbitMQ, error)
 		nil,          // arguments
 	)
 	if err != nil {
-		rmq.Close()
-		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+		return fmt.Errorf("failed to declare exchange: %w", err)
 	}
 
 	// Declare queue
@@ -65,48 +227,102 @@ func NewRabbitMQ(cfg *config.RabbitMQConfig, log *zap.Logger) (*RabbitMQ, error)
 		nil,       // arguments
 	)
 	if err != nil {
-		rmq.Close()
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+		return fmt.Errorf("failed to declare queue: %w", err)
 	}
 
-	// Bind queue to exchange
+	// Bind queue to exchange. Only commands are routed to the queue, the
+	// service's own lifecycle events share the exchange.
 	err = channel.QueueBind(
-		cfg.Queue,    // queue name
-		"#",          // routing key
-		cfg.Exchange, // exchange
+		cfg.Queue,               // queue name
+		cfg.CommandRoutingKey(), // routing key
+		cfg.Exchange,            // exchange
 		false,
 		nil,
 	)
 	if err != nil {
-		rmq.Close()
-		return nil, fmt.Errorf("failed to bind queue: %w", err)
+		return fmt.Errorf("failed to bind queue: %w", err)
 	}
 
-	log.Info("RabbitMQ connection established",
-		zap.String("exchange", cfg.Exchange),
-		zap.String("queue", cfg.Queue))
+	// Earlier versions bound the queue to every routing key; unbinding a
+	// binding that does not exist is a no-op
+	if err := channel.QueueUnbind(cfg.Queue, "#", cfg.Exchange, nil); err != nil {
+		return fmt.Errorf("failed to unbind queue: %w", err)
+	}
 
-	return rmq, nil
+	// Declare retry queues and the dead-letter queue for failed messages
+	return declareRetryTopology(channel, cfg.Queue, policy)
 }
 
-// Publish publishes a message to the exchange
+// current returns the live connection and channel
+func (r *RabbitMQ) current() (*amqp091.Connection, *amqp091.Channel, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed() {
+		return nil, nil, ErrClosed
+	}
+
+	select {
+	case <-r.ready:
+		return r.conn, r.channel, nil
+	default:
+		return nil, nil, ErrNotConnected
+	}
+}
+
+// waitConnected blocks until a connection is up
+func (r *RabbitMQ) waitConnected(ctx context.Context) error {
+	r.mu.RLock()
+	ready := r.ready
+	r.mu.RUnlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.done:
+		return ErrClosed
+	case <-ready:
+		if r.closed() {
+			return ErrClosed
+		}
+		return nil
+	}
+}
+
+// closed reports whether Close has been called
+func (r *RabbitMQ) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Connected reports whether the connection to RabbitMQ is currently up
+func (r *RabbitMQ) Connected() bool {
+	_, _, err := r.current()
+	return err == nil
+}
+
+// Publish publishes a persistent message to the exchange and waits for the
+// broker to confirm it
 func (r *RabbitMQ) Publish(ctx context.Context, routingKey string, body []byte) error {
-	err := r.channel.PublishWithContext(
-		ctx,
-		r.config.Exchange, // exchange
-		routingKey,        // routing key
-		false,             // mandatory
-		false,             // immediate
-		amqp091.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-			Timestamp:   time.Now(),
-		},
-	)
+	_, channel, err := r.current()
 	if err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
+	err = publishConfirmed(ctx, channel, r.config.Exchange, routingKey, amqp091.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp091.Persistent,
+		Body:         body,
+		Timestamp:    time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
 	r.log.Debug("Message published",
 		zap.String("routing_key", routingKey),
 		zap.Int("size", len(body)))
@@ -114,9 +330,102 @@ func (r *RabbitMQ) Publish(ctx context.Context, routingKey string, body []byte)
 	return nil
 }
 
-// Consume starts consuming messages from the queue
-func (r *RabbitMQ) Consume(ctx context.Context, handler func([]byte) error) error {
-	msgs, err := r.channel.Consume(
+// publishConfirmed publishes msg on a channel in confirm mode and waits for
+// the broker to confirm it
+func publishConfirmed(ctx context.Context, channel *amqp091.Channel, exchange, routingKey string, msg amqp091.Publishing) error {
+	confirm, err := channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		exchange,   // exchange
+		routingKey, // routing key
+		false,      // mandatory
+		false,      // immediate
+		msg,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to confirm message: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("message was rejected by the broker")
+	}
+
+	return nil
+}
+
+// Reply publishes body to the message's reply-to queue through the default
+// exchange, carrying over its correlation ID
+func (r *RabbitMQ) Reply(ctx context.Context, msg Message, body []byte) error {
+	if msg.ReplyTo == "" {
+		return nil
+	}
+
+	_, channel, err := r.current()
+	if err != nil {
+		return fmt.Errorf("failed to publish reply: %w", err)
+	}
+
+	err = channel.PublishWithContext(
+		ctx,
+		"",          // default exchange
+		msg.ReplyTo, // routing key
+		false,       // mandatory
+		false,       // immediate
+		amqp091.Publishing{
+			ContentType:   "application/json",
+			CorrelationId: msg.CorrelationID,
+			Body:          body,
+			Timestamp:     time.Now(),
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to publish reply: %w", err)
+	}
+
+	r.log.Debug("Reply published",
+		zap.String("reply_to", msg.ReplyTo),
+		zap.String("correlation_id", msg.CorrelationID))
+
+	return nil
+}
+
+// Consume starts consuming messages from the queue. A message whose handler
+// fails is retried with exponential backoff and dead-lettered after the
+// configured number of attempts. Consumption resumes after a reconnection;
+// Consume only returns once ctx is done or the connection is closed.
+func (r *RabbitMQ) Consume(ctx context.Context, handler func(context.Context, Message) error) error {
+	for {
+		if err := r.waitConnected(ctx); err != nil {
+			r.log.Info("Stopping message consumer")
+			return err
+		}
+
+		_, channel, err := r.current()
+		if err == nil {
+			err = r.consume(ctx, channel, handler)
+		}
+		if ctx.Err() != nil {
+			r.log.Info("Stopping message consumer")
+			return ctx.Err()
+		}
+
+		r.log.Warn("Message consumer interrupted, resuming after reconnect", zap.Error(err))
+
+		// Give the watcher time to notice a dead channel before trying again
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectInitialDelay):
+		}
+	}
+}
+
+// consume delivers messages from one channel to handler until the channel
+// closes or ctx is done
+func (r *RabbitMQ) consume(ctx context.Context, channel *amqp091.Channel, handler func(context.Context, Message) error) error {
+	msgs, err := channel.Consume(
 		r.config.Queue, // queue
 		"",             // consumer
 		false,          // auto-ack
@@ -134,7 +443,6 @@ func (r *RabbitMQ) Consume(ctx context.Context, handler func([]byte) error) erro
 	for {
 		select {
 		case <-ctx.Done():
-			r.log.Info("Stopping message consumer")
 			return ctx.Err()
 		case msg, ok := <-msgs:
 			if !ok {
@@ -143,9 +451,8 @@ func (r *RabbitMQ) Consume(ctx context.Context, handler func([]byte) error) erro
 
 			r.log.Debug("Received message", zap.Int("size", len(msg.Body)))
 
-			if err := handler(msg.Body); err != nil {
-				r.log.Error("Failed to handle message", zap.Error(err))
-				msg.Nack(false, true) // Requeue message
+			if err := handler(ctx, newMessage(msg)); err != nil {
+				r.retry(ctx, channel, msg, err)
 			} else {
 				msg.Ack(false)
 			}
@@ -153,15 +460,91 @@ func (r *RabbitMQ) Consume(ctx context.Context, handler func([]byte) error) erro
 	}
 }
 
-// Close closes the RabbitMQ connection
+// Request publishes a message with a reply-to address and waits for the
+// reply carrying its correlation ID. Replies arrive through RabbitMQ's
+// direct reply-to pseudo-queue on a channel opened for the request.
+func (r *RabbitMQ) Request(ctx context.Context, routingKey string, body []byte) (Message, error) {
+	conn, _, err := r.current()
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to open channel: %w", err)
+	}
+	defer channel.Close()
+
+	// The reply consumer must exist before the request is published
+	replies, err := channel.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to consume replies: %w", err)
+	}
+
+	correlationID := uuid.New().String()
+	err = channel.PublishWithContext(
+		ctx,
+		r.config.Exchange, // exchange
+		routingKey,        // routing key
+		false,             // mandatory
+		false,             // immediate
+		amqp091.Publishing{
+			ContentType:   "application/json",
+			MessageId:     uuid.New().String(),
+			CorrelationId: correlationID,
+			ReplyTo:       directReplyTo,
+			Body:          body,
+			Timestamp:     time.Now(),
+		},
+	)
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case reply, ok := <-replies:
+			if !ok {
+				return Message{}, fmt.Errorf("reply channel closed")
+			}
+			if reply.CorrelationId == correlationID {
+				return newMessage(reply), nil
+			}
+		}
+	}
+}
+
+// newMessage converts a delivery into a Message
+func newMessage(msg amqp091.Delivery) Message {
+	return Message{
+		Body:          msg.Body,
+		RoutingKey:    originalRoutingKey(msg),
+		MessageID:     msg.MessageId,
+		ReplyTo:       msg.ReplyTo,
+		CorrelationID: msg.CorrelationId,
+	}
+}
+
+// Close closes the RabbitMQ connection and stops reconnecting
 func (r *RabbitMQ) Close() error {
-	if r.channel != nil {
-		if err := r.channel.Close(); err != nil {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+
+	r.mu.Lock()
+	conn, channel := r.conn, r.channel
+	r.conn, r.channel = nil, nil
+	r.mu.Unlock()
+
+	if channel != nil {
+		if err := channel.Close(); err != nil && !errors.Is(err, amqp091.ErrClosed) {
 			return err
 		}
 	}
-	if r.conn != nil {
-		if err := r.conn.Close(); err != nil {
+	if conn != nil {
+		if err := conn.Close(); err != nil && !errors.Is(err, amqp091.ErrClosed) {
 			return err
 		}
 	}
@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+// newDisconnected returns a RabbitMQ that has lost its connection
+func newDisconnected() *RabbitMQ {
+	return &RabbitMQ{
+		log:   zap.NewNop(),
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+}
+
+func TestRabbitMQ_NotConnected(t *testing.T) {
+	r := newDisconnected()
+
+	assert.False(t, r.Connected())
+	assert.ErrorIs(t, r.Publish(context.Background(), "session.running", []byte("{}")), ErrNotConnected)
+	assert.ErrorIs(t, r.Reply(context.Background(), Message{ReplyTo: "replies"}, []byte("{}")), ErrNotConnected)
+
+	_, err := r.DeadLetters(context.Background(), 10)
+	assert.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestRabbitMQ_WaitConnected(t *testing.T) {
+	r := newDisconnected()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, r.waitConnected(ctx), context.DeadlineExceeded)
+
+	close(r.ready)
+	assert.NoError(t, r.waitConnected(context.Background()))
+	assert.True(t, r.Connected())
+}
+
+func TestRabbitMQ_Closed(t *testing.T) {
+	r := newDisconnected()
+	close(r.ready)
+
+	assert.NoError(t, r.Close())
+	assert.NoError(t, r.Close())
+	assert.False(t, r.Connected())
+	assert.ErrorIs(t, r.waitConnected(context.Background()), ErrClosed)
+	assert.ErrorIs(t, r.Consume(context.Background(), nil), ErrClosed)
+}
+
+func TestNewRabbitMQ_ConnectsInBackground(t *testing.T) {
+	// Nothing listens on port 1, so the first dial fails
+	r := NewRabbitMQ(&config.RabbitMQConfig{Host: "127.0.0.1", Port: 1, Queue: "paypilot_queue"}, zap.NewNop())
+
+	assert.False(t, r.Connected())
+	assert.ErrorIs(t, r.Publish(context.Background(), "session.running", []byte("{}")), ErrNotConnected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, r.Consume(ctx, nil), context.DeadlineExceeded, "consumers wait for the connection")
+
+	assert.NoError(t, r.Close())
+	assert.ErrorIs(t, r.waitConnected(context.Background()), ErrClosed)
+}
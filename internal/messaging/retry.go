@@ -0,0 +1,244 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultMaxAttempts is used when no retry limit is configured
+	defaultMaxAttempts = 5
+	// defaultInitialDelay is used when no initial retry delay is configured
+	defaultInitialDelay = 5 * time.Second
+	// defaultMaxDelay is used when no maximum retry delay is configured
+	defaultMaxDelay = 5 * time.Minute
+
+	// Headers carried by retried and dead-lettered messages
+	headerAttempts           = "x-attempts"
+	headerLastError          = "x-last-error"
+	headerOriginalRoutingKey = "x-original-routing-key"
+	headerDeadLetteredAt     = "x-dead-lettered-at"
+)
+
+// retryPolicy decides when a failed message is retried and when it is dead-lettered
+type retryPolicy struct {
+	maxAttempts  int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+// newRetryPolicy builds a retry policy from configuration, filling in defaults
+func newRetryPolicy(cfg *config.RabbitMQRetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts:  cfg.MaxAttempts,
+		initialDelay: time.Duration(cfg.InitialDelay) * time.Second,
+		maxDelay:     time.Duration(cfg.MaxDelay) * time.Second,
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = defaultMaxAttempts
+	}
+	if policy.initialDelay <= 0 {
+		policy.initialDelay = defaultInitialDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = defaultMaxDelay
+	}
+	if policy.maxDelay < policy.initialDelay {
+		policy.maxDelay = policy.initialDelay
+	}
+	return policy
+}
+
+// delay returns how long a message waits after its nth failed attempt
+func (p retryPolicy) delay(attempt int) time.Duration {
+	delay := p.initialDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if delay >= p.maxDelay {
+			return p.maxDelay
+		}
+	}
+	return delay
+}
+
+// delays returns the distinct retry delays, one retry queue is declared for each
+func (p retryPolicy) delays() []time.Duration {
+	var delays []time.Duration
+	for attempt := 1; attempt < p.maxAttempts; attempt++ {
+		delay := p.delay(attempt)
+		if len(delays) == 0 || delays[len(delays)-1] != delay {
+			delays = append(delays, delay)
+		}
+	}
+	return delays
+}
+
+// retryQueueName returns the name of the queue that holds messages for delay
+// before dead-lettering them back to queue. The delay is part of the name
+// because a queue's TTL cannot change once declared.
+func retryQueueName(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+// deadLetterExchangeName returns the exchange failed messages are published to
+func deadLetterExchangeName(queue string) string {
+	return queue + ".dlx"
+}
+
+// deadLetterQueueName returns the queue that keeps failed messages for inspection
+func deadLetterQueueName(queue string) string {
+	return queue + ".dead"
+}
+
+// declareRetryTopology declares the retry queues and the dead-letter exchange and queue
+func declareRetryTopology(channel *amqp091.Channel, queue string, policy retryPolicy) error {
+	for _, delay := range policy.delays() {
+		_, err := channel.QueueDeclare(
+			retryQueueName(queue, delay), // name
+			true,                         // durable
+			false,                        // delete when unused
+			false,                        // exclusive
+			false,                        // no-wait
+			amqp091.Table{
+				"x-message-ttl":             delay.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare retry queue: %w", err)
+		}
+	}
+
+	err := channel.ExchangeDeclare(
+		deadLetterExchangeName(queue), // name
+		"fanout",                      // type
+		true,                          // durable
+		false,                         // auto-deleted
+		false,                         // internal
+		false,                         // no-wait
+		nil,                           // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter exchange: %w", err)
+	}
+
+	_, err = channel.QueueDeclare(
+		deadLetterQueueName(queue), // name
+		true,                       // durable
+		false,                      // delete when unused
+		false,                      // exclusive
+		false,                      // no-wait
+		nil,                        // arguments
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+
+	err = channel.QueueBind(deadLetterQueueName(queue), "", deadLetterExchangeName(queue), false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to bind dead-letter queue: %w", err)
+	}
+
+	return nil
+}
+
+// attempts returns how many times a delivery has already failed
+func attempts(headers amqp091.Table) int {
+	switch n := headers[headerAttempts].(type) {
+	case int:
+		return n
+	case int8:
+		return int(n)
+	case int16:
+		return int(n)
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	default:
+		return 0
+	}
+}
+
+// originalRoutingKey returns the routing key a delivery was first published
+// with; retried messages come back through the default exchange under the
+// queue name
+func originalRoutingKey(msg amqp091.Delivery) string {
+	if key, ok := msg.Headers[headerOriginalRoutingKey].(string); ok && key != "" {
+		return key
+	}
+	return msg.RoutingKey
+}
+
+// republished copies a delivery for publishing again with the given headers
+func republished(msg amqp091.Delivery, headers amqp091.Table) amqp091.Publishing {
+	return amqp091.Publishing{
+		Headers:       headers,
+		ContentType:   msg.ContentType,
+		DeliveryMode:  amqp091.Persistent,
+		CorrelationId: msg.CorrelationId,
+		ReplyTo:       msg.ReplyTo,
+		MessageId:     msg.MessageId,
+		Timestamp:     msg.Timestamp,
+		Body:          msg.Body,
+	}
+}
+
+// retry schedules a failed delivery for another attempt, or moves it to the
+// dead-letter queue once it has used up its attempts. The delivery is acked
+// once the broker confirms its copy, so it is never requeued in a hot loop;
+// if the copy is not confirmed the delivery is requeued instead.
+func (r *RabbitMQ) retry(ctx context.Context, channel *amqp091.Channel, msg amqp091.Delivery, handlerErr error) {
+	attempt := attempts(msg.Headers) + 1
+
+	headers := amqp091.Table{}
+	for key, value := range msg.Headers {
+		headers[key] = value
+	}
+	headers[headerAttempts] = int32(attempt)
+	headers[headerLastError] = handlerErr.Error()
+	headers[headerOriginalRoutingKey] = originalRoutingKey(msg)
+
+	var exchange, routingKey string
+	if attempt >= r.retryPolicy.maxAttempts {
+		headers[headerDeadLetteredAt] = time.Now().UTC().Format(time.RFC3339)
+		exchange = deadLetterExchangeName(r.config.Queue)
+	} else {
+		routingKey = retryQueueName(r.config.Queue, r.retryPolicy.delay(attempt))
+	}
+
+	// The delivery is only acked once the broker holds its copy
+	confirmCtx, cancel := context.WithTimeout(ctx, confirmTimeout)
+	err := publishConfirmed(confirmCtx, channel, exchange, routingKey, republished(msg, headers))
+	cancel()
+	if err != nil {
+		r.log.Error("Failed to schedule message retry, requeueing",
+			zap.Int("attempt", attempt),
+			zap.Error(err))
+		msg.Nack(false, true)
+		return
+	}
+	msg.Ack(false)
+
+	if exchange != "" {
+		r.log.Warn("Message dead-lettered",
+			zap.String("routing_key", originalRoutingKey(msg)),
+			zap.String("message_id", msg.MessageId),
+			zap.Int("attempts", attempt),
+			zap.Error(handlerErr))
+		return
+	}
+
+	r.log.Warn("Message handling failed, retry scheduled",
+		zap.String("routing_key", originalRoutingKey(msg)),
+		zap.String("message_id", msg.MessageId),
+		zap.Int("attempt", attempt),
+		zap.Duration("delay", r.retryPolicy.delay(attempt)),
+		zap.Error(handlerErr))
+}
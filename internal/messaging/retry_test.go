@@ -0,0 +1,85 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+)
+
+func TestNewRetryPolicy_Defaults(t *testing.T) {
+	policy := newRetryPolicy(&config.RabbitMQRetryConfig{})
+
+	assert.Equal(t, defaultMaxAttempts, policy.maxAttempts)
+	assert.Equal(t, defaultInitialDelay, policy.initialDelay)
+	assert.Equal(t, defaultMaxDelay, policy.maxDelay)
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := newRetryPolicy(&config.RabbitMQRetryConfig{MaxAttempts: 6, InitialDelay: 5, MaxDelay: 30})
+
+	assert.Equal(t, 5*time.Second, policy.delay(1))
+	assert.Equal(t, 10*time.Second, policy.delay(2))
+	assert.Equal(t, 20*time.Second, policy.delay(3))
+	assert.Equal(t, 30*time.Second, policy.delay(4))
+	assert.Equal(t, 30*time.Second, policy.delay(5))
+
+	// One retry queue per distinct delay
+	assert.Equal(t, []time.Duration{5 * time.Second, 10 * time.Second, 20 * time.Second, 30 * time.Second}, policy.delays())
+}
+
+func TestRetryPolicy_SingleAttempt(t *testing.T) {
+	policy := newRetryPolicy(&config.RabbitMQRetryConfig{MaxAttempts: 1})
+
+	assert.Empty(t, policy.delays())
+}
+
+func TestRetryQueueNames(t *testing.T) {
+	assert.Equal(t, "paypilot_queue.retry.5s", retryQueueName("paypilot_queue", 5*time.Second))
+	assert.Equal(t, "paypilot_queue.retry.5m0s", retryQueueName("paypilot_queue", 5*time.Minute))
+	assert.Equal(t, "paypilot_queue.dlx", deadLetterExchangeName("paypilot_queue"))
+	assert.Equal(t, "paypilot_queue.dead", deadLetterQueueName("paypilot_queue"))
+}
+
+func TestAttempts(t *testing.T) {
+	assert.Equal(t, 0, attempts(nil))
+	assert.Equal(t, 3, attempts(amqp091.Table{headerAttempts: int32(3)}))
+	assert.Equal(t, 4, attempts(amqp091.Table{headerAttempts: int64(4)}))
+	assert.Equal(t, 0, attempts(amqp091.Table{headerAttempts: "x"}))
+}
+
+func TestOriginalRoutingKey(t *testing.T) {
+	retried := amqp091.Delivery{
+		RoutingKey: "paypilot_queue",
+		Headers:    amqp091.Table{headerOriginalRoutingKey: "dev_session.command.create_session"},
+	}
+	assert.Equal(t, "dev_session.command.create_session", originalRoutingKey(retried))
+
+	fresh := amqp091.Delivery{RoutingKey: "dev_session.command.stop_session"}
+	assert.Equal(t, "dev_session.command.stop_session", originalRoutingKey(fresh))
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	letter := newDeadLetter(amqp091.Delivery{
+		MessageId:  "m-1",
+		RoutingKey: "paypilot_queue",
+		Body:       []byte(`{"type":"stop_session"}`),
+		Headers: amqp091.Table{
+			headerAttempts:           int32(5),
+			headerLastError:          "boom",
+			headerOriginalRoutingKey: "dev_session.command.stop_session",
+			headerDeadLetteredAt:     "2026-01-01T12:00:00Z",
+		},
+	})
+
+	assert.Equal(t, "m-1", letter.MessageID)
+	assert.Equal(t, "dev_session.command.stop_session", letter.RoutingKey)
+	assert.Equal(t, 5, letter.Attempts)
+	assert.Equal(t, "boom", letter.LastError)
+	assert.Equal(t, `{"type":"stop_session"}`, letter.Body)
+	if assert.NotNil(t, letter.DeadLetteredAt) {
+		assert.Equal(t, time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), letter.DeadLetteredAt.UTC())
+	}
+}
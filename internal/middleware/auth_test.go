@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	testSecret = "test-secret"
+	testAPIKey = auth.APIKeyPrefix + "0123456789abcdef_secret"
+)
+
+// fakeKeys accepts testAPIKey as a service with the sessions:read scope
+type fakeKeys struct{}
+
+func (fakeKeys) Authenticate(_ context.Context, key string) (*auth.Principal, error) {
+	if key != testAPIKey {
+		return nil, errors.New("invalid api key")
+	}
+	return &auth.Principal{APIKeyID: 7, Scopes: models.Scopes{models.ScopeSessionsRead}}, nil
+}
+
+func newAuthRouter(t *testing.T) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	validator, err := auth.NewValidator(context.Background(), zap.NewNop(), &config.AuthConfig{HS256Secret: testSecret})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(Auth(zap.NewNop(), validator, fakeKeys{}))
+	router.GET("/me", func(c *gin.Context) {
+		principal, _ := auth.FromContext(c.Request.Context())
+		c.JSON(http.StatusOK, gin.H{
+			"user_id":    c.GetInt(auth.UserIDKey),
+			"roles":      c.GetStringSlice(auth.RolesKey),
+			"api_key_id": c.GetUint(auth.APIKeyIDKey),
+			"actor":      c.GetString(auth.ActorKey),
+			"principal":  principal.UserID,
+		})
+	})
+	router.GET("/write", RequireScope(models.ScopeSessionsWrite), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/admin", RequireScope(models.ScopeAdmin), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func token(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+	return signed
+}
+
+func request(router *gin.Engine, target, authorization string) *httptest.ResponseRecorder {
+	return requestWithHeader(router, target, "Authorization", authorization)
+}
+
+func requestWithHeader(router *gin.Engine, target, header, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	if value != "" {
+		req.Header.Set(header, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	router := newAuthRouter(t)
+
+	w := request(router, "/me", "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = request(router, "/me", "Basic dXNlcjpwYXNz")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = request(router, "/me", "Bearer not-a-jwt")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "invalid_token")
+
+	w = request(router, "/me", "Bearer "+token(t, jwt.MapClaims{"sub": "5", "roles": []string{"developer"}}))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id": 5, "roles": ["developer"], "api_key_id": 0, "actor": "user:5", "principal": 5}`, w.Body.String())
+}
+
+func TestAuth_APIKey(t *testing.T) {
+	router := newAuthRouter(t)
+
+	w := requestWithHeader(router, "/me", "X-API-Key", auth.APIKeyPrefix+"0123456789abcdef_wrong")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = requestWithHeader(router, "/me", "X-API-Key", testAPIKey)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"user_id": 0, "roles": null, "api_key_id": 7, "actor": "api_key:7", "principal": 0}`, w.Body.String())
+
+	w = request(router, "/me", "Bearer "+testAPIKey)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	router := newAuthRouter(t)
+	developer := "Bearer " + token(t, jwt.MapClaims{"sub": "5", "roles": []string{"developer"}})
+
+	w := request(router, "/write", developer)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = request(router, "/admin", developer)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = request(router, "/admin", "bearer "+token(t, jwt.MapClaims{"sub": "5", "roles": []string{"admin"}}))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = requestWithHeader(router, "/write", "X-API-Key", testAPIKey)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), models.ScopeSessionsWrite)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
 	"go.uber.org/zap"
 )
 
@@ -27,6 +28,7 @@ func Logger(log *zap.Logger) gin.HandlerFunc {
 			zap.String("ip", c.ClientIP()),
 			zap.String("user-agent", c.Request.UserAgent()),
 			zap.Duration("latency", latency),
+			zap.String("actor", c.GetString(auth.ActorKey)),
 			zap.String("error", c.Errors.ByType(gin.ErrorTypePrivate).String()),
 		)
 	}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Scopes an API key can be issued with
+const (
+	// ScopeSessionsRead allows reading sessions, their history and endpoints
+	ScopeSessionsRead = "sessions:read"
+	// ScopeSessionsWrite allows creating, changing and deleting sessions
+	ScopeSessionsWrite = "sessions:write"
+	// ScopeAdmin allows everything, including the admin routes
+	ScopeAdmin = "admin"
+)
+
+// ValidScope reports whether scope is one API keys can be issued with
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeSessionsRead, ScopeSessionsWrite, ScopeAdmin:
+		return true
+	default:
+		return false
+	}
+}
+
+// Scopes is a list of scopes, stored as a space separated string
+type Scopes []string
+
+// Has reports whether the list holds scope, or admin, which implies every scope
+func (s Scopes) Has(scope string) bool {
+	for _, have := range s {
+		if have == scope || have == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Value implements driver.Valuer
+func (s Scopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+// Scan implements sql.Scanner
+func (s *Scopes) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case string:
+		*s = strings.Fields(value)
+	case []byte:
+		*s = strings.Fields(string(value))
+	case nil:
+		*s = nil
+	default:
+		return fmt.Errorf("cannot scan %T into Scopes", value)
+	}
+	return nil
+}
+
+// APIKey is a credential for services calling the API. Only a hash of the
+// key is stored; the key itself is shown once when it is issued or rotated.
+type APIKey struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"uniqueIndex;not null" json:"prefix"` // Public part of the key, used to look it up
+	Hash       string     `gorm:"not null" json:"-"`                  // SHA-256 of the whole key
+	Scopes     Scopes     `gorm:"type:text;not null" json:"scopes"`
+	CreatedBy  string     `json:"created_by"` // Actor that issued the key
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RotatedAt  *time.Time `json:"rotated_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+// TableName overrides the table name
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// Usable reports whether the key is neither revoked nor expired at now
+func (k *APIKey) Usable(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// OutboxEvent is a session lifecycle event waiting to be published. It is
+// written in the same transaction as the session change it describes and
+// marked delivered once the broker has confirmed it.
+type OutboxEvent struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	EventID     string     `gorm:"uniqueIndex;not null" json:"event_id"` // Envelope ID, used by consumers to discard duplicates
+	Type        string     `gorm:"not null" json:"type"`                 // Event type, also the routing key
+	SessionID   uint       `gorm:"index" json:"session_id"`
+	Payload     string     `gorm:"type:jsonb;not null" json:"payload"`
+	Attempts    int        `gorm:"default:0" json:"attempts"`
+	LastError   string     `json:"last_error"`
+	DeliveredAt *time.Time `gorm:"index" json:"delivered_at"`
+}
+
+// TableName overrides the table name
+func (OutboxEvent) TableName() string {
+	return "outbox_events"
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// PreviewLink is a shareable, read-only link to a session's preview
+// endpoint. The link itself is signed and not stored; it stops working when
+// it expires, is revoked or has been opened MaxUses times.
+type PreviewLink struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	SessionID    uint       `gorm:"not null;index" json:"session_id"`
+	CreatedBy    string     `json:"created_by"` // Actor that created the link
+	ExpiresAt    time.Time  `gorm:"not null" json:"expires_at"`
+	PasswordHash string     `json:"-"`        // bcrypt hash of the optional password
+	MaxUses      *int       `json:"max_uses"` // Number of times the link may be opened, nil for unlimited
+	Uses         int        `gorm:"not null;default:0" json:"uses"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+}
+
+// TableName overrides the table name
+func (PreviewLink) TableName() string {
+	return "preview_links"
+}
+
+// Usable reports whether the link is neither revoked, expired nor used up at now
+func (l *PreviewLink) Usable(now time.Time) bool {
+	return l.RevokedAt == nil && now.Before(l.ExpiresAt) && (l.MaxUses == nil || l.Uses < *l.MaxUses)
+}
+
+// MarshalJSON reports whether the link needs a password without exposing its hash
+func (l PreviewLink) MarshalJSON() ([]byte, error) {
+	type previewLink PreviewLink
+	return json.Marshal(struct {
+		previewLink
+		PasswordProtected bool `json:"password_protected"`
+	}{previewLink(l), l.PasswordHash != ""})
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// ProcessedMessage records a consumed message by its message ID so that a
+// redelivery replays the recorded reply instead of running again
+type ProcessedMessage struct {
+	ID          uint       `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+	MessageID   string     `gorm:"uniqueIndex;not null" json:"message_id"`
+	ClaimedAt   time.Time  `json:"claimed_at"`   // When a consumer started handling the message
+	CompletedAt *time.Time `json:"completed_at"` // Nil while the message is being handled
+	Reply       string     `gorm:"type:text" json:"reply"`
+}
+
+// TableName overrides the table name
+func (ProcessedMessage) TableName() string {
+	return "processed_messages"
+}
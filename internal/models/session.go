@@ -1,29 +1,44 @@
 package models
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"gorm.io/gorm"
 )
 
+// AlwaysOnSessionDuration defines how long always-on sessions remain valid (1 year)
+const AlwaysOnSessionDuration = 24 * 365 * time.Hour
+
 // Session represents a development session for a no-code app project
 // This manages dev containers in Kubernetes namespaces
 type Session struct {
-	ID            uint           `gorm:"primarykey" json:"id"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
-	UserID        int            `gorm:"not null;index" json:"user_id" binding:"required"`
-	ProjectID     int            `gorm:"not null;index" json:"project_id" binding:"required"`
-	ProjectUUID   string         `gorm:"uniqueIndex;not null" json:"project_uuid" binding:"required"` // UUID from another service
-	Token         string         `gorm:"uniqueIndex;not null" json:"token"`
-	ExpiresAt     time.Time      `json:"expires_at"`
-	ContainerName string         `json:"container_name"`
-	Namespace     string         `json:"namespace"`                       // Uses project_uuid as namespace
-	Status        string         `gorm:"default:'pending'" json:"status"` // pending, running, stopped, error
-	IPAddress     string         `json:"ip_address"`
-	UserAgent     string         `json:"user_agent"`
-	IsActive      bool           `gorm:"default:true" json:"is_active"`
+	ID          uint           `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
+	UserID      int            `gorm:"not null;index" json:"user_id" binding:"required"`
+	ProjectID   int            `gorm:"not null;index" json:"project_id" binding:"required"`
+	ProjectUUID string         `gorm:"uniqueIndex:idx_sessions_project_uuid,where:deleted_at IS NULL;not null" json:"project_uuid" binding:"required"` // UUID from another service
+	// Token is the plaintext access token. It is never stored and is only
+	// set on the session returned when the token is issued or rotated.
+	Token     string `gorm:"-" json:"token,omitempty"`
+	TokenHash string `gorm:"uniqueIndex:idx_sessions_token_hash,where:deleted_at IS NULL;not null" json:"-"` // SHA-256 of the token
+	// PreviousTokenHash is the token replaced by the last rotation, which
+	// keeps working until PreviousTokenExpiresAt
+	PreviousTokenHash      string        `gorm:"index" json:"-"`
+	PreviousTokenExpiresAt *time.Time    `json:"-"`
+	TokenRotatedAt         *time.Time    `json:"token_rotated_at"`
+	ExpiresAt              time.Time     `json:"expires_at"`
+	ContainerName          string        `json:"container_name"`
+	Namespace              string        `json:"namespace"` // Uses project_uuid as namespace
+	Status                 SessionStatus `gorm:"default:'pending'" json:"status"`
+	IPAddress              string        `json:"ip_address"`
+	UserAgent              string        `json:"user_agent"`
+	IsActive               bool          `gorm:"default:true" json:"is_active"`
+	// LastAccessedAt is the last time the session's endpoints were requested
+	LastAccessedAt *time.Time `gorm:"index" json:"last_accessed_at"`
 	// Service endpoints
 	PreviewURL  string `json:"preview_url"`  // Preview application endpoint
 	PreviewPath string `json:"preview_path"` // Path redirect for preview
@@ -31,6 +46,10 @@ type Session struct {
 	ChatPath    string `json:"chat_path"`    // Path redirect for chat
 	VscodeURL   string `json:"vscode_url"`   // VS Code web endpoint
 	VscodePath  string `json:"vscode_path"`  // Path redirect for vscode
+	// In-cluster endpoints on the load balancer ClusterIP
+	InternalPreviewURL string `json:"internal_preview_url"`
+	InternalChatURL    string `json:"internal_chat_url"`
+	InternalVscodeURL  string `json:"internal_vscode_url"`
 }
 
 // TableName overrides the table name
@@ -42,3 +61,40 @@ func (Session) TableName() string {
 func (s *Session) IsExpired() bool {
 	return time.Now().After(s.ExpiresAt)
 }
+
+// HashToken returns the hex SHA-256 of a session token, as stored in
+// token_hash. Tokens are random, so a fast hash is enough to keep them from
+// being recovered.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetToken gives the session a new access token. Only its hash is stored;
+// a token replaced by an earlier rotation stops working.
+func (s *Session) SetToken(token string) {
+	s.Token = token
+	s.TokenHash = HashToken(token)
+	s.PreviousTokenHash = ""
+	s.PreviousTokenExpiresAt = nil
+}
+
+// Authenticates reports whether token grants access to the session at now:
+// it is the current token, or the previous one within its overlap window
+func (s *Session) Authenticates(token string, now time.Time) bool {
+	hash := HashToken(token)
+	if hash == s.TokenHash {
+		return true
+	}
+	return s.PreviousTokenHash != "" && hash == s.PreviousTokenHash &&
+		s.PreviousTokenExpiresAt != nil && now.Before(*s.PreviousTokenExpiresAt)
+}
+
+// IdleSince returns the time the session was last used, falling back to its
+// creation time if it has never been accessed
+func (s *Session) IdleSince() time.Time {
+	if s.LastAccessedAt != nil {
+		return *s.LastAccessedAt
+	}
+	return s.CreatedAt
+}
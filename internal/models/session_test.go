@@ -44,3 +44,33 @@ func TestSession_TableName(t *testing.T) {
 	s := Session{}
 	assert.Equal(t, "sessions", s.TableName())
 }
+
+func TestSession_IdleSince(t *testing.T) {
+	created := time.Now().Add(-2 * time.Hour)
+	s := &Session{CreatedAt: created}
+	assert.Equal(t, created, s.IdleSince())
+
+	accessed := time.Now().Add(-time.Minute)
+	s.LastAccessedAt = &accessed
+	assert.Equal(t, accessed, s.IdleSince())
+}
+
+func TestSession_Authenticates(t *testing.T) {
+	session := &Session{}
+	session.SetToken("old")
+	assert.NotEqual(t, "old", session.TokenHash)
+	assert.True(t, session.Authenticates("old", time.Now()))
+
+	expiresAt := time.Now().Add(time.Minute)
+	session.SetToken("new")
+	session.PreviousTokenHash = HashToken("old")
+	session.PreviousTokenExpiresAt = &expiresAt
+	assert.True(t, session.Authenticates("new", time.Now()))
+	assert.True(t, session.Authenticates("old", time.Now()), "the previous token works during the overlap")
+	assert.False(t, session.Authenticates("old", expiresAt), "and stops working after it")
+	assert.False(t, session.Authenticates("other", time.Now()))
+
+	session.SetToken("newer")
+	assert.False(t, session.Authenticates("old", time.Now()), "a new token drops the previous one")
+	assert.False(t, session.Authenticates("new", time.Now()))
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SessionStatus is the lifecycle state of a session's dev container
+type SessionStatus string
+
+// Session statuses
+const (
+	// StatusPending means the session is queued for provisioning
+	StatusPending SessionStatus = "pending"
+	// StatusProvisioning means the dev container is being installed
+	StatusProvisioning SessionStatus = "provisioning"
+	// StatusRunning means the dev container is ready
+	StatusRunning SessionStatus = "running"
+	// StatusStopping means the dev container is being scaled down or removed
+	StatusStopping SessionStatus = "stopping"
+	// StatusStopped means the dev container is scaled to zero or removed
+	StatusStopped SessionStatus = "stopped"
+	// StatusHibernated means the idle dev container was scaled to zero
+	StatusHibernated SessionStatus = "hibernated"
+	// StatusWaking means the hibernated dev container is being started
+	StatusWaking SessionStatus = "waking"
+	// StatusError means provisioning or a lifecycle operation failed
+	StatusError SessionStatus = "error"
+)
+
+// Actors recorded in the status history for changes the service makes on
+// its own
+const (
+	// ActorAPI is recorded for changes requested through the HTTP API while
+	// authentication is disabled. Authenticated callers are recorded as
+	// "user:<id>".
+	ActorAPI = "api"
+	// ActorCommand is recorded for changes requested through RabbitMQ commands
+	ActorCommand = "command"
+	// ActorProvisioner is recorded when a provisioning or start job finishes
+	ActorProvisioner = "provisioner"
+	// ActorHibernator is recorded when idle sessions are hibernated or woken up
+	ActorHibernator = "hibernator"
+	// ActorReaper is recorded when expired sessions are torn down
+	ActorReaper = "reaper"
+	// ActorReconciler is recorded when drift with the cluster is repaired
+	ActorReconciler = "reconciler"
+)
+
+// ErrInvalidTransition is returned for a status change the state machine does not allow
+var ErrInvalidTransition = errors.New("invalid session status transition")
+
+// transitions lists the statuses each status may move to, besides
+// StatusStopping and StatusError, which every status may move to
+var transitions = map[SessionStatus][]SessionStatus{
+	StatusPending:      {StatusProvisioning},
+	StatusProvisioning: {StatusRunning},
+	StatusRunning:      {StatusHibernated},
+	StatusStopping:     {StatusStopped},
+	StatusStopped:      {StatusRunning, StatusPending},
+	StatusHibernated:   {StatusWaking, StatusRunning, StatusStopped, StatusPending},
+	StatusWaking:       {StatusRunning, StatusHibernated},
+	StatusError:        {StatusRunning, StatusStopped, StatusPending},
+}
+
+// Valid reports whether s is a known status
+func (s SessionStatus) Valid() bool {
+	_, ok := transitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a session may move from s to to. Staying
+// in the same status is always allowed.
+func (s SessionStatus) CanTransitionTo(to SessionStatus) bool {
+	if !s.Valid() || !to.Valid() {
+		return false
+	}
+	if s == to || to == StatusStopping || to == StatusError {
+		return true
+	}
+	for _, allowed := range transitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition returns ErrInvalidTransition unless a session may move
+// from one status to the other
+func CheckTransition(from, to SessionStatus) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, from, to)
+	}
+	return nil
+}
+
+// SessionStatusChange is an entry in a session's status history
+type SessionStatusChange struct {
+	ID         uint          `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	SessionID  uint          `gorm:"not null;index" json:"session_id"`
+	FromStatus SessionStatus `gorm:"not null" json:"from_status"`
+	ToStatus   SessionStatus `gorm:"not null" json:"to_status"`
+	Reason     string        `json:"reason"`
+	Actor      string        `gorm:"not null" json:"actor"`
+}
+
+// TableName overrides the table name
+func (SessionStatusChange) TableName() string {
+	return "session_status_history"
+}
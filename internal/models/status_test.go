@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionStatus_CanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from SessionStatus
+		to   SessionStatus
+		want bool
+	}{
+		{StatusPending, StatusProvisioning, true},
+		{StatusProvisioning, StatusRunning, true},
+		{StatusRunning, StatusStopping, true},
+		{StatusStopping, StatusStopped, true},
+		{StatusStopped, StatusRunning, true},
+		{StatusRunning, StatusHibernated, true},
+		{StatusHibernated, StatusWaking, true},
+		{StatusWaking, StatusRunning, true},
+		{StatusError, StatusPending, true},
+		{StatusRunning, StatusRunning, true},
+		{StatusWaking, StatusError, true},
+		{StatusStopped, StatusStopping, true},
+
+		{StatusPending, StatusRunning, false},
+		{StatusRunning, StatusPending, false},
+		{StatusStopping, StatusRunning, false},
+		{StatusStopped, StatusHibernated, false},
+		{StatusWaking, StatusStopped, false},
+		{StatusRunning, StatusStopped, false},
+		{"unknown", StatusError, false},
+		{StatusRunning, "unknown", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.from.CanTransitionTo(tt.to))
+		})
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	assert.NoError(t, CheckTransition(StatusPending, StatusProvisioning))
+
+	err := CheckTransition(StatusPending, StatusStopped)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+	assert.Contains(t, err.Error(), "pending to stopped")
+}
+
+func TestSessionStatusChange_TableName(t *testing.T) {
+	assert.Equal(t, "session_status_history", SessionStatusChange{}.TableName())
+}
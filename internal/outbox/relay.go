@@ -0,0 +1,185 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/messaging"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultInterval is used when no relay interval is configured
+	defaultInterval = time.Second
+	// defaultBatchSize is used when no per-run limit is configured
+	defaultBatchSize = 100
+	// defaultRetention is used when no retention for delivered events is configured
+	defaultRetention = 7 * 24 * time.Hour
+	// publishTimeout bounds the wait for a single broker confirm
+	publishTimeout = 10 * time.Second
+	// cleanupInterval is the time between removals of old delivered events
+	cleanupInterval = time.Hour
+)
+
+// Relay publishes outbox events to the broker in the order they were
+// recorded. An event is marked delivered only after the broker confirms it,
+// so delivery is at-least-once: consumers discard duplicates by event ID.
+type Relay struct {
+	log       *zap.Logger
+	repo      repository.OutboxRepository
+	publisher messaging.Publisher
+	cfg       *config.OutboxConfig
+}
+
+// New creates an outbox relay publishing the events in repo to publisher
+func New(log *zap.Logger, repo repository.OutboxRepository, publisher messaging.Publisher, cfg *config.OutboxConfig) *Relay {
+	return &Relay{
+		log:       log,
+		repo:      repo,
+		publisher: publisher,
+		cfg:       cfg,
+	}
+}
+
+// Run relays pending events on the configured interval until ctx is
+// cancelled, and periodically removes delivered events past the retention
+func (r *Relay) Run(ctx context.Context) {
+	interval := time.Duration(r.cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	r.log.Info("Outbox relay started",
+		zap.Duration("interval", interval),
+		zap.Duration("retention", r.retention()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastCleanup time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			r.log.Info("Stopping outbox relay")
+			return
+		case <-ticker.C:
+			// Keep going while full batches are waiting
+			for {
+				relayed, err := r.Relay(ctx)
+				if err != nil {
+					r.log.Warn("Outbox relay run failed", zap.Error(err))
+					break
+				}
+				if relayed < r.batchSize() || ctx.Err() != nil {
+					break
+				}
+			}
+
+			if time.Since(lastCleanup) >= cleanupInterval {
+				if _, err := r.Cleanup(ctx); err != nil {
+					r.log.Error("Failed to remove delivered outbox events", zap.Error(err))
+				}
+				lastCleanup = time.Now()
+			}
+		}
+	}
+}
+
+// Relay publishes up to batch_size pending events, oldest first, and
+// returns the number delivered. Only one relay across replicas runs at a
+// time, so events are never published out of order or twice at once; a run
+// that finds the outbox locked does nothing. Each event is marked delivered
+// as soon as the broker confirms it, and the run stops at the first failure
+// to keep events in order.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	delivered := 0
+	var publishErr error
+
+	err := r.repo.WithRelayLock(ctx, func(ctx context.Context) error {
+		pending, err := r.repo.Pending(ctx, r.batchSize())
+		if err != nil {
+			return err
+		}
+
+		for i := range pending {
+			event := &pending[i]
+
+			if err := r.publish(ctx, event); err != nil {
+				if err := r.repo.MarkFailed(ctx, event.ID, err.Error()); err != nil {
+					r.log.Error("Failed to record outbox publish failure", zap.Uint("id", event.ID), zap.Error(err))
+				}
+				publishErr = fmt.Errorf("failed to publish event %s: %w", event.EventID, err)
+				return nil
+			}
+
+			if err := r.repo.MarkDelivered(ctx, event.ID, time.Now()); err != nil {
+				return fmt.Errorf("failed to mark event %s delivered: %w", event.EventID, err)
+			}
+			delivered++
+		}
+
+		return nil
+	})
+	if errors.Is(err, repository.ErrOutboxLocked) {
+		return 0, nil
+	}
+	if err != nil {
+		return delivered, err
+	}
+
+	return delivered, publishErr
+}
+
+// publish sends one event and waits for the broker's confirm
+func (r *Relay) publish(ctx context.Context, event *models.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	if err := r.publisher.Publish(ctx, event.Type, []byte(event.Payload)); err != nil {
+		return err
+	}
+
+	r.log.Debug("Outbox event delivered",
+		zap.String("type", event.Type),
+		zap.String("event_id", event.EventID),
+		zap.Uint("session_id", event.SessionID))
+
+	return nil
+}
+
+// Cleanup deletes events delivered longer ago than the retention period and
+// returns how many were removed
+func (r *Relay) Cleanup(ctx context.Context) (int64, error) {
+	removed, err := r.repo.DeleteDelivered(ctx, time.Now().Add(-r.retention()))
+	if err != nil {
+		return 0, err
+	}
+
+	if removed > 0 {
+		r.log.Info("Removed delivered outbox events", zap.Int64("count", removed))
+	}
+
+	return removed, nil
+}
+
+// batchSize returns the maximum number of events published per run
+func (r *Relay) batchSize() int {
+	if r.cfg.BatchSize <= 0 {
+		return defaultBatchSize
+	}
+	return r.cfg.BatchSize
+}
+
+// retention returns how long delivered events are kept
+func (r *Relay) retention() time.Duration {
+	retention := time.Duration(r.cfg.Retention) * time.Second
+	if retention <= 0 {
+		return defaultRetention
+	}
+	return retention
+}
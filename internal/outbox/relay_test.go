@@ -0,0 +1,120 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+// fakePublisher records published routing keys and fails the failAt-th publish
+type fakePublisher struct {
+	mu        sync.Mutex
+	published []string
+	failAt    int // 0 never fails
+	calls     int
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, routingKey string, body []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	if p.calls == p.failAt {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, routingKey)
+	return nil
+}
+
+// newTestRelay returns a relay over an outbox holding the given event types
+func newTestRelay(publisher *fakePublisher, batchSize int, types ...string) (*Relay, *repository.MemoryOutboxRepository) {
+	repo := repository.NewMemoryOutboxRepository()
+	for i, eventType := range types {
+		repo.Add(models.OutboxEvent{EventID: fmt.Sprintf("%s-%d", eventType, i), Type: eventType, Payload: "{}"})
+	}
+	return New(zap.NewNop(), repo, publisher, &config.OutboxConfig{BatchSize: batchSize}), repo
+}
+
+func TestRelay_PublishesInOrder(t *testing.T) {
+	publisher := &fakePublisher{}
+	relay, repo := newTestRelay(publisher, 2, "one", "two", "three")
+
+	relayed, err := relay.Relay(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, relayed)
+
+	relayed, err = relay.Relay(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, relayed)
+
+	assert.Equal(t, []string{"one", "two", "three"}, publisher.published)
+	for _, event := range repo.Events() {
+		assert.NotNil(t, event.DeliveredAt)
+	}
+}
+
+func TestRelay_PublishFailureKeepsDeliveredPart(t *testing.T) {
+	publisher := &fakePublisher{failAt: 2}
+	relay, repo := newTestRelay(publisher, 10, "one", "two", "three")
+
+	relayed, err := relay.Relay(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 1, relayed)
+
+	stored := repo.Events()
+	assert.NotNil(t, stored[0].DeliveredAt, "events confirmed before the failure stay delivered")
+	assert.Nil(t, stored[1].DeliveredAt)
+	assert.Equal(t, 1, stored[1].Attempts)
+	assert.Contains(t, stored[1].LastError, "broker unavailable")
+	assert.Nil(t, stored[2].DeliveredAt, "the run stops at the failure to keep events in order")
+	assert.Zero(t, stored[2].Attempts)
+
+	relayed, err = relay.Relay(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, relayed)
+	assert.Equal(t, []string{"one", "two", "three"}, publisher.published)
+}
+
+func TestRelay_SkipsWhileAnotherRelayRuns(t *testing.T) {
+	publisher := &fakePublisher{}
+	relay, repo := newTestRelay(publisher, 10, "one")
+
+	err := repo.WithRelayLock(context.Background(), func(ctx context.Context) error {
+		relayed, err := relay.Relay(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, relayed)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, publisher.published)
+
+	relayed, err := relay.Relay(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, relayed)
+}
+
+func TestRelay_CleanupRemovesOldDeliveredEvents(t *testing.T) {
+	relay, repo := newTestRelay(&fakePublisher{}, 10, "old", "recent", "pending")
+	stored := repo.Events()
+	require.NoError(t, repo.MarkDelivered(context.Background(), stored[0].ID, time.Now().Add(-8*24*time.Hour)))
+	require.NoError(t, repo.MarkDelivered(context.Background(), stored[1].ID, time.Now()))
+
+	removed, err := relay.Cleanup(context.Background())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, removed)
+
+	remaining := repo.Events()
+	require.Len(t, remaining, 2)
+	assert.Equal(t, "recent", remaining[0].Type)
+	assert.Equal(t, "pending", remaining[1].Type)
+}
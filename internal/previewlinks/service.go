@@ -0,0 +1,349 @@
+package previewlinks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// LinkPrefix starts the tokens of preview links
+	LinkPrefix = "pvl_"
+	// GrantPrefix starts the grants handed to browsers that opened a link
+	GrantPrefix = "pvg_"
+
+	// defaultTTL is used when no default link lifetime is configured
+	defaultTTL = 24 * time.Hour
+	// defaultMaxTTL is used when no longest link lifetime is configured
+	defaultMaxTTL = 30 * 24 * time.Hour
+	// maxPasswordBytes is the longest password bcrypt can hash
+	maxPasswordBytes = 72
+)
+
+// Errors returned by Service
+var (
+	// ErrNoSecret is returned by NewService when no signing secret is configured
+	ErrNoSecret = errors.New("preview links need a signing secret")
+	// ErrNotFound is returned when no preview link matches
+	ErrNotFound = repository.ErrPreviewLinkNotFound
+	// ErrRevoked is returned when revoking a revoked link
+	ErrRevoked = repository.ErrPreviewLinkRevoked
+	// ErrInvalidRequest is returned for a link request with an invalid lifetime, use count or password
+	ErrInvalidRequest = errors.New("invalid preview link request")
+	// ErrInactive is returned when creating a link for a session that is no longer active
+	ErrInactive = errors.New("session is no longer active")
+	// ErrInvalidLink is returned for link tokens and grants that are malformed,
+	// wrongly signed or unknown
+	ErrInvalidLink = errors.New("invalid preview link")
+	// ErrPasswordRequired is returned when a link's password is missing or wrong
+	ErrPasswordRequired = errors.New("preview link password required")
+	// ErrUnusable is returned for links that are revoked, expired or used up,
+	// and for links to sessions that are no longer active
+	ErrUnusable = errors.New("preview link is revoked, expired or used up")
+	// ErrOutOfScope is returned when a link is used for anything but reading
+	// its session's preview endpoint
+	ErrOutOfScope = errors.New("preview links only open their session's preview endpoint")
+)
+
+// IsCredential reports whether token is a preview link token or grant
+// rather than a session token
+func IsCredential(token string) bool {
+	return strings.HasPrefix(token, LinkPrefix) || strings.HasPrefix(token, GrantPrefix)
+}
+
+// Options describe a new preview link. Zero values take the defaults.
+type Options struct {
+	// TTL is how long the link is valid, the configured default when zero
+	TTL time.Duration
+	// Password must be given to open the link, none when empty
+	Password string
+	// MaxUses is how often the link may be opened, unlimited when nil
+	MaxUses *int
+}
+
+// Access is a successful opening of a preview link
+type Access struct {
+	Link    *models.PreviewLink
+	Session *models.Session
+	// Grant is set when the link was opened with its token. Browsers keep it
+	// so that the requests that follow neither count as uses nor ask for the
+	// password again.
+	Grant string
+}
+
+// Service creates, lists and revokes preview links and checks the links
+// preview traffic is opened with. Links look like pvl_<id>_<expiry>_<mac>;
+// they are signed with the configured secret and not stored.
+type Service struct {
+	log      *zap.Logger
+	links    repository.PreviewLinkRepository
+	sessions repository.SessionRepository
+	secret   []byte
+	ttl      time.Duration
+	maxTTL   time.Duration
+}
+
+// NewService creates a preview link service storing links in links and
+// looking up their sessions in sessions. It returns ErrNoSecret when cfg has
+// no signing secret.
+func NewService(log *zap.Logger, links repository.PreviewLinkRepository, sessions repository.SessionRepository, cfg *config.PreviewLinksConfig) (*Service, error) {
+	if cfg.Secret == "" {
+		return nil, ErrNoSecret
+	}
+
+	maxTTL := time.Duration(cfg.MaxTTL) * time.Second
+	if maxTTL <= 0 {
+		maxTTL = defaultMaxTTL
+	}
+	ttl := time.Duration(cfg.DefaultTTL) * time.Second
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	return &Service{
+		log:      log,
+		links:    links,
+		sessions: sessions,
+		secret:   []byte(cfg.Secret),
+		ttl:      min(ttl, maxTTL),
+		maxTTL:   maxTTL,
+	}, nil
+}
+
+// Create creates a preview link to session and returns it with its token,
+// which is not stored and cannot be shown again
+func (s *Service) Create(ctx context.Context, session *models.Session, opts Options) (*models.PreviewLink, string, error) {
+	if opts.TTL < 0 || opts.TTL > s.maxTTL {
+		return nil, "", fmt.Errorf("%w: expires_in must be between 1 and %d seconds", ErrInvalidRequest, int(s.maxTTL.Seconds()))
+	}
+	if opts.MaxUses != nil && *opts.MaxUses < 1 {
+		return nil, "", fmt.Errorf("%w: max_uses must be at least 1", ErrInvalidRequest)
+	}
+	if len(opts.Password) > maxPasswordBytes {
+		return nil, "", fmt.Errorf("%w: password must be at most %d bytes", ErrInvalidRequest, maxPasswordBytes)
+	}
+	if !session.IsActive || session.IsExpired() {
+		return nil, "", ErrInactive
+	}
+
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = s.ttl
+	}
+
+	link := &models.PreviewLink{
+		SessionID: session.ID,
+		CreatedBy: actorFrom(ctx),
+		// Tokens carry the expiry in whole seconds
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+		MaxUses:   opts.MaxUses,
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to hash preview link password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := s.links.Create(ctx, link); err != nil {
+		return nil, "", err
+	}
+
+	s.log.Info("Preview link created",
+		zap.Uint("preview_link_id", link.ID),
+		zap.Uint("session_id", session.ID),
+		zap.Time("expires_at", link.ExpiresAt),
+		zap.String("actor", link.CreatedBy))
+
+	return link, s.sign(LinkPrefix, link.ID, link.ExpiresAt), nil
+}
+
+// List returns a page of a session's preview links, newest first, and the
+// total number of links of the session
+func (s *Service) List(ctx context.Context, sessionID uint, page repository.Page) ([]models.PreviewLink, int64, error) {
+	return s.links.ListBySession(ctx, sessionID, page)
+}
+
+// Revoke disables a preview link of a session. Browsers that already opened
+// it lose access on their next request. The link is kept for the record.
+func (s *Service) Revoke(ctx context.Context, sessionID, linkID uint) (*models.PreviewLink, error) {
+	link, err := s.links.Get(ctx, linkID)
+	if err != nil {
+		return nil, err
+	}
+	if link.SessionID != sessionID {
+		return nil, ErrNotFound
+	}
+	if link.RevokedAt != nil {
+		return nil, ErrRevoked
+	}
+
+	if err := s.links.Revoke(ctx, link.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	// Reload the link so that it shows uses counted while revoking it
+	if link, err = s.links.Get(ctx, linkID); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Preview link revoked",
+		zap.Uint("preview_link_id", link.ID),
+		zap.Uint("session_id", sessionID),
+		zap.String("actor", actorFrom(ctx)))
+
+	return link, nil
+}
+
+// Open checks a request to a session's preview made with credential, a
+// link token or a grant. grant is the grant the browser holds, if any; a
+// valid grant for the same link lets a link token through without counting
+// a use or asking for the password. Otherwise opening a link token checks
+// password, counts a use and returns a new grant. check, which may be nil,
+// vets the request against the link's session before any use is counted;
+// its error is returned as is.
+func (s *Service) Open(ctx context.Context, credential, grant, password string, check func(*models.Session) error) (*Access, error) {
+	var id uint
+	var granted bool
+	var expires time.Time
+	if strings.HasPrefix(credential, GrantPrefix) {
+		linkID, until, ok := s.verify(GrantPrefix, credential)
+		if !ok {
+			return nil, fmt.Errorf("%w: bad grant", ErrInvalidLink)
+		}
+		id, expires, granted = linkID, until, true
+	} else {
+		linkID, until, ok := s.verify(LinkPrefix, credential)
+		if !ok {
+			return nil, fmt.Errorf("%w: bad token", ErrInvalidLink)
+		}
+		id, expires = linkID, until
+		if grantID, _, ok := s.verify(GrantPrefix, grant); ok && grantID == id {
+			granted = true
+		}
+	}
+
+	now := time.Now()
+	if !now.Before(expires) {
+		return nil, ErrUnusable
+	}
+
+	link, err := s.links.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("%w: unknown link", ErrInvalidLink)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if link.RevokedAt != nil || !now.Before(link.ExpiresAt) {
+		return nil, ErrUnusable
+	}
+
+	session, err := s.sessions.Get(ctx, link.SessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, ErrUnusable
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsActive || session.IsExpired() {
+		return nil, ErrUnusable
+	}
+	if check != nil {
+		if err := check(session); err != nil {
+			return nil, err
+		}
+	}
+
+	access := &Access{Link: link, Session: session}
+	if granted {
+		return access, nil
+	}
+
+	if link.PasswordHash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return nil, ErrPasswordRequired
+		}
+	}
+
+	if err := s.links.Use(ctx, link.ID, now); err != nil {
+		if errors.Is(err, repository.ErrPreviewLinkUsedUp) {
+			return nil, ErrUnusable
+		}
+		return nil, err
+	}
+	link.Uses++
+	link.LastUsedAt = &now
+
+	s.log.Info("Preview link opened",
+		zap.Uint("preview_link_id", link.ID),
+		zap.Uint("session_id", session.ID),
+		zap.Int("uses", link.Uses))
+
+	access.Grant = s.sign(GrantPrefix, link.ID, link.ExpiresAt)
+	return access, nil
+}
+
+// sign returns the token of kind prefix for link id, valid until expires
+func (s *Service) sign(prefix string, id uint, expires time.Time) string {
+	payload := prefix + strconv.FormatUint(uint64(id), 10) + "_" + strconv.FormatInt(expires.Unix(), 10)
+	return payload + "_" + s.mac(payload)
+}
+
+// verify returns the link ID and expiry of a token of kind prefix if it is
+// correctly signed
+func (s *Service) verify(prefix, token string) (uint, time.Time, bool) {
+	rest, ok := strings.CutPrefix(token, prefix)
+	if !ok {
+		return 0, time.Time{}, false
+	}
+	parts := strings.Split(rest, "_")
+	if len(parts) != 3 {
+		return 0, time.Time{}, false
+	}
+
+	payload := strings.TrimSuffix(token, "_"+parts[2])
+	if !hmac.Equal([]byte(s.mac(payload)), []byte(parts[2])) {
+		return 0, time.Time{}, false
+	}
+
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return uint(id), time.Unix(expires, 0), true
+}
+
+// mac returns the hex HMAC-SHA256 of payload. The prefix is part of
+// the payload, so link tokens and grants cannot stand in for each other.
+func (s *Service) mac(payload string) string {
+	m := hmac.New(sha256.New, s.secret)
+	m.Write([]byte(payload))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// actorFrom returns the caller on ctx, as recorded for link changes
+func actorFrom(ctx context.Context) string {
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Actor()
+	}
+	return models.ActorAPI
+}
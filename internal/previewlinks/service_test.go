@@ -0,0 +1,141 @@
+package previewlinks
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+func newTestService(t *testing.T) (*Service, *models.Session) {
+	sessions := repository.NewMemorySessionRepository()
+	session := &models.Session{
+		ProjectUUID: "p1",
+		Namespace:   "p1",
+		TokenHash:   models.HashToken("tok"),
+		IsActive:    true,
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+	require.NoError(t, sessions.Create(context.Background(), session, ""))
+
+	svc, err := NewService(zap.NewNop(), repository.NewMemoryPreviewLinkRepository(), sessions,
+		&config.PreviewLinksConfig{Secret: "secret", DefaultTTL: 600, MaxTTL: 3600})
+	require.NoError(t, err)
+	return svc, session
+}
+
+func TestNewService_RequiresSecret(t *testing.T) {
+	_, err := NewService(zap.NewNop(), nil, nil, &config.PreviewLinksConfig{})
+	assert.ErrorIs(t, err, ErrNoSecret)
+}
+
+func TestService_CreateAndOpen(t *testing.T) {
+	svc, session := newTestService(t)
+	ctx := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: 3})
+
+	link, token, err := svc.Create(ctx, session, Options{})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, LinkPrefix))
+	assert.True(t, IsCredential(token))
+	assert.Equal(t, "user:3", link.CreatedBy)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), link.ExpiresAt, 2*time.Second)
+
+	access, err := svc.Open(context.Background(), token, "", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, access.Session.ID)
+	assert.Equal(t, 1, access.Link.Uses)
+	assert.True(t, strings.HasPrefix(access.Grant, GrantPrefix))
+
+	// The grant opens the link again without counting a use
+	again, err := svc.Open(context.Background(), access.Grant, "", "", nil)
+	require.NoError(t, err)
+	assert.Empty(t, again.Grant)
+	again, err = svc.Open(context.Background(), token, access.Grant, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, again.Link.Uses)
+
+	_, err = svc.Open(context.Background(), token+"0", "", "", nil)
+	assert.ErrorIs(t, err, ErrInvalidLink)
+	_, err = svc.Open(context.Background(), GrantPrefix+strings.TrimPrefix(token, LinkPrefix), "", "", nil)
+	assert.ErrorIs(t, err, ErrInvalidLink, "link tokens are not grants")
+
+	scope := errors.New("out of scope")
+	_, err = svc.Open(context.Background(), token, "", "", func(*models.Session) error { return scope })
+	assert.ErrorIs(t, err, scope)
+}
+
+func TestService_CreateRejects(t *testing.T) {
+	svc, session := newTestService(t)
+	zero := 0
+
+	_, _, err := svc.Create(context.Background(), session, Options{TTL: 2 * time.Hour})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, _, err = svc.Create(context.Background(), session, Options{MaxUses: &zero})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, _, err = svc.Create(context.Background(), session, Options{Password: strings.Repeat("x", 73)})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	session.IsActive = false
+	_, _, err = svc.Create(context.Background(), session, Options{})
+	assert.ErrorIs(t, err, ErrInactive)
+}
+
+func TestService_OpenPasswordAndMaxUses(t *testing.T) {
+	svc, session := newTestService(t)
+	two := 2
+
+	link, token, err := svc.Create(context.Background(), session, Options{Password: "hunter2", MaxUses: &two})
+	require.NoError(t, err)
+	assert.NotEmpty(t, link.PasswordHash)
+	assert.NotContains(t, link.PasswordHash, "hunter2")
+
+	_, err = svc.Open(context.Background(), token, "", "", nil)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+	_, err = svc.Open(context.Background(), token, "", "wrong", nil)
+	assert.ErrorIs(t, err, ErrPasswordRequired)
+
+	for range two {
+		_, err = svc.Open(context.Background(), token, "", "hunter2", nil)
+		require.NoError(t, err)
+	}
+	_, err = svc.Open(context.Background(), token, "", "hunter2", nil)
+	assert.ErrorIs(t, err, ErrUnusable, "wrong passwords do not count as uses")
+}
+
+func TestService_Revoke(t *testing.T) {
+	svc, session := newTestService(t)
+
+	link, token, err := svc.Create(context.Background(), session, Options{})
+	require.NoError(t, err)
+	access, err := svc.Open(context.Background(), token, "", "", nil)
+	require.NoError(t, err)
+
+	_, err = svc.Revoke(context.Background(), session.ID+1, link.ID)
+	assert.ErrorIs(t, err, ErrNotFound, "links are revoked through their own session")
+
+	revoked, err := svc.Revoke(context.Background(), session.ID, link.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	assert.Equal(t, 1, revoked.Uses, "revoking a link keeps its uses")
+	_, err = svc.Revoke(context.Background(), session.ID, link.ID)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	_, err = svc.Open(context.Background(), token, "", "", nil)
+	assert.ErrorIs(t, err, ErrUnusable)
+	_, err = svc.Open(context.Background(), access.Grant, "", "", nil)
+	assert.ErrorIs(t, err, ErrUnusable, "revoking a link ends the access it granted")
+
+	links, total, err := svc.List(context.Background(), session.ID, repository.NewPage(1, 10))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, total)
+	assert.Len(t, links, 1)
+}
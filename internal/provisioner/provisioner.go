@@ -0,0 +1,140 @@
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/worker"
+	"go.uber.org/zap"
+)
+
+// Provisioner installs dev containers for pending sessions in the background
+// and records the outcome on the session row
+type Provisioner struct {
+	log     *zap.Logger
+	repo    repository.SessionRepository
+	runtime kubernetes.Runtime
+	pool    *worker.Pool
+}
+
+// New creates a provisioner that runs its jobs on pool and records their
+// outcome, with an event for the resulting status, in repo
+func New(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, pool *worker.Pool) *Provisioner {
+	return &Provisioner{
+		log:     log,
+		repo:    repo,
+		runtime: runtime,
+		pool:    pool,
+	}
+}
+
+// Enqueue schedules the dev container for a saved session to be installed.
+// It returns worker.ErrQueueFull or worker.ErrPoolClosed if the job cannot be queued.
+func (p *Provisioner) Enqueue(session models.Session) error {
+	return p.pool.Submit(func(ctx context.Context) {
+		p.provision(ctx, session)
+	})
+}
+
+// EnqueueStart schedules a scaled down dev container to be started again.
+// It returns worker.ErrQueueFull or worker.ErrPoolClosed if the job cannot be queued.
+func (p *Provisioner) EnqueueStart(session models.Session) error {
+	return p.pool.Submit(func(ctx context.Context) {
+		p.start(ctx, session)
+	})
+}
+
+// provision moves a pending session to provisioning, installs the dev
+// container and moves the session to running or error. Sessions that are no
+// longer pending, such as deleted ones, are skipped.
+func (p *Provisioner) provision(ctx context.Context, session models.Session) {
+	change := repository.StatusChange{Reason: "dev container installation started", Actor: models.ActorProvisioner}
+	err := p.repo.Update(ctx, &session, models.StatusProvisioning, change, events.SessionProvisioning, nil)
+	if err != nil {
+		p.log.Warn("Skipping provisioning of changed session",
+			zap.Uint("session_id", session.ID),
+			zap.Error(err))
+		return
+	}
+
+	p.log.Info("Provisioning dev container",
+		zap.Uint("session_id", session.ID),
+		zap.String("project_uuid", session.ProjectUUID))
+
+	endpoints, err := p.runtime.CreateDevContainer(ctx, session.ProjectUUID, session.ProjectID, session.UserID)
+	if err != nil {
+		p.log.Error("Failed to create dev container",
+			zap.Uint("session_id", session.ID),
+			zap.Error(err))
+		err = fmt.Errorf("failed to create dev container: %w", err)
+	}
+
+	p.record(ctx, session, endpoints, err)
+}
+
+// start scales the dev container back up and moves the session to running or error
+func (p *Provisioner) start(ctx context.Context, session models.Session) {
+	p.log.Info("Starting dev container",
+		zap.Uint("session_id", session.ID),
+		zap.String("project_uuid", session.ProjectUUID))
+
+	endpoints, err := p.runtime.StartDevContainer(ctx, session.ProjectUUID)
+	if err != nil {
+		p.log.Error("Failed to start dev container",
+			zap.Uint("session_id", session.ID),
+			zap.Error(err))
+		err = fmt.Errorf("failed to start dev container: %w", err)
+	}
+
+	p.record(ctx, session, endpoints, err)
+}
+
+// record stores the outcome of a provisioning or start job on the session
+// row together with an event for the resulting status
+func (p *Provisioner) record(ctx context.Context, session models.Session, endpoints *kubernetes.ServiceEndpoints, err error) {
+	status := models.StatusRunning
+	change := repository.StatusChange{Reason: "dev container ready", Actor: models.ActorProvisioner}
+	var apply func(stored *models.Session) error
+
+	if err != nil {
+		status = models.StatusError
+		change.Reason = err.Error()
+	} else {
+		apply = func(stored *models.Session) error {
+			stored.ContainerName = kubernetes.ReleaseName(stored.ProjectUUID)
+			// Populate service endpoints
+			if endpoints != nil {
+				stored.IPAddress = endpoints.ClusterIP
+				stored.PreviewURL = endpoints.PreviewURL
+				stored.PreviewPath = endpoints.PreviewPath
+				stored.ChatURL = endpoints.ChatURL
+				stored.ChatPath = endpoints.ChatPath
+				stored.VscodeURL = endpoints.VscodeURL
+				stored.VscodePath = endpoints.VscodePath
+				stored.InternalPreviewURL = endpoints.InternalPreviewURL
+				stored.InternalChatURL = endpoints.InternalChatURL
+				stored.InternalVscodeURL = endpoints.InternalVscodeURL
+			}
+			return nil
+		}
+	}
+
+	// The outcome is recorded even when the job was cancelled on shutdown, so
+	// the session is not left provisioning. The event carries the stored row
+	// with the new endpoints.
+	err = p.repo.Update(context.WithoutCancel(ctx), &session, status, change, events.StatusEvent(status), apply)
+	if err != nil {
+		p.log.Error("Failed to record provisioning result",
+			zap.Uint("session_id", session.ID),
+			zap.Error(err))
+		return
+	}
+
+	p.log.Info("Dev container provisioning finished",
+		zap.Uint("session_id", session.ID),
+		zap.String("status", string(status)))
+}
@@ -0,0 +1,162 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultInterval is used when no reap interval is configured
+	defaultInterval = time.Minute
+	// defaultBatchSize is used when no per-run limit is configured
+	defaultBatchSize = 50
+)
+
+// Reaper tears down sessions whose ExpiresAt has passed
+type Reaper struct {
+	log     *zap.Logger
+	repo    repository.SessionRepository
+	runtime kubernetes.Runtime
+	cfg     *config.ReaperConfig
+}
+
+// New creates a reaper that finds and updates sessions in repo.
+// runtime may be nil, in which case no releases are removed.
+func New(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, cfg *config.ReaperConfig) *Reaper {
+	return &Reaper{
+		log:     log,
+		repo:    repo,
+		runtime: runtime,
+		cfg:     cfg,
+	}
+}
+
+// Run reaps expired sessions on the configured interval until ctx is cancelled
+func (r *Reaper) Run(ctx context.Context) {
+	interval := time.Duration(r.cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	r.log.Info("Session reaper started",
+		zap.Duration("interval", interval),
+		zap.Duration("grace_period", r.gracePeriod()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.log.Info("Stopping session reaper")
+			return
+		case <-ticker.C:
+			if _, err := r.Reap(ctx); err != nil {
+				r.log.Error("Session reaper run failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Reap expires active sessions whose ExpiresAt is older than the grace
+// period, oldest first and at most batch_size per call. It returns the
+// number of sessions reaped.
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	limit := r.cfg.BatchSize
+	if limit <= 0 {
+		limit = defaultBatchSize
+	}
+
+	cutoff := time.Now().Add(-r.gracePeriod())
+
+	sessions, err := r.repo.ListExpired(ctx, cutoff, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load expired sessions: %w", err)
+	}
+
+	reaped := 0
+	for i := range sessions {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if err := r.Expire(ctx, &sessions[i]); err != nil {
+			r.log.Error("Failed to reap session",
+				zap.Uint("session_id", sessions[i].ID),
+				zap.Error(err))
+			continue
+		}
+		reaped++
+	}
+
+	if len(sessions) > 0 {
+		r.log.Info("Session reaper run finished",
+			zap.Int("expired", len(sessions)),
+			zap.Int("reaped", reaped))
+	}
+
+	return reaped, nil
+}
+
+// Expire moves the session to stopping, tears down its dev container, marks
+// the session stopped and inactive and records a session.expired event. The
+// session is left stopping if the release cannot be removed, so a later run
+// retries it.
+func (r *Reaper) Expire(ctx context.Context, session *models.Session) error {
+	change := repository.StatusChange{Reason: "session expired", Actor: models.ActorReaper}
+	err := r.repo.Update(ctx, session, models.StatusStopping, change, "", nil)
+	if err != nil {
+		return fmt.Errorf("failed to mark session %d stopping: %w", session.ID, err)
+	}
+
+	if err := r.teardown(ctx, session); err != nil {
+		return err
+	}
+
+	change.Reason = "dev container removed"
+	err = r.repo.Update(ctx, session, models.StatusStopped, change, events.SessionExpired, func(stored *models.Session) error {
+		stored.IsActive = false
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mark session %d stopped: %w", session.ID, err)
+	}
+
+	r.log.Info("Session expired",
+		zap.Uint("session_id", session.ID),
+		zap.String("project_uuid", session.ProjectUUID),
+		zap.Time("expires_at", session.ExpiresAt))
+
+	return nil
+}
+
+// teardown removes the session's release, treating an already missing release as success
+func (r *Reaper) teardown(ctx context.Context, session *models.Session) error {
+	if r.runtime == nil || session.ProjectUUID == "" {
+		return nil
+	}
+
+	err := r.runtime.DeleteDevContainer(ctx, session.ProjectUUID)
+	if err != nil && !errors.Is(err, kubernetes.ErrReleaseNotFound) {
+		return fmt.Errorf("failed to delete dev container: %w", err)
+	}
+
+	return nil
+}
+
+// gracePeriod returns how long past ExpiresAt a session is kept before it is reaped
+func (r *Reaper) gracePeriod() time.Duration {
+	if r.cfg.GracePeriod < 0 {
+		return 0
+	}
+	return time.Duration(r.cfg.GracePeriod) * time.Second
+}
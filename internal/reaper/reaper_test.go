@@ -0,0 +1,57 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const testProjectUUID = "550e8400-e29b-41d4-a716-446655440000"
+
+func TestReaper_TeardownDeletesRelease(t *testing.T) {
+	ctx := context.Background()
+	rt := kubernetes.NewFakeRuntime()
+	_, err := rt.CreateDevContainer(ctx, testProjectUUID, 1, 2)
+	require.NoError(t, err)
+
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, &config.ReaperConfig{})
+	session := &models.Session{ID: 1, ProjectUUID: testProjectUUID}
+
+	require.NoError(t, r.teardown(ctx, session))
+	_, ok := rt.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.False(t, ok)
+
+	// A release that is already gone does not block expiry
+	assert.NoError(t, r.teardown(ctx, session))
+}
+
+func TestReaper_TeardownFailure(t *testing.T) {
+	rt := kubernetes.NewFakeRuntime()
+	rt.DeleteErr = errors.New("cluster unreachable")
+
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, &config.ReaperConfig{})
+	err := r.teardown(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	assert.ErrorContains(t, err, "cluster unreachable")
+}
+
+func TestReaper_TeardownWithoutRuntime(t *testing.T) {
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), nil, &config.ReaperConfig{})
+	assert.NoError(t, r.teardown(context.Background(), &models.Session{ProjectUUID: testProjectUUID}))
+}
+
+func TestReaper_GracePeriod(t *testing.T) {
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), nil, &config.ReaperConfig{GracePeriod: 300})
+	assert.Equal(t, 5*time.Minute, r.gracePeriod())
+
+	r.cfg.GracePeriod = -1
+	assert.Equal(t, time.Duration(0), r.gracePeriod())
+}
@@ -0,0 +1,512 @@
+package reconciler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/provisioner"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultInterval is used when no reconcile interval is configured
+	defaultInterval = 5 * time.Minute
+	// defaultStaleAfter is used when no stale_after is configured
+	defaultStaleAfter = 15 * time.Minute
+
+	// MissingMark marks sessions whose release is missing or failed as errored
+	MissingMark = "mark"
+	// MissingReinstall reinstalls missing or failed releases
+	MissingReinstall = "reinstall"
+
+	// OrphanReport only reports releases without an active session
+	OrphanReport = "report"
+	// OrphanUninstall uninstalls releases without an active session
+	OrphanUninstall = "uninstall"
+	// OrphanAdopt creates a session row for releases without an active session
+	OrphanAdopt = "adopt"
+)
+
+// FindingKind classifies a difference between the sessions table and the cluster
+type FindingKind string
+
+const (
+	// KindStatusDrift means the release status differs from the session status
+	KindStatusDrift FindingKind = "status_drift"
+	// KindMissingRelease means an active session has no release
+	KindMissingRelease FindingKind = "missing_release"
+	// KindFailedRelease means the release of an active session has failed
+	KindFailedRelease FindingKind = "failed_release"
+	// KindOrphanRelease means a release has no active session
+	KindOrphanRelease FindingKind = "orphan_release"
+)
+
+// Action is the repair chosen for a finding
+type Action string
+
+const (
+	// ActionNone leaves the finding as a report entry
+	ActionNone Action = "none"
+	// ActionUpdateStatus copies the observed status onto the session row
+	ActionUpdateStatus Action = "update_status"
+	// ActionReinstall installs the release again through the provisioner
+	ActionReinstall Action = "reinstall"
+	// ActionUninstall removes the release
+	ActionUninstall Action = "uninstall"
+	// ActionAdopt records a session row for the release
+	ActionAdopt Action = "adopt"
+)
+
+// Finding is a single difference found during a reconcile run
+type Finding struct {
+	Kind          FindingKind          `json:"kind"`
+	SessionID     uint                 `json:"session_id,omitempty"`
+	ProjectUUID   string               `json:"project_uuid"`
+	Release       string               `json:"release"`
+	SessionStatus models.SessionStatus `json:"session_status,omitempty"`
+	ReleaseStatus string               `json:"release_status,omitempty"`
+	Action        Action               `json:"action"`
+	Applied       bool                 `json:"applied"`
+	Error         string               `json:"error,omitempty"`
+
+	session *models.Session
+	release *kubernetes.Release
+	// target is the status ActionUpdateStatus moves the session to
+	target models.SessionStatus
+}
+
+// Report summarises a reconcile run
+type Report struct {
+	DryRun     bool      `json:"dry_run"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Sessions   int       `json:"sessions"`
+	Releases   int       `json:"releases"`
+	Findings   []Finding `json:"findings"`
+}
+
+// Reconciler compares session rows with live Helm releases and repairs the
+// differences according to the configured policies
+type Reconciler struct {
+	log         *zap.Logger
+	repo        repository.SessionRepository
+	runtime     kubernetes.Runtime
+	provisioner *provisioner.Provisioner
+	cfg         *config.ReconcilerConfig
+
+	// mu keeps periodic and on-demand runs from overlapping
+	mu sync.Mutex
+}
+
+// New creates a reconciler that compares the sessions in repo with the
+// releases of runtime. Reinstalls are queued on prov.
+func New(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, prov *provisioner.Provisioner, cfg *config.ReconcilerConfig) *Reconciler {
+	return &Reconciler{
+		log:         log,
+		repo:        repo,
+		runtime:     runtime,
+		provisioner: prov,
+		cfg:         cfg,
+	}
+}
+
+// Run reconciles on the configured interval until ctx is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	interval := time.Duration(r.cfg.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	r.log.Info("Reconciler started",
+		zap.Duration("interval", interval),
+		zap.Bool("dry_run", r.cfg.DryRun))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.log.Info("Stopping reconciler")
+			return
+		case <-ticker.C:
+			if _, err := r.Reconcile(ctx, r.cfg.DryRun); err != nil {
+				r.log.Error("Reconcile run failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Reconcile performs a single run. With dryRun set the findings and the
+// actions that would be taken are reported but nothing is changed.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (*Report, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := &Report{
+		DryRun:    dryRun,
+		StartedAt: time.Now(),
+	}
+
+	sessions, err := r.repo.ListActive(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+
+	releases, err := r.runtime.ListReleases(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list releases: %w", err)
+	}
+
+	report.Sessions = len(sessions)
+	report.Releases = len(releases)
+	report.Findings = r.inspect(ctx, sessions, releases)
+
+	for i := range report.Findings {
+		finding := &report.Findings[i]
+
+		r.log.Warn("Reconcile finding",
+			zap.String("kind", string(finding.Kind)),
+			zap.String("release", finding.Release),
+			zap.Uint("session_id", finding.SessionID),
+			zap.String("action", string(finding.Action)),
+			zap.Bool("dry_run", dryRun))
+
+		if !dryRun {
+			r.apply(ctx, finding)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+
+	r.log.Info("Reconcile run finished",
+		zap.Int("sessions", report.Sessions),
+		zap.Int("releases", report.Releases),
+		zap.Int("findings", len(report.Findings)),
+		zap.Bool("dry_run", dryRun))
+
+	return report, nil
+}
+
+// inspect compares active sessions with releases and decides on an action
+// for every difference. It reads cluster state but changes nothing.
+func (r *Reconciler) inspect(ctx context.Context, sessions []models.Session, releases []kubernetes.Release) []Finding {
+	findings := []Finding{}
+	known := make(map[string]bool, len(sessions))
+	staleBefore := time.Now().Add(-r.staleAfter())
+
+	for i := range sessions {
+		session := &sessions[i]
+		known[session.ProjectUUID] = true
+
+		// Provisioning, a wake-up or a teardown in flight is left to the
+		// worker that started it, unless it has not moved for stale_after
+		// and the worker is presumed lost
+		stuck := inFlight(session.Status)
+		if stuck && session.UpdatedAt.After(staleBefore) {
+			continue
+		}
+
+		namespace := session.Namespace
+		if namespace == "" {
+			namespace = session.ProjectUUID
+		}
+		releaseName := kubernetes.ReleaseName(session.ProjectUUID)
+
+		finding := Finding{
+			SessionID:     session.ID,
+			ProjectUUID:   session.ProjectUUID,
+			Release:       releaseName,
+			SessionStatus: session.Status,
+			session:       session,
+		}
+
+		status, err := r.runtime.GetContainerStatus(ctx, namespace, releaseName)
+		switch {
+		case errors.Is(err, kubernetes.ErrReleaseNotFound):
+			finding.Kind = KindMissingRelease
+			finding.Action = r.brokenReleaseAction(session)
+			finding.target = models.StatusError
+			// A teardown that was lost after removing the release is done
+			if session.Status == models.StatusStopping {
+				finding.target = models.StatusStopped
+			}
+		case err != nil:
+			r.log.Warn("Failed to get release status",
+				zap.String("release", releaseName),
+				zap.Error(err))
+			continue
+		case status == "error" || (stuck && status == "pending"):
+			// A release that is still installing long after its session
+			// stopped moving counts as failed
+			finding.Kind = KindFailedRelease
+			finding.ReleaseStatus = status
+			finding.Action = r.brokenReleaseAction(session)
+			finding.target = models.StatusError
+		case status == "unknown" || sameStatus(session.Status, status):
+			continue
+		default:
+			finding.Kind = KindStatusDrift
+			finding.ReleaseStatus = status
+			finding.Action = ActionUpdateStatus
+			finding.target = observedStatus(session.Status, status)
+			// Drift the state machine cannot follow, such as a running
+			// session whose pod is pending again, is only reported. A stuck
+			// session is moved through error instead.
+			if !stuck && !reachable(session.Status, finding.target) {
+				finding.Action = ActionNone
+			}
+		}
+
+		findings = append(findings, finding)
+	}
+
+	for i := range releases {
+		rel := &releases[i]
+		if known[rel.ProjectUUID] {
+			continue
+		}
+
+		findings = append(findings, Finding{
+			Kind:          KindOrphanRelease,
+			ProjectUUID:   rel.ProjectUUID,
+			Release:       rel.Name,
+			ReleaseStatus: rel.Status,
+			Action:        r.orphanAction(),
+			release:       rel,
+		})
+	}
+
+	return findings
+}
+
+// inFlight reports whether a worker is moving sessions of status to another
+// status and will record the outcome
+func inFlight(status models.SessionStatus) bool {
+	switch status {
+	case models.StatusPending, models.StatusProvisioning, models.StatusWaking, models.StatusStopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// sameStatus reports whether an observed release status matches the session
+func sameStatus(sessionStatus models.SessionStatus, releaseStatus string) bool {
+	return observedStatus(sessionStatus, releaseStatus) == sessionStatus
+}
+
+// observedStatus returns the session status a release status stands for. A
+// hibernated session is scaled to zero, which the runtime reports as
+// stopped, so a hibernated or waking session with a stopped release is
+// hibernated.
+func observedStatus(sessionStatus models.SessionStatus, releaseStatus string) models.SessionStatus {
+	if releaseStatus == string(models.StatusStopped) &&
+		(sessionStatus == models.StatusHibernated || sessionStatus == models.StatusWaking) {
+		return models.StatusHibernated
+	}
+	return models.SessionStatus(releaseStatus)
+}
+
+// brokenReleaseAction picks the repair for a session whose release is missing or failed
+func (r *Reconciler) brokenReleaseAction(session *models.Session) Action {
+	// A stuck teardown is finished rather than reinstalled
+	if session.Status == models.StatusStopping {
+		return ActionUpdateStatus
+	}
+	if r.cfg.MissingRelease == MissingReinstall {
+		return ActionReinstall
+	}
+	if session.Status == models.StatusError {
+		return ActionNone
+	}
+	return ActionUpdateStatus
+}
+
+// orphanAction picks the repair for a release without an active session
+func (r *Reconciler) orphanAction() Action {
+	switch r.cfg.OrphanRelease {
+	case OrphanUninstall:
+		return ActionUninstall
+	case OrphanAdopt:
+		return ActionAdopt
+	default:
+		return ActionNone
+	}
+}
+
+// apply carries out the action of a finding and records the outcome on it
+func (r *Reconciler) apply(ctx context.Context, finding *Finding) {
+	var err error
+
+	switch finding.Action {
+	case ActionNone:
+		return
+	case ActionUpdateStatus:
+		err = r.moveTo(ctx, finding.session, finding.target, findingReason(finding))
+	case ActionReinstall:
+		err = r.reinstall(ctx, finding)
+	case ActionUninstall:
+		err = r.runtime.DeleteDevContainer(ctx, finding.ProjectUUID)
+	case ActionAdopt:
+		err = r.adopt(ctx, finding.release)
+	default:
+		err = fmt.Errorf("unknown action %q", finding.Action)
+	}
+
+	if err != nil {
+		r.log.Error("Failed to repair reconcile finding",
+			zap.String("release", finding.Release),
+			zap.String("action", string(finding.Action)),
+			zap.Error(err))
+		finding.Error = err.Error()
+		return
+	}
+
+	finding.Applied = true
+}
+
+// findingReason describes a finding for the status history
+func findingReason(finding *Finding) string {
+	switch finding.Kind {
+	case KindMissingRelease:
+		return "release " + finding.Release + " is missing"
+	case KindFailedRelease:
+		return "release " + finding.Release + " has failed"
+	default:
+		return "release " + finding.Release + " is " + finding.ReleaseStatus
+	}
+}
+
+// setStatus moves a session to status for reason and records the change
+func (r *Reconciler) setStatus(ctx context.Context, session *models.Session, status models.SessionStatus, reason string) error {
+	change := repository.StatusChange{Reason: reason, Actor: models.ActorReconciler}
+	return r.repo.Update(ctx, session, status, change, events.StatusEvent(status), nil)
+}
+
+// moveTo moves a session to status for reason, going through stopping on
+// the way to stopped and through error when the state machine does not
+// allow the move otherwise, as for a session stuck in pending whose release
+// is running
+func (r *Reconciler) moveTo(ctx context.Context, session *models.Session, status models.SessionStatus, reason string) error {
+	if !session.Status.CanTransitionTo(status) {
+		via := models.StatusError
+		if reachable(session.Status, status) {
+			via = models.StatusStopping
+		}
+		if err := r.setStatus(ctx, session, via, reason); err != nil {
+			return err
+		}
+	}
+	return r.setStatus(ctx, session, status, reason)
+}
+
+// reachable reports whether a session may move from from to to, directly
+// or, for a running session whose release was scaled down, through stopping
+func reachable(from, to models.SessionStatus) bool {
+	return from.CanTransitionTo(to) || (from == models.StatusRunning && to == models.StatusStopped)
+}
+
+// reinstall removes a failed release and queues the session for provisioning again
+func (r *Reconciler) reinstall(ctx context.Context, finding *Finding) error {
+	if finding.Kind == KindFailedRelease {
+		err := r.runtime.DeleteDevContainer(ctx, finding.ProjectUUID)
+		if err != nil && !errors.Is(err, kubernetes.ErrReleaseNotFound) {
+			return err
+		}
+	}
+
+	// A session can only be queued again once it is known to be broken
+	if !finding.session.Status.CanTransitionTo(models.StatusPending) {
+		if err := r.setStatus(ctx, finding.session, models.StatusError, findingReason(finding)); err != nil {
+			return err
+		}
+	}
+	if err := r.setStatus(ctx, finding.session, models.StatusPending, "reinstalling release "+finding.Release); err != nil {
+		return err
+	}
+
+	return r.provisioner.Enqueue(*finding.session)
+}
+
+// adopt records an active session for a release that has none. The session
+// is created pending and then moved to the status matching the release, so
+// the status history shows how it got there.
+func (r *Reconciler) adopt(ctx context.Context, rel *kubernetes.Release) error {
+	session := models.Session{
+		UserID:        rel.UserID,
+		ProjectID:     rel.ProjectID,
+		ProjectUUID:   rel.ProjectUUID,
+		ExpiresAt:     time.Now().Add(models.AlwaysOnSessionDuration),
+		ContainerName: rel.Name,
+		Namespace:     rel.Namespace,
+		Status:        models.StatusPending,
+		IsActive:      true,
+	}
+	// Nobody learns the token of an adopted session; its owner rotates it to get one
+	session.SetToken(uuid.New().String())
+
+	endpoints, err := r.runtime.GetServiceEndpoints(ctx, rel.Namespace, rel.Name)
+	if err != nil {
+		r.log.Warn("Failed to get service endpoints for adopted release",
+			zap.String("release", rel.Name),
+			zap.Error(err))
+	} else {
+		session.IPAddress = endpoints.ClusterIP
+		session.PreviewURL = endpoints.PreviewURL
+		session.PreviewPath = endpoints.PreviewPath
+		session.ChatURL = endpoints.ChatURL
+		session.ChatPath = endpoints.ChatPath
+		session.VscodeURL = endpoints.VscodeURL
+		session.VscodePath = endpoints.VscodePath
+		session.InternalPreviewURL = endpoints.InternalPreviewURL
+		session.InternalChatURL = endpoints.InternalChatURL
+		session.InternalVscodeURL = endpoints.InternalVscodeURL
+	}
+
+	if err := r.repo.Create(ctx, &session, events.SessionCreated); err != nil {
+		return err
+	}
+
+	reason := "adopted release " + rel.Name + " (" + rel.Status + ")"
+	for _, status := range adoptionPath(rel.Status) {
+		if err := r.setStatus(ctx, &session, status, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adoptionPath returns the statuses an adopted session goes through from
+// pending to match a release status. Failed releases and releases in a state
+// the runtime does not know are adopted as errored.
+func adoptionPath(releaseStatus string) []models.SessionStatus {
+	switch releaseStatus {
+	case string(models.StatusRunning):
+		return []models.SessionStatus{models.StatusProvisioning, models.StatusRunning}
+	case string(models.StatusPending):
+		return []models.SessionStatus{models.StatusProvisioning}
+	case string(models.StatusStopped):
+		return []models.SessionStatus{models.StatusStopping, models.StatusStopped}
+	default:
+		return []models.SessionStatus{models.StatusError}
+	}
+}
+
+// staleAfter returns how long a session may sit in an in-flight status
+// before the reconciler takes it over from its worker
+func (r *Reconciler) staleAfter() time.Duration {
+	if r.cfg.StaleAfter <= 0 {
+		return defaultStaleAfter
+	}
+	return time.Duration(r.cfg.StaleAfter) * time.Second
+}
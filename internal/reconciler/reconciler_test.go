@@ -0,0 +1,273 @@
+package reconciler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"github.com/villageFlower/paypilot_dev_session_service/pkg/config"
+	"go.uber.org/zap"
+)
+
+const (
+	runningUUID = "550e8400-e29b-41d4-a716-446655440000"
+	missingUUID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+	failedUUID  = "6ba7b811-9dad-11d1-80b4-00c04fd430c8"
+	driftUUID   = "6ba7b812-9dad-11d1-80b4-00c04fd430c8"
+	orphanUUID  = "6ba7b814-9dad-11d1-80b4-00c04fd430c8"
+	pendingUUID = "6ba7b815-9dad-11d1-80b4-00c04fd430c8"
+)
+
+// newFixture installs releases in a fake runtime and returns matching session rows
+func newFixture(t *testing.T) (*kubernetes.FakeRuntime, []models.Session, []kubernetes.Release) {
+	ctx := context.Background()
+	rt := kubernetes.NewFakeRuntime()
+
+	for _, projectUUID := range []string{runningUUID, failedUUID, driftUUID, orphanUUID} {
+		_, err := rt.CreateDevContainer(ctx, projectUUID, 1, 2)
+		require.NoError(t, err)
+	}
+	rt.SetStatus(kubernetes.ReleaseName(failedUUID), "error")
+
+	sessions := []models.Session{
+		{ID: 1, ProjectUUID: runningUUID, Namespace: runningUUID, Status: "running"},
+		{ID: 2, ProjectUUID: missingUUID, Namespace: missingUUID, Status: "running"},
+		{ID: 3, ProjectUUID: failedUUID, Namespace: failedUUID, Status: "running"},
+		{ID: 4, ProjectUUID: driftUUID, Namespace: driftUUID, Status: "error"},
+		{ID: 5, ProjectUUID: pendingUUID, Namespace: pendingUUID, Status: "pending", UpdatedAt: time.Now()},
+	}
+
+	releases, err := rt.ListReleases(ctx)
+	require.NoError(t, err)
+
+	return rt, sessions, releases
+}
+
+func TestReconciler_InspectDefaultPolicies(t *testing.T) {
+	rt, sessions, releases := newFixture(t)
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, nil, &config.ReconcilerConfig{})
+
+	findings := r.inspect(context.Background(), sessions, releases)
+	require.Len(t, findings, 4)
+
+	assert.Equal(t, KindMissingRelease, findings[0].Kind)
+	assert.Equal(t, uint(2), findings[0].SessionID)
+	assert.Equal(t, ActionUpdateStatus, findings[0].Action)
+
+	assert.Equal(t, KindFailedRelease, findings[1].Kind)
+	assert.Equal(t, uint(3), findings[1].SessionID)
+	assert.Equal(t, ActionUpdateStatus, findings[1].Action)
+
+	assert.Equal(t, KindStatusDrift, findings[2].Kind)
+	assert.Equal(t, uint(4), findings[2].SessionID)
+	assert.Equal(t, "running", findings[2].ReleaseStatus)
+	assert.Equal(t, ActionUpdateStatus, findings[2].Action)
+
+	assert.Equal(t, KindOrphanRelease, findings[3].Kind)
+	assert.Equal(t, orphanUUID, findings[3].ProjectUUID)
+	assert.Equal(t, ActionNone, findings[3].Action)
+}
+
+func TestReconciler_InspectRepairPolicies(t *testing.T) {
+	rt, sessions, releases := newFixture(t)
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, nil, &config.ReconcilerConfig{
+		MissingRelease: MissingReinstall,
+		OrphanRelease:  OrphanAdopt,
+	})
+
+	findings := r.inspect(context.Background(), sessions, releases)
+	require.Len(t, findings, 4)
+
+	assert.Equal(t, ActionReinstall, findings[0].Action)
+	assert.Equal(t, ActionReinstall, findings[1].Action)
+	assert.Equal(t, ActionAdopt, findings[3].Action)
+
+	r.cfg.OrphanRelease = OrphanUninstall
+	assert.Equal(t, ActionUninstall, r.orphanAction())
+}
+
+func TestReconciler_ApplyUninstall(t *testing.T) {
+	rt, sessions, releases := newFixture(t)
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, nil, &config.ReconcilerConfig{OrphanRelease: OrphanUninstall})
+
+	findings := r.inspect(context.Background(), sessions, releases)
+	orphan := findings[len(findings)-1]
+	require.Equal(t, KindOrphanRelease, orphan.Kind)
+
+	r.apply(context.Background(), &orphan)
+	assert.True(t, orphan.Applied)
+	assert.Empty(t, orphan.Error)
+
+	_, ok := rt.Release(kubernetes.ReleaseName(orphanUUID))
+	assert.False(t, ok)
+}
+
+func TestReconciler_MarkLeavesErroredSessionAlone(t *testing.T) {
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), kubernetes.NewFakeRuntime(), nil, &config.ReconcilerConfig{})
+
+	assert.Equal(t, ActionNone, r.brokenReleaseAction(&models.Session{Status: "error"}))
+	assert.Equal(t, ActionUpdateStatus, r.brokenReleaseAction(&models.Session{Status: "running"}))
+}
+
+func TestSameStatus(t *testing.T) {
+	assert.True(t, sameStatus("running", "running"))
+	assert.True(t, sameStatus("hibernated", "stopped"))
+	assert.False(t, sameStatus("hibernated", "running"))
+	assert.False(t, sameStatus("stopped", "running"))
+}
+
+func TestReconciler_InspectReportsDriftTheStateMachineCannotFollow(t *testing.T) {
+	rt, _, _ := newFixture(t)
+	rt.SetStatus(kubernetes.ReleaseName(runningUUID), "pending")
+	r := New(zap.NewNop(), repository.NewMemorySessionRepository(), rt, nil, &config.ReconcilerConfig{})
+
+	sessions := []models.Session{
+		{ID: 1, ProjectUUID: runningUUID, Namespace: runningUUID, Status: models.StatusRunning},
+		// A teardown in flight is left to the worker that started it
+		{ID: 6, ProjectUUID: driftUUID, Namespace: driftUUID, Status: models.StatusStopping, UpdatedAt: time.Now()},
+	}
+
+	findings := r.inspect(context.Background(), sessions, nil)
+	require.Len(t, findings, 1)
+	assert.Equal(t, KindStatusDrift, findings[0].Kind)
+	assert.Equal(t, "pending", findings[0].ReleaseStatus)
+	assert.Equal(t, ActionNone, findings[0].Action)
+}
+
+func TestReconciler_StopsScaledDownSessionThroughStopping(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	_, err := rt.CreateDevContainer(ctx, runningUUID, 1, 2)
+	require.NoError(t, err)
+	rt.SetStatus(kubernetes.ReleaseName(runningUUID), "stopped")
+	session := storeSession(t, repo, runningUUID, models.StatusProvisioning, models.StatusRunning)
+
+	r := New(zap.NewNop(), repo, rt, nil, &config.ReconcilerConfig{})
+	report, err := r.Reconcile(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Findings, 1)
+	assert.Equal(t, ActionUpdateStatus, report.Findings[0].Action)
+	assert.Equal(t, models.StatusStopped, statusOf(t, repo, session.ID))
+
+	history, _, err := repo.StatusHistory(ctx, session.ID, repository.NewPage(1, 2))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.StatusStopped, history[0].ToStatus)
+	assert.Equal(t, models.StatusStopping, history[1].ToStatus)
+}
+
+// storeSession stores a session for projectUUID and walks it to status
+func storeSession(t *testing.T, repo *repository.MemorySessionRepository, projectUUID string, path ...models.SessionStatus) *models.Session {
+	ctx := context.Background()
+	session := &models.Session{ProjectUUID: projectUUID, Namespace: projectUUID, TokenHash: models.HashToken(projectUUID)}
+	require.NoError(t, repo.Create(ctx, session, ""))
+	for _, status := range path {
+		require.NoError(t, repo.Transition(ctx, session, status, repository.StatusChange{}, ""))
+	}
+	return session
+}
+
+// statusOf returns the stored status of a session
+func statusOf(t *testing.T, repo *repository.MemorySessionRepository, id uint) models.SessionStatus {
+	stored, err := repo.Get(context.Background(), id)
+	require.NoError(t, err)
+	return stored.Status
+}
+
+func TestReconciler_ReconcilesStuckSessions(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	for _, projectUUID := range []string{runningUUID, failedUUID, driftUUID} {
+		_, err := rt.CreateDevContainer(ctx, projectUUID, 1, 2)
+		require.NoError(t, err)
+	}
+	rt.SetStatus(kubernetes.ReleaseName(failedUUID), "pending")
+	rt.SetStatus(kubernetes.ReleaseName(driftUUID), "stopped")
+
+	lost := storeSession(t, repo, runningUUID)
+	installing := storeSession(t, repo, failedUUID, models.StatusProvisioning)
+	waking := storeSession(t, repo, driftUUID,
+		models.StatusProvisioning, models.StatusRunning, models.StatusHibernated, models.StatusWaking)
+	stopping := storeSession(t, repo, missingUUID, models.StatusStopping)
+	recent := storeSession(t, repo, pendingUUID)
+
+	sessions, err := repo.ListActive(ctx)
+	require.NoError(t, err)
+	for i := range sessions {
+		if sessions[i].ID != recent.ID {
+			sessions[i].UpdatedAt = time.Now().Add(-time.Hour)
+		}
+	}
+
+	r := New(zap.NewNop(), repo, rt, nil, &config.ReconcilerConfig{})
+	findings := r.inspect(ctx, sessions, nil)
+	require.Len(t, findings, 4, "the session that moved recently is left to its worker")
+	for i := range findings {
+		r.apply(ctx, &findings[i])
+		assert.True(t, findings[i].Applied, findings[i].Error)
+	}
+
+	assert.Equal(t, models.StatusRunning, statusOf(t, repo, lost.ID))
+	assert.Equal(t, models.StatusError, statusOf(t, repo, installing.ID), "a release still installing counts as failed")
+	assert.Equal(t, models.StatusHibernated, statusOf(t, repo, waking.ID))
+	assert.Equal(t, models.StatusStopped, statusOf(t, repo, stopping.ID))
+	assert.Equal(t, models.StatusPending, statusOf(t, repo, recent.ID))
+
+	// Pending cannot move to running directly, so the lost job goes through error
+	history, _, err := repo.StatusHistory(ctx, lost.ID, repository.NewPage(1, 10))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.StatusRunning, history[0].ToStatus)
+	assert.Equal(t, models.StatusError, history[1].ToStatus)
+	assert.Equal(t, models.ActorReconciler, history[0].Actor)
+}
+
+func TestReconciler_AdoptMapsReleaseStatus(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemorySessionRepository()
+	rt := kubernetes.NewFakeRuntime()
+	statuses := map[string]string{
+		runningUUID: "running",
+		driftUUID:   "stopped",
+		failedUUID:  "error",
+		orphanUUID:  "unknown",
+		pendingUUID: "pending",
+	}
+	for projectUUID, status := range statuses {
+		_, err := rt.CreateDevContainer(ctx, projectUUID, 1, 2)
+		require.NoError(t, err)
+		rt.SetStatus(kubernetes.ReleaseName(projectUUID), status)
+	}
+
+	r := New(zap.NewNop(), repo, rt, nil, &config.ReconcilerConfig{OrphanRelease: OrphanAdopt})
+	report, err := r.Reconcile(ctx, false)
+	require.NoError(t, err)
+	require.Len(t, report.Findings, len(statuses))
+
+	sessions, err := repo.ListActive(ctx)
+	require.NoError(t, err)
+	adopted := make(map[string]models.Session, len(sessions))
+	for _, session := range sessions {
+		adopted[session.ProjectUUID] = session
+	}
+
+	assert.Equal(t, models.StatusRunning, adopted[runningUUID].Status)
+	assert.Equal(t, models.StatusStopped, adopted[driftUUID].Status)
+	assert.Equal(t, models.StatusError, adopted[failedUUID].Status)
+	assert.Equal(t, models.StatusError, adopted[orphanUUID].Status, "unknown is not a session status")
+	assert.Equal(t, models.StatusProvisioning, adopted[pendingUUID].Status)
+
+	history, _, err := repo.StatusHistory(ctx, adopted[runningUUID].ID, repository.NewPage(1, 10))
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.StatusPending, history[1].FromStatus)
+	assert.Equal(t, models.StatusRunning, history[0].ToStatus)
+	assert.Equal(t, models.ActorReconciler, history[0].Actor)
+}
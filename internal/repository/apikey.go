@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyRepository stores API keys. Revoked keys are kept so that their
+// issuance stays on record.
+type APIKeyRepository interface {
+	// Get returns the API key with the given ID
+	Get(ctx context.Context, id uint) (*models.APIKey, error)
+	// GetByPrefix returns the API key with the given public prefix
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	// List returns a page of API keys, newest first, and the total number of keys
+	List(ctx context.Context, page Page) ([]models.APIKey, int64, error)
+	// Create inserts a new API key and fills in its ID and timestamps
+	Create(ctx context.Context, key *models.APIKey) error
+	// Save writes every field of an existing API key
+	Save(ctx context.Context, key *models.APIKey) error
+	// Touch records that the API key was used at
+	Touch(ctx context.Context, id uint, at time.Time) error
+}
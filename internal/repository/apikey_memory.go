@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// MemoryAPIKeyRepository keeps API keys in memory for tests and local runs
+type MemoryAPIKeyRepository struct {
+	mu     sync.Mutex
+	keys   map[uint]models.APIKey
+	nextID uint
+}
+
+// NewMemoryAPIKeyRepository creates an empty in-memory repository
+func NewMemoryAPIKeyRepository() *MemoryAPIKeyRepository {
+	return &MemoryAPIKeyRepository{keys: map[uint]models.APIKey{}}
+}
+
+// Get returns the API key with the given ID
+func (r *MemoryAPIKeyRepository) Get(ctx context.Context, id uint) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+// GetByPrefix returns the API key with the given public prefix
+func (r *MemoryAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			return &key, nil
+		}
+	}
+	return nil, ErrAPIKeyNotFound
+}
+
+// List returns a page of API keys, newest first, and the total number of keys
+func (r *MemoryAPIKeyRepository) List(ctx context.Context, page Page) ([]models.APIKey, int64, error) {
+	r.mu.Lock()
+	keys := make([]models.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, key)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+
+	total := int64(len(keys))
+	start := min(page.Offset(), len(keys))
+	end := min(start+page.Size, len(keys))
+	return keys[start:end], total, nil
+}
+
+// Create inserts a new API key
+func (r *MemoryAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	key.ID = r.nextID
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	r.keys[key.ID] = *key
+	return nil
+}
+
+// Save writes every field of an existing API key
+func (r *MemoryAPIKeyRepository) Save(ctx context.Context, key *models.APIKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[key.ID]; !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.UpdatedAt = time.Now()
+	r.keys[key.ID] = *key
+	return nil
+}
+
+// Touch records that the API key was used at
+func (r *MemoryAPIKeyRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	key.LastUsedAt = &at
+	r.keys[id] = key
+	return nil
+}
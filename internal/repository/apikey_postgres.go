@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+)
+
+// PostgresAPIKeyRepository stores API keys in Postgres through GORM
+type PostgresAPIKeyRepository struct {
+	db *gorm.DB
+}
+
+// NewPostgresAPIKeyRepository creates a repository on db
+func NewPostgresAPIKeyRepository(db *gorm.DB) *PostgresAPIKeyRepository {
+	return &PostgresAPIKeyRepository{db: db}
+}
+
+// Get returns the API key with the given ID
+func (r *PostgresAPIKeyRepository) Get(ctx context.Context, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).First(&key, id).Error; err != nil {
+		return nil, translateAPIKey(err, "load")
+	}
+	return &key, nil
+}
+
+// GetByPrefix returns the API key with the given public prefix
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.WithContext(ctx).Where("prefix = ?", prefix).First(&key).Error; err != nil {
+		return nil, translateAPIKey(err, "load")
+	}
+	return &key, nil
+}
+
+// List returns a page of API keys, newest first, and the total number of keys
+func (r *PostgresAPIKeyRepository) List(ctx context.Context, page Page) ([]models.APIKey, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.APIKey{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translateAPIKey(err, "count")
+	}
+
+	keys := []models.APIKey{}
+	err := query.Order("id DESC").Limit(page.Size).Offset(page.Offset()).Find(&keys).Error
+	if err != nil {
+		return nil, 0, translateAPIKey(err, "list")
+	}
+
+	return keys, total, nil
+}
+
+// Create inserts a new API key
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	if err := r.db.WithContext(ctx).Create(key).Error; err != nil {
+		return translateAPIKey(err, "create")
+	}
+	return nil
+}
+
+// Save writes every field of an existing API key
+func (r *PostgresAPIKeyRepository) Save(ctx context.Context, key *models.APIKey) error {
+	if err := r.db.WithContext(ctx).Save(key).Error; err != nil {
+		return translateAPIKey(err, "save")
+	}
+	return nil
+}
+
+// Touch records that the API key was used at
+func (r *PostgresAPIKeyRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).UpdateColumn("last_used_at", at).Error
+	if err != nil {
+		return translateAPIKey(err, "touch")
+	}
+	return nil
+}
+
+// translateAPIKey maps GORM errors onto the repository's errors
+func translateAPIKey(err error, operation string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrAPIKeyNotFound
+	}
+	return fmt.Errorf("failed to %s api key: %w", operation, err)
+}
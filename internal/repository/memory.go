@@ -0,0 +1,398 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+)
+
+// RecordedEvent is a lifecycle event recorded by MemorySessionRepository
+type RecordedEvent struct {
+	Type    string
+	Session models.Session
+}
+
+// MemorySessionRepository keeps sessions in memory for tests and local
+// runs. Like the sessions table, it keeps project UUIDs and token hashes unique
+// among sessions that are not deleted, and it keeps recorded events instead
+// of writing them to an outbox.
+type MemorySessionRepository struct {
+	mu           sync.Mutex
+	sessions     map[uint]models.Session
+	nextID       uint
+	events       []RecordedEvent
+	history      []models.SessionStatusChange
+	projectLocks map[string]chan struct{}
+}
+
+// NewMemorySessionRepository creates an empty in-memory repository
+func NewMemorySessionRepository() *MemorySessionRepository {
+	return &MemorySessionRepository{
+		sessions:     map[uint]models.Session{},
+		projectLocks: map[string]chan struct{}{},
+	}
+}
+
+// Get returns the session with the given ID
+func (r *MemorySessionRepository) Get(ctx context.Context, id uint) (*models.Session, error) {
+	return r.find(func(s *models.Session) bool { return s.ID == id })
+}
+
+// GetByProjectUUID returns the session of a project
+func (r *MemorySessionRepository) GetByProjectUUID(ctx context.Context, projectUUID string) (*models.Session, error) {
+	return r.find(func(s *models.Session) bool { return s.ProjectUUID == projectUUID })
+}
+
+// GetByToken returns the session authenticated by token
+func (r *MemorySessionRepository) GetByToken(ctx context.Context, token string) (*models.Session, error) {
+	now := time.Now()
+	return r.find(func(s *models.Session) bool { return s.Authenticates(token, now) })
+}
+
+// List returns a page of the sessions matching filter and the total number of matches
+func (r *MemorySessionRepository) List(ctx context.Context, filter SessionFilter, page Page) ([]models.Session, int64, error) {
+	matches := r.all(func(s *models.Session) bool {
+		return (filter.UserID == nil || s.UserID == *filter.UserID) &&
+			(filter.ProjectID == nil || s.ProjectID == *filter.ProjectID) &&
+			(filter.Status == "" || s.Status == filter.Status)
+	})
+
+	total := int64(len(matches))
+	start := min(page.Offset(), len(matches))
+	end := min(start+page.Size, len(matches))
+	return matches[start:end], total, nil
+}
+
+// ListActive returns every active session that belongs to a project
+func (r *MemorySessionRepository) ListActive(ctx context.Context) ([]models.Session, error) {
+	return r.all(func(s *models.Session) bool { return s.IsActive && s.ProjectUUID != "" }), nil
+}
+
+// ListExpired returns up to limit active sessions that expired before cutoff
+func (r *MemorySessionRepository) ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error) {
+	matches := r.all(func(s *models.Session) bool { return s.IsActive && s.ExpiresAt.Before(cutoff) })
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].ExpiresAt.Before(matches[j].ExpiresAt) })
+	return matches[:min(limit, len(matches))], nil
+}
+
+// ListIdle returns up to limit running sessions not accessed since cutoff
+func (r *MemorySessionRepository) ListIdle(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error) {
+	matches := r.all(func(s *models.Session) bool {
+		return s.IsActive && s.Status == models.StatusRunning && s.ProjectUUID != "" && s.IdleSince().Before(cutoff)
+	})
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].IdleSince().Before(matches[j].IdleSince()) })
+	return matches[:min(limit, len(matches))], nil
+}
+
+// History returns a page of a project's sessions, deleted ones included, newest first
+func (r *MemorySessionRepository) History(ctx context.Context, projectUUID string, userID *int, page Page) ([]models.Session, int64, error) {
+	r.mu.Lock()
+	matches := []models.Session{}
+	for _, session := range r.sessions {
+		if session.ProjectUUID == projectUUID && (userID == nil || session.UserID == *userID) {
+			matches = append(matches, session)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID > matches[j].ID })
+
+	total := int64(len(matches))
+	start := min(page.Offset(), len(matches))
+	end := min(start+page.Size, len(matches))
+	return matches[start:end], total, nil
+}
+
+// Create inserts a new session
+func (r *MemorySessionRepository) Create(ctx context.Context, session *models.Session, eventType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conflicts(session) {
+		return ErrConflict
+	}
+
+	// Mirror the column defaults of the sessions table
+	if session.Status == "" {
+		session.Status = models.StatusPending
+	}
+	session.IsActive = true
+
+	r.nextID++
+	now := time.Now()
+	session.ID = r.nextID
+	session.CreatedAt = now
+	session.UpdatedAt = now
+
+	r.store(session, eventType)
+	return nil
+}
+
+// Save writes every field of an existing session except its status
+func (r *MemorySessionRepository) Save(ctx context.Context, session *models.Session, eventType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(session.ID)
+	if !ok {
+		return ErrNotFound
+	}
+	if r.conflicts(session) {
+		return ErrConflict
+	}
+
+	session.UpdatedAt = time.Now()
+	saved := *session
+	saved.Status = stored.Status
+	saved.Token = ""
+	r.sessions[session.ID] = saved
+	r.record(session, eventType)
+	return nil
+}
+
+// Transition moves a session to status and writes its other fields with it
+func (r *MemorySessionRepository) Transition(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(session.ID)
+	switch {
+	case !ok:
+		return ErrNotFound
+	case stored.Status != session.Status:
+		return fmt.Errorf("%w: session %d is %s, not %s", ErrStaleStatus, session.ID, stored.Status, session.Status)
+	case r.conflicts(session):
+		return ErrConflict
+	}
+	if err := models.CheckTransition(stored.Status, status); err != nil {
+		return err
+	}
+
+	r.changeStatus(session, status, change)
+	r.store(session, eventType)
+	return nil
+}
+
+// Update moves a session to status and writes the stored session with fn applied
+func (r *MemorySessionRepository) Update(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string, fn func(stored *models.Session) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(session.ID)
+	switch {
+	case !ok:
+		return ErrNotFound
+	case stored.Status != session.Status:
+		return fmt.Errorf("%w: session %d is %s, not %s", ErrStaleStatus, session.ID, stored.Status, session.Status)
+	}
+	if fn != nil {
+		if err := fn(&stored); err != nil {
+			return err
+		}
+	}
+	if r.conflicts(&stored) {
+		return ErrConflict
+	}
+	if err := models.CheckTransition(stored.Status, status); err != nil {
+		return err
+	}
+
+	r.changeStatus(&stored, status, change)
+	stored.Token = session.Token
+	*session = stored
+	r.store(session, eventType)
+	return nil
+}
+
+// Touch records that the session was accessed at
+func (r *MemorySessionRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(id)
+	if !ok {
+		return nil
+	}
+	stored.LastAccessedAt = &at
+	r.sessions[id] = stored
+	return nil
+}
+
+// StatusHistory returns a page of a session's status changes, newest first
+func (r *MemorySessionRepository) StatusHistory(ctx context.Context, sessionID uint, page Page) ([]models.SessionStatusChange, int64, error) {
+	r.mu.Lock()
+	matches := []models.SessionStatusChange{}
+	for i := len(r.history) - 1; i >= 0; i-- {
+		if r.history[i].SessionID == sessionID {
+			matches = append(matches, r.history[i])
+		}
+	}
+	r.mu.Unlock()
+
+	total := int64(len(matches))
+	start := min(page.Offset(), len(matches))
+	end := min(start+page.Size, len(matches))
+	return matches[start:end], total, nil
+}
+
+// Delete soft-deletes a session
+func (r *MemorySessionRepository) Delete(ctx context.Context, session *models.Session, eventType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.live(session.ID)
+	if !ok {
+		return ErrNotFound
+	}
+
+	stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	session.DeletedAt = stored.DeletedAt
+
+	r.store(&stored, eventType)
+	return nil
+}
+
+// Restore undeletes a soft-deleted session
+func (r *MemorySessionRepository) Restore(ctx context.Context, id uint, eventType string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.sessions[id]
+	switch {
+	case !ok:
+		return nil, ErrNotFound
+	case !stored.DeletedAt.Valid:
+		return nil, ErrNotDeleted
+	}
+
+	stored.DeletedAt = gorm.DeletedAt{}
+	if r.conflicts(&stored) {
+		return nil, ErrConflict
+	}
+
+	r.store(&stored, eventType)
+	return &stored, nil
+}
+
+// Purge removes a session permanently
+func (r *MemorySessionRepository) Purge(ctx context.Context, session *models.Session, eventType string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, session.ID)
+	r.record(session, eventType)
+	return nil
+}
+
+// WithProjectLock runs fn while holding the in-process lock on projectUUID
+func (r *MemorySessionRepository) WithProjectLock(ctx context.Context, projectUUID string, fn func(ctx context.Context) error) error {
+	r.mu.Lock()
+	lock, ok := r.projectLocks[projectUUID]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		r.projectLocks[projectUUID] = lock
+	}
+	r.mu.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-lock }()
+
+	return fn(ctx)
+}
+
+// Events returns the events recorded so far
+func (r *MemorySessionRepository) Events() []RecordedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedEvent(nil), r.events...)
+}
+
+// find returns a copy of the first live session matching match
+func (r *MemorySessionRepository) find(match func(*models.Session) bool) (*models.Session, error) {
+	matches := r.all(match)
+	if len(matches) == 0 {
+		return nil, ErrNotFound
+	}
+	return &matches[0], nil
+}
+
+// all returns copies of the live sessions matching match, ordered by ID
+func (r *MemorySessionRepository) all(match func(*models.Session) bool) []models.Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matches := []models.Session{}
+	for _, session := range r.sessions {
+		if !session.DeletedAt.Valid && match(&session) {
+			matches = append(matches, session)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return matches
+}
+
+// live returns the stored session with the given ID unless it is soft-deleted
+func (r *MemorySessionRepository) live(id uint) (models.Session, bool) {
+	session, ok := r.sessions[id]
+	return session, ok && !session.DeletedAt.Valid
+}
+
+// conflicts reports whether another session that is not deleted has the
+// session's project UUID or token
+func (r *MemorySessionRepository) conflicts(session *models.Session) bool {
+	for id, other := range r.sessions {
+		if id == session.ID || other.DeletedAt.Valid {
+			continue
+		}
+		if other.ProjectUUID == session.ProjectUUID || other.TokenHash == session.TokenHash {
+			return true
+		}
+	}
+	return false
+}
+
+// changeStatus moves session to status, recording the change in the status
+// history unless the status stays the same and the change is not repeated
+func (r *MemorySessionRepository) changeStatus(session *models.Session, status models.SessionStatus, change StatusChange) {
+	now := time.Now()
+	if session.Status != status || change.Repeat {
+		r.history = append(r.history, models.SessionStatusChange{
+			ID:         uint(len(r.history) + 1),
+			CreatedAt:  now,
+			SessionID:  session.ID,
+			FromStatus: session.Status,
+			ToStatus:   status,
+			Reason:     change.Reason,
+			Actor:      change.Actor,
+		})
+	}
+
+	session.Status = status
+	session.UpdatedAt = now
+}
+
+// store saves a copy of session, without its plaintext token, and records
+// eventType
+func (r *MemorySessionRepository) store(session *models.Session, eventType string) {
+	stored := *session
+	stored.Token = ""
+	r.sessions[session.ID] = stored
+	r.record(session, eventType)
+}
+
+// record keeps eventType unless it is empty
+func (r *MemorySessionRepository) record(session *models.Session, eventType string) {
+	if eventType != "" {
+		r.events = append(r.events, RecordedEvent{Type: eventType, Session: *session})
+	}
+}
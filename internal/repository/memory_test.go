@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+)
+
+func newSession(n int) *models.Session {
+	return &models.Session{
+		UserID:      n%2 + 1,
+		ProjectID:   n,
+		ProjectUUID: fmt.Sprintf("project-%d", n),
+		TokenHash:   models.HashToken(fmt.Sprintf("token-%d", n)),
+	}
+}
+
+func TestMemorySessionRepository_Create(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, "dev_session.created"))
+	assert.Equal(t, uint(1), session.ID)
+	assert.Equal(t, models.StatusPending, session.Status)
+	assert.True(t, session.IsActive)
+	assert.False(t, session.CreatedAt.IsZero())
+
+	byToken, err := repo.GetByToken(ctx, "token-1")
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, byToken.ID)
+
+	duplicate := newSession(2)
+	duplicate.ProjectUUID = session.ProjectUUID
+	assert.ErrorIs(t, repo.Create(ctx, duplicate, ""), ErrConflict)
+
+	events := repo.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "dev_session.created", events[0].Type)
+	assert.Equal(t, session.ID, events[0].Session.ID)
+}
+
+func TestMemorySessionRepository_ReturnsCopies(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, newSession(1), ""))
+
+	loaded, err := repo.Get(ctx, 1)
+	require.NoError(t, err)
+	loaded.UserID = 99
+
+	reloaded, err := repo.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.NotEqual(t, 99, reloaded.UserID, "changes must only be stored by Save")
+
+	require.NoError(t, repo.Save(ctx, loaded, ""))
+	reloaded, err = repo.Get(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 99, reloaded.UserID)
+}
+
+func TestMemorySessionRepository_SaveKeepsStatus(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, ""))
+	session.Status = models.StatusRunning
+	require.NoError(t, repo.Save(ctx, session, ""))
+
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusPending, stored.Status, "the status only changes through Transition")
+}
+
+func TestMemorySessionRepository_Transition(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, ""))
+	session.ContainerName = "dev-project-1"
+
+	change := StatusChange{Reason: "dev container installation started", Actor: models.ActorProvisioner}
+	require.NoError(t, repo.Transition(ctx, session, models.StatusProvisioning, change, "dev_session.provisioning"))
+	assert.Equal(t, models.StatusProvisioning, session.Status)
+
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProvisioning, stored.Status)
+	assert.Equal(t, "dev-project-1", stored.ContainerName, "the other fields are written with the status")
+
+	// Provisioning sessions cannot be stopped directly, only through stopping
+	err = repo.Transition(ctx, session, models.StatusStopped, StatusChange{}, "")
+	assert.ErrorIs(t, err, models.ErrInvalidTransition)
+	assert.Equal(t, models.StatusProvisioning, session.Status)
+
+	// A caller holding an outdated copy cannot overwrite the status
+	stale := *session
+	stale.Status = models.StatusPending
+	assert.ErrorIs(t, repo.Transition(ctx, &stale, models.StatusError, StatusChange{}, ""), ErrStaleStatus)
+
+	assert.ErrorIs(t, repo.Transition(ctx, &models.Session{ID: 42}, models.StatusError, StatusChange{}, ""), ErrNotFound)
+
+	events := repo.Events()
+	require.Len(t, events, 1)
+	assert.Equal(t, "dev_session.provisioning", events[0].Type)
+}
+
+func TestMemorySessionRepository_Update(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, ""))
+
+	// A change made by someone else since the worker loaded its copy is kept
+	worker := *session
+	session.ContainerName = "renamed"
+	require.NoError(t, repo.Save(ctx, session, ""))
+
+	change := StatusChange{Reason: "dev container installation started", Actor: models.ActorProvisioner}
+	require.NoError(t, repo.Update(ctx, &worker, models.StatusProvisioning, change, "", func(stored *models.Session) error {
+		stored.PreviewPath = "/preview"
+		return nil
+	}))
+	assert.Equal(t, models.StatusProvisioning, worker.Status)
+	assert.Equal(t, "renamed", worker.ContainerName)
+	assert.Equal(t, "/preview", worker.PreviewPath)
+
+	// An error from fn leaves the session as it was
+	abort := errors.New("abort")
+	err := repo.Update(ctx, &worker, models.StatusRunning, change, "", func(*models.Session) error { return abort })
+	assert.ErrorIs(t, err, abort)
+	stored, err := repo.Get(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusProvisioning, stored.Status)
+
+	assert.ErrorIs(t, repo.Update(ctx, session, models.StatusError, change, "", nil), ErrStaleStatus)
+	assert.ErrorIs(t, repo.Update(ctx, &worker, models.StatusStopped, change, "", nil), models.ErrInvalidTransition)
+
+	history, _, err := repo.StatusHistory(ctx, session.ID, NewPage(1, 10))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, models.ActorProvisioner, history[0].Actor)
+}
+
+func TestMemorySessionRepository_StatusHistory(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, ""))
+	other := newSession(2)
+	require.NoError(t, repo.Create(ctx, other, ""))
+
+	require.NoError(t, repo.Transition(ctx, session, models.StatusProvisioning, StatusChange{Reason: "installing", Actor: models.ActorProvisioner}, ""))
+	require.NoError(t, repo.Transition(ctx, other, models.StatusError, StatusChange{Reason: "broken", Actor: models.ActorReconciler}, ""))
+	require.NoError(t, repo.Transition(ctx, session, models.StatusRunning, StatusChange{Reason: "ready", Actor: models.ActorProvisioner}, ""))
+	// Staying in the same status is not a change
+	require.NoError(t, repo.Transition(ctx, session, models.StatusRunning, StatusChange{}, ""))
+
+	history, total, err := repo.StatusHistory(ctx, session.ID, NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, history, 2)
+	assert.Equal(t, models.StatusProvisioning, history[0].FromStatus)
+	assert.Equal(t, models.StatusRunning, history[0].ToStatus)
+	assert.Equal(t, "ready", history[0].Reason)
+	assert.Equal(t, models.StatusPending, history[1].FromStatus)
+	assert.Equal(t, models.ActorProvisioner, history[1].Actor)
+
+	history, total, err = repo.StatusHistory(ctx, session.ID, NewPage(2, 1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, history, 1)
+	assert.Equal(t, models.StatusProvisioning, history[0].ToStatus)
+}
+
+func TestMemorySessionRepository_Delete(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	session := newSession(1)
+	require.NoError(t, repo.Create(ctx, session, ""))
+	require.NoError(t, repo.Delete(ctx, session, "dev_session.deleted"))
+
+	_, err := repo.Get(ctx, session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.GetByProjectUUID(ctx, session.ProjectUUID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, repo.Save(ctx, session, ""), ErrNotFound)
+
+	// Like the partial unique indexes, deleted sessions do not hold on to
+	// their project UUID and token
+	require.NoError(t, repo.Create(ctx, newSession(1), ""))
+}
+
+func TestMemorySessionRepository_HistoryAndRestore(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	first := newSession(1)
+	require.NoError(t, repo.Create(ctx, first, ""))
+	require.NoError(t, repo.Delete(ctx, first, ""))
+
+	second := newSession(1)
+	second.TokenHash = models.HashToken("token-2")
+	second.UserID = 7
+	require.NoError(t, repo.Create(ctx, second, ""))
+	require.NoError(t, repo.Create(ctx, newSession(3), ""))
+
+	history, total, err := repo.History(ctx, "project-1", nil, NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	require.Len(t, history, 2)
+	assert.Equal(t, second.ID, history[0].ID, "newest first")
+	assert.Equal(t, first.ID, history[1].ID)
+	assert.True(t, history[1].DeletedAt.Valid)
+
+	owner := 7
+	history, total, err = repo.History(ctx, "project-1", &owner, NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, history, 1)
+	assert.Equal(t, second.ID, history[0].ID)
+
+	_, err = repo.Restore(ctx, second.ID, "")
+	assert.ErrorIs(t, err, ErrNotDeleted)
+	_, err = repo.Restore(ctx, 42, "")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = repo.Restore(ctx, first.ID, "")
+	assert.ErrorIs(t, err, ErrConflict, "the project has a live session")
+
+	require.NoError(t, repo.Delete(ctx, second, ""))
+	restored, err := repo.Restore(ctx, first.ID, "dev_session.restored")
+	require.NoError(t, err)
+	assert.False(t, restored.DeletedAt.Valid)
+
+	live, err := repo.GetByProjectUUID(ctx, "project-1")
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, live.ID)
+
+	events := repo.Events()
+	require.NotEmpty(t, events)
+	assert.Equal(t, "dev_session.restored", events[len(events)-1].Type)
+}
+
+func TestMemorySessionRepository_List(t *testing.T) {
+	repo := NewMemorySessionRepository()
+	ctx := context.Background()
+
+	for n := 1; n <= 5; n++ {
+		require.NoError(t, repo.Create(ctx, newSession(n), ""))
+	}
+	deleted, err := repo.Get(ctx, 5)
+	require.NoError(t, err)
+	require.NoError(t, repo.Delete(ctx, deleted, ""))
+	running, err := repo.Get(ctx, 4)
+	require.NoError(t, err)
+	require.NoError(t, repo.Transition(ctx, running, models.StatusProvisioning, StatusChange{}, ""))
+	require.NoError(t, repo.Transition(ctx, running, models.StatusRunning, StatusChange{}, ""))
+
+	sessions, total, err := repo.List(ctx, SessionFilter{}, NewPage(2, 3))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	require.Len(t, sessions, 1)
+	assert.Equal(t, uint(4), sessions[0].ID)
+
+	userID := 1
+	sessions, total, err = repo.List(ctx, SessionFilter{UserID: &userID}, NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, uint(2), sessions[0].ID)
+	assert.Equal(t, uint(4), sessions[1].ID)
+
+	sessions, total, err = repo.List(ctx, SessionFilter{UserID: &userID, Status: "running"}, NewPage(1, 10))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, uint(4), sessions[0].ID)
+
+	sessions, _, err = repo.List(ctx, SessionFilter{}, NewPage(9, 10))
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+}
+
+func TestNewPage(t *testing.T) {
+	assert.Equal(t, Page{Number: 1, Size: DefaultPageSize}, NewPage(0, 0))
+	assert.Equal(t, Page{Number: 3, Size: DefaultPageSize}, NewPage(3, MaxPageSize+1))
+	assert.Equal(t, Page{Number: 2, Size: 25}, NewPage(2, 25))
+
+	page := NewPage(3, 25)
+	assert.Equal(t, 50, page.Offset())
+	assert.Equal(t, int64(0), page.TotalPages(0))
+	assert.Equal(t, int64(2), page.TotalPages(26))
+}
+
+func TestTranslate(t *testing.T) {
+	assert.ErrorIs(t, translate(gorm.ErrRecordNotFound, "load"), ErrNotFound)
+	assert.ErrorIs(t, translate(fmt.Errorf("insert: %w", gorm.ErrDuplicatedKey), "create"), ErrConflict)
+
+	err := translate(errors.New("connection refused"), "save")
+	assert.EqualError(t, err, "failed to save session: connection refused")
+}
+
+func TestMemorySessionRepository_WithProjectLock(t *testing.T) {
+	repo := NewMemorySessionRepository()
+
+	held := make(chan struct{})
+	release := make(chan struct{})
+	go repo.WithProjectLock(context.Background(), "project-1", func(ctx context.Context) error {
+		close(held)
+		<-release
+		return nil
+	})
+	<-held
+
+	// Another project is not blocked
+	require.NoError(t, repo.WithProjectLock(context.Background(), "project-2", func(ctx context.Context) error { return nil }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := repo.WithProjectLock(ctx, "project-1", func(ctx context.Context) error {
+		t.Error("the lock must not be acquired while held")
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	assert.NoError(t, repo.WithProjectLock(context.Background(), "project-1", func(ctx context.Context) error { return nil }))
+}
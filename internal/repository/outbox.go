@@ -0,0 +1,31 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// ErrOutboxLocked is returned by WithRelayLock while another relay holds the lock
+var ErrOutboxLocked = errors.New("outbox is being relayed elsewhere")
+
+// OutboxRepository reads and updates the events waiting in the outbox. The
+// events themselves are written by events.Emitter in the transaction of the
+// change they describe.
+type OutboxRepository interface {
+	// WithRelayLock runs fn while holding the relay lock, so that only one
+	// relay across replicas publishes at a time. It returns ErrOutboxLocked
+	// without running fn when another relay holds the lock.
+	WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) error
+	// Pending returns up to limit undelivered events, oldest first
+	Pending(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	// MarkDelivered records that the broker confirmed the event at
+	MarkDelivered(ctx context.Context, id uint, at time.Time) error
+	// MarkFailed counts a failed attempt to publish the event
+	MarkFailed(ctx context.Context, id uint, reason string) error
+	// DeleteDelivered removes events delivered before cutoff and returns how
+	// many were removed
+	DeleteDelivered(ctx context.Context, cutoff time.Time) (int64, error)
+}
@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// MemoryOutboxRepository keeps outbox events in memory for tests
+type MemoryOutboxRepository struct {
+	mu     sync.Mutex
+	events []models.OutboxEvent
+	nextID uint
+
+	// relay is held by WithRelayLock
+	relay sync.Mutex
+}
+
+// NewMemoryOutboxRepository creates an empty in-memory outbox
+func NewMemoryOutboxRepository() *MemoryOutboxRepository {
+	return &MemoryOutboxRepository{}
+}
+
+// Add appends an event to the outbox, as events.Emitter does in Postgres
+func (r *MemoryOutboxRepository) Add(event models.OutboxEvent) *models.OutboxEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	event.ID = r.nextID
+	event.CreatedAt = time.Now()
+	r.events = append(r.events, event)
+	return &event
+}
+
+// Events returns a copy of every event in the outbox, oldest first
+func (r *MemoryOutboxRepository) Events() []models.OutboxEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.OutboxEvent(nil), r.events...)
+}
+
+// WithRelayLock runs fn unless another relay is running
+func (r *MemoryOutboxRepository) WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.relay.TryLock() {
+		return ErrOutboxLocked
+	}
+	defer r.relay.Unlock()
+
+	return fn(ctx)
+}
+
+// Pending returns up to limit undelivered events, oldest first
+func (r *MemoryOutboxRepository) Pending(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := []models.OutboxEvent{}
+	for _, event := range r.events {
+		if len(pending) == limit {
+			break
+		}
+		if event.DeliveredAt == nil {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+
+// MarkDelivered records that the broker confirmed the event at
+func (r *MemoryOutboxRepository) MarkDelivered(ctx context.Context, id uint, at time.Time) error {
+	r.update(id, func(event *models.OutboxEvent) { event.DeliveredAt = &at })
+	return nil
+}
+
+// MarkFailed counts a failed attempt to publish the event
+func (r *MemoryOutboxRepository) MarkFailed(ctx context.Context, id uint, reason string) error {
+	r.update(id, func(event *models.OutboxEvent) {
+		event.Attempts++
+		event.LastError = reason
+	})
+	return nil
+}
+
+// DeleteDelivered removes events delivered before cutoff
+func (r *MemoryOutboxRepository) DeleteDelivered(ctx context.Context, cutoff time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kept := r.events[:0]
+	for _, event := range r.events {
+		if event.DeliveredAt == nil || !event.DeliveredAt.Before(cutoff) {
+			kept = append(kept, event)
+		}
+	}
+	removed := int64(len(r.events) - len(kept))
+	r.events = kept
+	return removed, nil
+}
+
+// update applies fn to the event with the given ID, if there is one
+func (r *MemoryOutboxRepository) update(id uint, fn func(event *models.OutboxEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.events {
+		if r.events[i].ID == id {
+			fn(&r.events[i])
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+)
+
+// outboxLockNamespace is the first key of the outbox relay's advisory lock
+const outboxLockNamespace = 0x4f42 // "OB"
+
+// PostgresOutboxRepository reads and updates the outbox in Postgres through GORM
+type PostgresOutboxRepository struct {
+	db *gorm.DB
+}
+
+// NewPostgresOutboxRepository creates a repository on db
+func NewPostgresOutboxRepository(db *gorm.DB) *PostgresOutboxRepository {
+	return &PostgresOutboxRepository{db: db}
+}
+
+// WithRelayLock runs fn while holding a Postgres advisory lock. The lock is
+// held by a session rather than a transaction, so no transaction stays open
+// while fn waits for the broker.
+func (r *PostgresOutboxRepository) WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	// Advisory locks belong to a session, so lock and unlock on one
+	// connection while fn uses the rest of the pool
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open lock connection: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1, 0)`, outboxLockNamespace).Scan(&locked); err != nil {
+		return fmt.Errorf("failed to lock outbox: %w", err)
+	}
+	if !locked {
+		return ErrOutboxLocked
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, 0)`, outboxLockNamespace)
+		if err != nil {
+			// Never return a connection that may still hold the lock to the pool
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// Pending returns up to limit undelivered events, oldest first
+func (r *PostgresOutboxRepository) Pending(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	var pending []models.OutboxEvent
+	err := r.db.WithContext(ctx).
+		Where("delivered_at IS NULL").
+		Order("id").
+		Limit(limit).
+		Find(&pending).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load pending outbox events: %w", err)
+	}
+	return pending, nil
+}
+
+// MarkDelivered records that the broker confirmed the event at
+func (r *PostgresOutboxRepository) MarkDelivered(ctx context.Context, id uint, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).Update("delivered_at", at).Error
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox event delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed counts a failed attempt to publish the event
+func (r *PostgresOutboxRepository) MarkFailed(ctx context.Context, id uint, reason string) error {
+	updates := map[string]interface{}{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": reason,
+	}
+	if err := r.db.WithContext(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record outbox publish failure: %w", err)
+	}
+	return nil
+}
+
+// DeleteDelivered removes events delivered before cutoff
+func (r *PostgresOutboxRepository) DeleteDelivered(ctx context.Context, cutoff time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("delivered_at < ?", cutoff).Delete(&models.OutboxEvent{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to remove delivered outbox events: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
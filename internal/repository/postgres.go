@@ -0,0 +1,384 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// projectLockNamespace is the first key of the per-project advisory locks.
+// Two-key advisory locks do not collide with single-key ones such as the
+// migration lock.
+const projectLockNamespace = 0x5345 // "SE"
+
+// PostgresSessionRepository stores sessions in Postgres through GORM and
+// records lifecycle events in the outbox in the same transaction
+type PostgresSessionRepository struct {
+	db     *gorm.DB
+	events *events.Emitter
+}
+
+// NewPostgresSessionRepository creates a repository on db. Events are
+// recorded through emitter, which may be nil.
+func NewPostgresSessionRepository(db *gorm.DB, emitter *events.Emitter) *PostgresSessionRepository {
+	return &PostgresSessionRepository{db: db, events: emitter}
+}
+
+// Get returns the session with the given ID
+func (r *PostgresSessionRepository) Get(ctx context.Context, id uint) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.WithContext(ctx).First(&session, id).Error; err != nil {
+		return nil, translate(err, "load")
+	}
+	return &session, nil
+}
+
+// GetByProjectUUID returns the session of a project
+func (r *PostgresSessionRepository) GetByProjectUUID(ctx context.Context, projectUUID string) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.WithContext(ctx).Where("project_uuid = ?", projectUUID).First(&session).Error; err != nil {
+		return nil, translate(err, "load")
+	}
+	return &session, nil
+}
+
+// GetByToken returns the session authenticated by token, its current token
+// or the previous one within its overlap window
+func (r *PostgresSessionRepository) GetByToken(ctx context.Context, token string) (*models.Session, error) {
+	var session models.Session
+	hash := models.HashToken(token)
+	err := r.db.WithContext(ctx).
+		Where("token_hash = ? OR (previous_token_hash = ? AND previous_token_expires_at > ?)", hash, hash, time.Now()).
+		First(&session).Error
+	if err != nil {
+		return nil, translate(err, "load")
+	}
+	return &session, nil
+}
+
+// List returns a page of the sessions matching filter and the total number of matches
+func (r *PostgresSessionRepository) List(ctx context.Context, filter SessionFilter, page Page) ([]models.Session, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.Session{})
+	if filter.UserID != nil {
+		query = query.Where("user_id = ?", *filter.UserID)
+	}
+	if filter.ProjectID != nil {
+		query = query.Where("project_id = ?", *filter.ProjectID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translate(err, "count")
+	}
+
+	sessions := []models.Session{}
+	err := query.Order("id").Limit(page.Size).Offset(page.Offset()).Find(&sessions).Error
+	if err != nil {
+		return nil, 0, translate(err, "list")
+	}
+
+	return sessions, total, nil
+}
+
+// ListActive returns every active session that belongs to a project
+func (r *PostgresSessionRepository) ListActive(ctx context.Context) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := r.db.WithContext(ctx).Where("is_active = ? AND project_uuid <> ?", true, "").Order("id").Find(&sessions).Error
+	if err != nil {
+		return nil, translate(err, "list active")
+	}
+	return sessions, nil
+}
+
+// ListExpired returns up to limit active sessions that expired before cutoff
+func (r *PostgresSessionRepository) ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := r.db.WithContext(ctx).
+		Where("is_active = ? AND expires_at < ?", true, cutoff).
+		Order("expires_at").
+		Limit(limit).
+		Find(&sessions).Error
+	if err != nil {
+		return nil, translate(err, "list expired")
+	}
+	return sessions, nil
+}
+
+// ListIdle returns up to limit running sessions not accessed since cutoff
+func (r *PostgresSessionRepository) ListIdle(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := r.db.WithContext(ctx).
+		Where("is_active = ? AND status = ? AND project_uuid <> ?", true, models.StatusRunning, "").
+		Where("COALESCE(last_accessed_at, created_at) < ?", cutoff).
+		Order("COALESCE(last_accessed_at, created_at)").
+		Limit(limit).
+		Find(&sessions).Error
+	if err != nil {
+		return nil, translate(err, "list idle")
+	}
+	return sessions, nil
+}
+
+// History returns a page of a project's sessions, deleted ones included, newest first
+func (r *PostgresSessionRepository) History(ctx context.Context, projectUUID string, userID *int, page Page) ([]models.Session, int64, error) {
+	query := r.db.WithContext(ctx).Unscoped().Model(&models.Session{}).Where("project_uuid = ?", projectUUID)
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translate(err, "count")
+	}
+
+	sessions := []models.Session{}
+	err := query.Order("id DESC").Limit(page.Size).Offset(page.Offset()).Find(&sessions).Error
+	if err != nil {
+		return nil, 0, translate(err, "list")
+	}
+
+	return sessions, total, nil
+}
+
+// Create inserts a new session
+func (r *PostgresSessionRepository) Create(ctx context.Context, session *models.Session, eventType string) error {
+	return r.write(ctx, "create", session, eventType, func(tx *gorm.DB) error {
+		return tx.Create(session).Error
+	})
+}
+
+// Save writes every field of an existing session except its status
+func (r *PostgresSessionRepository) Save(ctx context.Context, session *models.Session, eventType string) error {
+	return r.write(ctx, "save", session, eventType, func(tx *gorm.DB) error {
+		return tx.Omit("status").Save(session).Error
+	})
+}
+
+// Transition moves a session to status and writes its other fields with it
+func (r *PostgresSessionRepository) Transition(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string) error {
+	previous := session.Status
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := changeStatus(tx, session, status, change); err != nil {
+			return err
+		}
+		if err := tx.Save(session).Error; err != nil {
+			return translate(err, "save")
+		}
+		if eventType == "" {
+			return nil
+		}
+		return r.events.Record(tx, eventType, session)
+	})
+	if err != nil {
+		session.Status = previous
+	}
+	return err
+}
+
+// Update moves a session to status and writes the stored session with fn applied
+func (r *PostgresSessionRepository) Update(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string, fn func(stored *models.Session) error) error {
+	var stored models.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&stored, session.ID).Error; err != nil {
+			return translate(err, "load")
+		}
+		if stored.Status != session.Status {
+			return fmt.Errorf("%w: session %d is %s, not %s", ErrStaleStatus, session.ID, stored.Status, session.Status)
+		}
+		if fn != nil {
+			if err := fn(&stored); err != nil {
+				return err
+			}
+		}
+		if err := changeStatus(tx, &stored, status, change); err != nil {
+			return err
+		}
+		if err := tx.Save(&stored).Error; err != nil {
+			return translate(err, "save")
+		}
+		if eventType == "" {
+			return nil
+		}
+		return r.events.Record(tx, eventType, &stored)
+	})
+	if err != nil {
+		return err
+	}
+
+	stored.Token = session.Token
+	*session = stored
+	return nil
+}
+
+// Touch records that the session was accessed at
+func (r *PostgresSessionRepository) Touch(ctx context.Context, id uint, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).UpdateColumn("last_accessed_at", at).Error
+	if err != nil {
+		return translate(err, "touch")
+	}
+	return nil
+}
+
+// StatusHistory returns a page of a session's status changes, newest first
+func (r *PostgresSessionRepository) StatusHistory(ctx context.Context, sessionID uint, page Page) ([]models.SessionStatusChange, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.SessionStatusChange{}).Where("session_id = ?", sessionID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translate(err, "count status history of")
+	}
+
+	changes := []models.SessionStatusChange{}
+	err := query.Order("id DESC").Limit(page.Size).Offset(page.Offset()).Find(&changes).Error
+	if err != nil {
+		return nil, 0, translate(err, "list status history of")
+	}
+
+	return changes, total, nil
+}
+
+// Delete soft-deletes a session
+func (r *PostgresSessionRepository) Delete(ctx context.Context, session *models.Session, eventType string) error {
+	return r.write(ctx, "delete", session, eventType, func(tx *gorm.DB) error {
+		return tx.Delete(session).Error
+	})
+}
+
+// Restore undeletes a soft-deleted session. It returns ErrConflict when the
+// project or token has been taken by another session in the meantime.
+func (r *PostgresSessionRepository) Restore(ctx context.Context, id uint, eventType string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&session, id).Error; err != nil {
+			return translate(err, "load")
+		}
+		if !session.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+
+		if err := tx.Unscoped().Model(&session).Update("deleted_at", nil).Error; err != nil {
+			return translate(err, "restore")
+		}
+		session.DeletedAt = gorm.DeletedAt{}
+
+		if eventType == "" {
+			return nil
+		}
+		return r.events.Record(tx, eventType, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Purge removes a session row permanently
+func (r *PostgresSessionRepository) Purge(ctx context.Context, session *models.Session, eventType string) error {
+	return r.write(ctx, "purge", session, eventType, func(tx *gorm.DB) error {
+		return tx.Unscoped().Delete(&models.Session{}, session.ID).Error
+	})
+}
+
+// WithProjectLock runs fn while holding a Postgres advisory lock on projectUUID
+func (r *PostgresSessionRepository) WithProjectLock(ctx context.Context, projectUUID string, fn func(ctx context.Context) error) error {
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	// Advisory locks belong to a session, so lock and unlock on one
+	// connection while fn uses the rest of the pool
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open lock connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1, hashtext($2))`, projectLockNamespace, projectUUID); err != nil {
+		return fmt.Errorf("failed to lock project: %w", err)
+	}
+	defer func() {
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1, hashtext($2))`, projectLockNamespace, projectUUID)
+		if err != nil {
+			// Never return a connection that may still hold the lock to the pool
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+	}()
+
+	return fn(ctx)
+}
+
+// changeStatus moves a session from session.Status to status within tx and
+// records the change in the status history. The session row is locked
+// until tx ends. It returns ErrStaleStatus if the stored status is no
+// longer session.Status, so concurrent changes cannot overwrite each other,
+// and models.ErrInvalidTransition if the move is not allowed. Staying in
+// the same status writes nothing.
+func changeStatus(tx *gorm.DB, session *models.Session, status models.SessionStatus, change StatusChange) error {
+	var stored models.Session
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status").First(&stored, session.ID).Error
+	if err != nil {
+		return translate(err, "load")
+	}
+	if stored.Status != session.Status {
+		return fmt.Errorf("%w: session %d is %s, not %s", ErrStaleStatus, session.ID, stored.Status, session.Status)
+	}
+	if stored.Status == status && !change.Repeat {
+		return nil
+	}
+	if err := models.CheckTransition(stored.Status, status); err != nil {
+		return err
+	}
+
+	if err := tx.Model(&stored).Update("status", status).Error; err != nil {
+		return translate(err, "update")
+	}
+	entry := models.SessionStatusChange{
+		SessionID:  session.ID,
+		FromStatus: stored.Status,
+		ToStatus:   status,
+		Reason:     change.Reason,
+		Actor:      change.Actor,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record session status change: %w", err)
+	}
+
+	session.Status = status
+	return nil
+}
+
+// write runs change and records eventType in one transaction
+func (r *PostgresSessionRepository) write(ctx context.Context, operation string, session *models.Session, eventType string, change func(tx *gorm.DB) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := change(tx); err != nil {
+			return translate(err, operation)
+		}
+		if eventType == "" {
+			return nil
+		}
+		return r.events.Record(tx, eventType, session)
+	})
+}
+
+// translate maps GORM errors onto the repository's errors
+func translate(err error, operation string) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrConflict
+	default:
+		return fmt.Errorf("failed to %s session: %w", operation, err)
+	}
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// Errors returned by PreviewLinkRepository
+var (
+	// ErrPreviewLinkNotFound is returned when no preview link matches
+	ErrPreviewLinkNotFound = errors.New("preview link not found")
+	// ErrPreviewLinkUsedUp is returned by Use when the link is revoked or
+	// has been opened as often as it may be
+	ErrPreviewLinkUsedUp = errors.New("preview link is used up")
+	// ErrPreviewLinkRevoked is returned by Revoke when the link is already
+	// revoked
+	ErrPreviewLinkRevoked = errors.New("preview link is already revoked")
+)
+
+// PreviewLinkRepository stores preview links. Revoked and expired links are
+// kept so that they stay on record.
+type PreviewLinkRepository interface {
+	// Get returns the preview link with the given ID
+	Get(ctx context.Context, id uint) (*models.PreviewLink, error)
+	// ListBySession returns a page of a session's preview links, newest
+	// first, and the total number of links of the session
+	ListBySession(ctx context.Context, sessionID uint, page Page) ([]models.PreviewLink, int64, error)
+	// Create inserts a new preview link and fills in its ID and timestamps
+	Create(ctx context.Context, link *models.PreviewLink) error
+	// Revoke marks the link revoked at, leaving its other fields alone so
+	// that concurrent uses are not lost. It returns ErrPreviewLinkRevoked if
+	// the link is already revoked.
+	Revoke(ctx context.Context, id uint, at time.Time) error
+	// Use counts one opening of the link at, atomically checking that it is
+	// not revoked and has uses left. It returns ErrPreviewLinkUsedUp otherwise.
+	Use(ctx context.Context, id uint, at time.Time) error
+}
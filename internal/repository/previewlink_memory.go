@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// MemoryPreviewLinkRepository keeps preview links in memory for tests and local runs
+type MemoryPreviewLinkRepository struct {
+	mu     sync.Mutex
+	links  map[uint]models.PreviewLink
+	nextID uint
+}
+
+// NewMemoryPreviewLinkRepository creates an empty in-memory repository
+func NewMemoryPreviewLinkRepository() *MemoryPreviewLinkRepository {
+	return &MemoryPreviewLinkRepository{links: map[uint]models.PreviewLink{}}
+}
+
+// Get returns the preview link with the given ID
+func (r *MemoryPreviewLinkRepository) Get(ctx context.Context, id uint) (*models.PreviewLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[id]
+	if !ok {
+		return nil, ErrPreviewLinkNotFound
+	}
+	return &link, nil
+}
+
+// ListBySession returns a page of a session's preview links, newest first,
+// and the total number of links of the session
+func (r *MemoryPreviewLinkRepository) ListBySession(ctx context.Context, sessionID uint, page Page) ([]models.PreviewLink, int64, error) {
+	r.mu.Lock()
+	links := []models.PreviewLink{}
+	for _, link := range r.links {
+		if link.SessionID == sessionID {
+			links = append(links, link)
+		}
+	}
+	r.mu.Unlock()
+
+	sort.Slice(links, func(i, j int) bool { return links[i].ID > links[j].ID })
+
+	total := int64(len(links))
+	start := min(page.Offset(), len(links))
+	end := min(start+page.Size, len(links))
+	return links[start:end], total, nil
+}
+
+// Create inserts a new preview link
+func (r *MemoryPreviewLinkRepository) Create(ctx context.Context, link *models.PreviewLink) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	now := time.Now()
+	link.ID = r.nextID
+	link.CreatedAt = now
+	link.UpdatedAt = now
+	r.links[link.ID] = *link
+	return nil
+}
+
+// Revoke marks the link revoked at
+func (r *MemoryPreviewLinkRepository) Revoke(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[id]
+	if !ok {
+		return ErrPreviewLinkNotFound
+	}
+	if link.RevokedAt != nil {
+		return ErrPreviewLinkRevoked
+	}
+	link.RevokedAt = &at
+	link.UpdatedAt = time.Now()
+	r.links[id] = link
+	return nil
+}
+
+// Use counts one opening of the link at
+func (r *MemoryPreviewLinkRepository) Use(ctx context.Context, id uint, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	link, ok := r.links[id]
+	if !ok {
+		return ErrPreviewLinkNotFound
+	}
+	if link.RevokedAt != nil || (link.MaxUses != nil && link.Uses >= *link.MaxUses) {
+		return ErrPreviewLinkUsedUp
+	}
+	link.Uses++
+	link.LastUsedAt = &at
+	r.links[id] = link
+	return nil
+}
@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"gorm.io/gorm"
+)
+
+// PostgresPreviewLinkRepository stores preview links in Postgres through GORM
+type PostgresPreviewLinkRepository struct {
+	db *gorm.DB
+}
+
+// NewPostgresPreviewLinkRepository creates a repository on db
+func NewPostgresPreviewLinkRepository(db *gorm.DB) *PostgresPreviewLinkRepository {
+	return &PostgresPreviewLinkRepository{db: db}
+}
+
+// Get returns the preview link with the given ID
+func (r *PostgresPreviewLinkRepository) Get(ctx context.Context, id uint) (*models.PreviewLink, error) {
+	var link models.PreviewLink
+	if err := r.db.WithContext(ctx).First(&link, id).Error; err != nil {
+		return nil, translatePreviewLink(err, "load")
+	}
+	return &link, nil
+}
+
+// ListBySession returns a page of a session's preview links, newest first,
+// and the total number of links of the session
+func (r *PostgresPreviewLinkRepository) ListBySession(ctx context.Context, sessionID uint, page Page) ([]models.PreviewLink, int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.PreviewLink{}).Where("session_id = ?", sessionID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, translatePreviewLink(err, "count")
+	}
+
+	links := []models.PreviewLink{}
+	err := query.Order("id DESC").Limit(page.Size).Offset(page.Offset()).Find(&links).Error
+	if err != nil {
+		return nil, 0, translatePreviewLink(err, "list")
+	}
+
+	return links, total, nil
+}
+
+// Create inserts a new preview link
+func (r *PostgresPreviewLinkRepository) Create(ctx context.Context, link *models.PreviewLink) error {
+	if err := r.db.WithContext(ctx).Create(link).Error; err != nil {
+		return translatePreviewLink(err, "create")
+	}
+	return nil
+}
+
+// Revoke marks the link revoked at. Only revoked_at is written, so a use
+// counted concurrently is kept.
+func (r *PostgresPreviewLinkRepository) Revoke(ctx context.Context, id uint, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.PreviewLink{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at)
+	if result.Error != nil {
+		return translatePreviewLink(result.Error, "revoke")
+	}
+	if result.RowsAffected == 0 {
+		return ErrPreviewLinkRevoked
+	}
+	return nil
+}
+
+// Use counts one opening of the link at. The check and the increment are a
+// single statement, so concurrent openings cannot exceed max_uses.
+func (r *PostgresPreviewLinkRepository) Use(ctx context.Context, id uint, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.PreviewLink{}).
+		Where("id = ? AND revoked_at IS NULL AND (max_uses IS NULL OR uses < max_uses)", id).
+		UpdateColumns(map[string]interface{}{
+			"uses":         gorm.Expr("uses + 1"),
+			"last_used_at": at,
+		})
+	if result.Error != nil {
+		return translatePreviewLink(result.Error, "use")
+	}
+	if result.RowsAffected == 0 {
+		return ErrPreviewLinkUsedUp
+	}
+	return nil
+}
+
+// translatePreviewLink maps GORM errors onto the repository's errors
+func translatePreviewLink(err error, operation string) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrPreviewLinkNotFound
+	}
+	return fmt.Errorf("failed to %s preview link: %w", operation, err)
+}
@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+const (
+	// DefaultPageSize is used when a page size is missing or out of range
+	DefaultPageSize = 10
+	// MaxPageSize is the largest page that can be requested
+	MaxPageSize = 100
+)
+
+// Errors returned by SessionRepository implementations in place of
+// driver-specific errors
+var (
+	// ErrNotFound is returned when no session matches
+	ErrNotFound = errors.New("session not found")
+	// ErrConflict is returned when a write would duplicate the project UUID or
+	// token hash of another session that is not deleted
+	ErrConflict = errors.New("session conflicts with an existing session")
+	// ErrNotDeleted is returned when restoring a session that is not deleted
+	ErrNotDeleted = errors.New("session is not deleted")
+	// ErrStaleStatus is returned when a session's stored status is no longer
+	// the status the caller read, because it was changed concurrently
+	ErrStaleStatus = errors.New("session status has changed")
+)
+
+// StatusChange describes why a session's status changes. It is recorded in
+// the session's status history.
+type StatusChange struct {
+	// Reason is a human-readable explanation of the change
+	Reason string
+	// Actor is who made the change, a models.Actor* constant or the caller's identity
+	Actor string
+	// Repeat records the change even when the status stays the same, as for
+	// a restart; otherwise such a change leaves no history entry
+	Repeat bool
+}
+
+// SessionFilter narrows a session listing. Nil and empty fields match every session.
+type SessionFilter struct {
+	UserID    *int
+	ProjectID *int
+	Status    models.SessionStatus
+}
+
+// Page selects a page of a listing. Number is 1-based.
+type Page struct {
+	Number int
+	Size   int
+}
+
+// NewPage returns the page with the given number and size, falling back to
+// the first page and DefaultPageSize for out-of-range values
+func NewPage(number, size int) Page {
+	if number < 1 {
+		number = 1
+	}
+	if size < 1 || size > MaxPageSize {
+		size = DefaultPageSize
+	}
+	return Page{Number: number, Size: size}
+}
+
+// Offset returns the number of results before the page
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// TotalPages returns the number of pages needed for total results
+func (p Page) TotalPages(total int64) int64 {
+	return (total + int64(p.Size) - 1) / int64(p.Size)
+}
+
+// SessionRepository stores sessions. Soft-deleted sessions are only
+// returned by History and Restore. Every write takes the type of the
+// lifecycle event to record with it; the event is stored atomically with
+// the change, and an empty type records none. A session's status only
+// changes through Transition, which enforces the state machine in
+// models.SessionStatus and records the change in the status history.
+type SessionRepository interface {
+	// Get returns the session with the given ID
+	Get(ctx context.Context, id uint) (*models.Session, error)
+	// GetByProjectUUID returns the session of a project
+	GetByProjectUUID(ctx context.Context, projectUUID string) (*models.Session, error)
+	// GetByToken returns the session authenticated by token: its current
+	// token, or the previous one until it expires
+	GetByToken(ctx context.Context, token string) (*models.Session, error)
+	// List returns a page of the sessions matching filter, ordered by ID,
+	// and the total number of matching sessions
+	List(ctx context.Context, filter SessionFilter, page Page) ([]models.Session, int64, error)
+	// ListActive returns every active session that belongs to a project,
+	// ordered by ID
+	ListActive(ctx context.Context) ([]models.Session, error)
+	// ListExpired returns up to limit active sessions whose ExpiresAt is
+	// before cutoff, the longest expired first
+	ListExpired(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error)
+	// ListIdle returns up to limit running sessions of projects that have not
+	// been accessed since cutoff, the longest idle first
+	ListIdle(ctx context.Context, cutoff time.Time, limit int) ([]models.Session, error)
+	// History returns a page of every session a project has had, deleted
+	// ones included, newest first, and the total number of sessions. A
+	// non-nil userID limits it to that user's sessions.
+	History(ctx context.Context, projectUUID string, userID *int, page Page) ([]models.Session, int64, error)
+	// Create inserts a new session and fills in its ID and timestamps
+	Create(ctx context.Context, session *models.Session, eventType string) error
+	// Save writes every field of an existing session except its status
+	Save(ctx context.Context, session *models.Session, eventType string) error
+	// Transition moves a session from its current status to status and
+	// writes its other fields with it. It returns ErrStaleStatus if the
+	// stored status is no longer session.Status and
+	// models.ErrInvalidTransition if the move is not allowed.
+	Transition(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string) error
+	// Update moves a session from session.Status to status like Transition,
+	// but writes the stored session with fn applied instead of the caller's
+	// copy, so fields changed concurrently by others are kept. fn runs while
+	// the session is locked and may be nil; an error from it aborts the
+	// change and is returned as is. On success session holds the result.
+	Update(ctx context.Context, session *models.Session, status models.SessionStatus, change StatusChange, eventType string, fn func(stored *models.Session) error) error
+	// Touch records that the session was accessed at. It is not a change
+	// of the session and leaves UpdatedAt alone.
+	Touch(ctx context.Context, id uint, at time.Time) error
+	// StatusHistory returns a page of a session's status changes, newest
+	// first, and the total number of changes
+	StatusHistory(ctx context.Context, sessionID uint, page Page) ([]models.SessionStatusChange, int64, error)
+	// Delete soft-deletes a session
+	Delete(ctx context.Context, session *models.Session, eventType string) error
+	// Restore undeletes a soft-deleted session and returns it
+	Restore(ctx context.Context, id uint, eventType string) (*models.Session, error)
+	// Purge removes a session row permanently
+	Purge(ctx context.Context, session *models.Session, eventType string) error
+	// WithProjectLock runs fn while holding an exclusive lock on projectUUID.
+	// The lock is shared by every replica using the same store, so callers
+	// for the same project run one after another.
+	WithProjectLock(ctx context.Context, projectUUID string, fn func(ctx context.Context) error) error
+}
@@ -0,0 +1,30 @@
+package sessions
+
+import (
+	"context"
+
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+)
+
+// actorKey is the context key of the actor making a change
+type actorKey struct{}
+
+// WithActor returns a context attributing the session changes made with it
+// to actor in the status history
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set on ctx by WithActor. Changes made without
+// one come from the HTTP API and are attributed to the authenticated caller
+// when there is one.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	if principal, ok := auth.FromContext(ctx); ok {
+		return principal.Actor()
+	}
+	return models.ActorAPI
+}
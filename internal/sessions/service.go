@@ -0,0 +1,593 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/provisioner"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/reaper"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+// Errors returned by Service. The HTTP handlers and the command consumer
+// translate them into responses.
+var (
+	// ErrNotFound is returned when no session matches
+	ErrNotFound = repository.ErrNotFound
+	// ErrExists is returned when the project already has a session
+	ErrExists = errors.New("session already exists for project")
+	// ErrRuntimeUnavailable is returned for dev container operations without a runtime
+	ErrRuntimeUnavailable = errors.New("kubernetes integration is not available")
+	// ErrNoDevContainer is returned for sessions that were created without a project UUID
+	ErrNoDevContainer = errors.New("session has no dev container")
+	// ErrInactive is returned for sessions that have been expired or deactivated
+	ErrInactive = errors.New("session is no longer active")
+	// ErrProvisioning is returned while a session's dev container is being installed
+	ErrProvisioning = errors.New("session is still being provisioned")
+	// ErrWaking is returned while a hibernated session is being started
+	ErrWaking = errors.New("session is waking up")
+	// ErrStopping is returned while a session's dev container is being stopped
+	ErrStopping = errors.New("session is stopping")
+	// ErrStopped is returned when restarting a session that is not running
+	ErrStopped = errors.New("session is stopped, start it instead")
+	// ErrNotStopped is returned when starting a session that is not stopped
+	ErrNotStopped = errors.New("session is not stopped")
+	// ErrHibernated is returned when starting a hibernated session, which is
+	// woken by opening it instead
+	ErrHibernated = errors.New("session is hibernated, open it to wake it")
+	// ErrUnavailable is returned when provisioning cannot be queued
+	ErrUnavailable = errors.New("session provisioning is unavailable, please retry later")
+	// ErrInvalidOverlap is returned for a token overlap that is negative or above MaxTokenOverlap
+	ErrInvalidOverlap = errors.New("token overlap must be between 0 and 24h")
+)
+
+// MaxTokenOverlap is the longest a rotated token may keep working
+const MaxTokenOverlap = 24 * time.Hour
+
+// Service implements the session operations shared by the HTTP API and the
+// RabbitMQ command consumer
+type Service struct {
+	log         *zap.Logger
+	repo        repository.SessionRepository
+	runtime     kubernetes.Runtime
+	provisioner *provisioner.Provisioner
+	reaper      *reaper.Reaper
+}
+
+// NewService creates a session service. Sessions and their lifecycle events
+// are stored in repo.
+// runtime may be nil, in which case sessions are recorded without dev containers.
+// Otherwise dev containers are installed in the background by prov.
+// Expired sessions are torn down through rp.
+func NewService(log *zap.Logger, repo repository.SessionRepository, runtime kubernetes.Runtime, prov *provisioner.Provisioner, rp *reaper.Reaper) *Service {
+	return &Service{
+		log:         log,
+		repo:        repo,
+		runtime:     runtime,
+		provisioner: prov,
+		reaper:      rp,
+	}
+}
+
+// HasRuntime reports whether dev containers are managed by the service
+func (s *Service) HasRuntime() bool {
+	return s.runtime != nil
+}
+
+// Get returns the session with the given ID. Sessions of other users are
+// not found for callers without the admin role.
+func (s *Service) Get(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return visible(ctx, session)
+}
+
+// GetByProjectUUID returns the session of a project, including expired
+// ones. Sessions of other users are not found for callers without the admin
+// role.
+func (s *Service) GetByProjectUUID(ctx context.Context, projectUUID string) (*models.Session, error) {
+	session, err := s.repo.GetByProjectUUID(ctx, projectUUID)
+	if err != nil {
+		return nil, err
+	}
+	return visible(ctx, session)
+}
+
+// Create records a new session and, when it belongs to a project and a
+// runtime is available, queues its dev container to be provisioned. The
+// token is always generated, expiry and namespace are filled in when not set,
+// and every session starts out pending. Only the token's hash is stored; the plaintext token is left
+// on session for the caller to hand out. It reports whether provisioning was
+// queued.
+func (s *Service) Create(ctx context.Context, session *models.Session) (bool, error) {
+	if session.ProjectUUID == "" {
+		return s.create(ctx, session)
+	}
+
+	var provision bool
+	err := s.repo.WithProjectLock(ctx, session.ProjectUUID, func(ctx context.Context) error {
+		var err error
+		provision, err = s.create(ctx, session)
+		return err
+	})
+	return provision, err
+}
+
+// GetOrCreate returns the session of template's project and reports whether
+// it already existed and was active. An expired or reaped session is
+// renewed, and a missing one is created from template. Calls for the same
+// project are serialized across replicas, so concurrent callers wait for the
+// first one to create the session and then return it. It also reports
+// whether provisioning was queued.
+func (s *Service) GetOrCreate(ctx context.Context, template *models.Session) (session *models.Session, existing bool, provisioning bool, err error) {
+	err = s.repo.WithProjectLock(ctx, template.ProjectUUID, func(ctx context.Context) error {
+		found, err := s.GetByProjectUUID(ctx, template.ProjectUUID)
+		switch {
+		case err == nil && found.IsActive && !found.IsExpired():
+			session, existing = found, true
+			return nil
+		case err == nil:
+			// The project UUID is unique, so an expired session is renewed in place
+			session = found
+			provisioning, err = s.renew(ctx, found, template.IPAddress, template.UserAgent)
+			return err
+		case errors.Is(err, ErrNotFound):
+			session = template
+			provisioning, err = s.create(ctx, template)
+			return err
+		default:
+			return err
+		}
+	})
+	if err != nil {
+		return nil, false, false, err
+	}
+	return session, existing, provisioning, nil
+}
+
+// visible returns ErrNotFound for a session the caller on ctx does not own.
+// Requests that were not authenticated, such as commands and background
+// jobs, see every session.
+func visible(ctx context.Context, session *models.Session) (*models.Session, error) {
+	if principal, ok := auth.FromContext(ctx); ok && !principal.Owns(session.UserID) {
+		return nil, ErrNotFound
+	}
+	return session, nil
+}
+
+// create records a new session. Callers hold the project lock.
+func (s *Service) create(ctx context.Context, session *models.Session) (bool, error) {
+	// Tokens are always generated here; one sent by the caller is ignored
+	session.SetToken(uuid.New().String())
+
+	// Set expiration time if not provided (1 year for always-on sessions)
+	if session.ExpiresAt.IsZero() {
+		session.ExpiresAt = time.Now().Add(models.AlwaysOnSessionDuration)
+	}
+
+	// Set namespace from project UUID if not set
+	if session.Namespace == "" && session.ProjectUUID != "" {
+		session.Namespace = session.ProjectUUID
+	}
+
+	// The status is owned by the state machine, never by the caller
+	session.Status = models.StatusPending
+
+	provision := s.runtime != nil && session.ProjectUUID != ""
+
+	if session.ProjectUUID != "" {
+		if _, err := s.GetByProjectUUID(ctx, session.ProjectUUID); err == nil {
+			return false, ErrExists
+		}
+	}
+
+	if err := s.repo.Create(ctx, session, events.SessionCreated); err != nil {
+		if errors.Is(err, repository.ErrConflict) {
+			return false, ErrExists
+		}
+		return false, err
+	}
+
+	s.log.Info("Session created",
+		zap.String("project_uuid", session.ProjectUUID),
+		zap.Uint("session_id", session.ID))
+
+	if provision {
+		if err := s.enqueue(ctx, session, s.repo.Purge); err != nil {
+			return false, err
+		}
+	}
+
+	return provision, nil
+}
+
+// renew replaces an expired or reaped session with a fresh one for the same
+// project. A session that has expired but not been reaped yet is torn down
+// first. It reports whether provisioning was queued. Callers hold the
+// project lock.
+func (s *Service) renew(ctx context.Context, session *models.Session, clientIP string, userAgent string) (bool, error) {
+	if session.IsActive {
+		if err := s.reaper.Expire(ctx, session); err != nil {
+			return false, fmt.Errorf("failed to expire session: %w", err)
+		}
+	}
+
+	s.log.Info("Renewing expired session",
+		zap.String("project_uuid", session.ProjectUUID),
+		zap.Uint("session_id", session.ID))
+
+	now := time.Now()
+	session.SetToken(uuid.New().String())
+	session.TokenRotatedAt = nil
+	session.ExpiresAt = now.Add(models.AlwaysOnSessionDuration)
+	session.LastAccessedAt = &now
+	session.IsActive = true
+	session.IPAddress = clientIP
+	session.UserAgent = userAgent
+	resetDevContainer(session)
+
+	change := repository.StatusChange{Reason: "expired session renewed", Actor: ActorFrom(ctx)}
+	if err := s.repo.Transition(ctx, session, models.StatusPending, change, events.SessionRenewed); err != nil {
+		return false, fmt.Errorf("failed to renew session: %w", err)
+	}
+
+	provision := s.runtime != nil
+	if provision {
+		if err := s.enqueue(ctx, session, s.repo.Purge); err != nil {
+			return false, err
+		}
+	}
+
+	return provision, nil
+}
+
+// Restore undeletes a deleted session. The dev container was removed with
+// the session, so a new one is provisioned when a runtime is available.
+// ErrExists is returned when the project has got a new session since.
+func (s *Service) Restore(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.repo.Restore(ctx, id, events.SessionRestored)
+	if errors.Is(err, repository.ErrConflict) {
+		return nil, ErrExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Session restored",
+		zap.String("project_uuid", session.ProjectUUID),
+		zap.Uint("session_id", session.ID))
+
+	if s.runtime == nil || session.ProjectUUID == "" {
+		return session, nil
+	}
+
+	resetDevContainer(session)
+	change := repository.StatusChange{Reason: "deleted session restored", Actor: ActorFrom(ctx)}
+	if err := s.repo.Transition(ctx, session, models.StatusPending, change, events.SessionPending); err != nil {
+		return nil, err
+	}
+	if err := s.enqueue(ctx, session, s.repo.Delete); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// resetDevContainer clears the endpoints of a session's old dev container
+// before it is moved back to pending for a new one
+func resetDevContainer(session *models.Session) {
+	session.ContainerName = ""
+	session.PreviewURL = ""
+	session.ChatURL = ""
+	session.VscodeURL = ""
+	session.InternalPreviewURL = ""
+	session.InternalChatURL = ""
+	session.InternalVscodeURL = ""
+}
+
+// enqueue hands a freshly saved pending session to the provisioning pool.
+// If the pool cannot take the job the session is removed again through
+// undo, with a session.deleted event, so the caller can retry, and
+// ErrUnavailable is returned.
+func (s *Service) enqueue(ctx context.Context, session *models.Session, undo func(context.Context, *models.Session, string) error) error {
+	err := s.provisioner.Enqueue(*session)
+	if err == nil {
+		return nil
+	}
+
+	s.log.Error("Failed to enqueue session provisioning",
+		zap.Uint("session_id", session.ID),
+		zap.Error(err))
+
+	if undoErr := undo(ctx, session, events.SessionDeleted); undoErr != nil {
+		s.log.Error("Failed to remove unprovisioned session", zap.Uint("session_id", session.ID), zap.Error(undoErr))
+	}
+
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+// Delete removes a session and its dev container. The session is moved
+// through stopping to stopped around the removal. It is left stopping and
+// kept if the dev container cannot be removed, so deleting it again retries
+// the removal instead of leaving a release behind that a restore would hit.
+func (s *Service) Delete(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete from Kubernetes if k8s client is available
+	if s.runtime != nil && session.ProjectUUID != "" {
+		change := repository.StatusChange{Reason: "session deleted", Actor: ActorFrom(ctx)}
+		if err := s.repo.Transition(ctx, session, models.StatusStopping, change, ""); err != nil {
+			return nil, err
+		}
+
+		err := s.runtime.DeleteDevContainer(ctx, session.ProjectUUID)
+		if err != nil && !errors.Is(err, kubernetes.ErrReleaseNotFound) {
+			s.log.Error("Failed to delete dev container from Kubernetes",
+				zap.Uint("session_id", session.ID),
+				zap.Error(err))
+			return nil, fmt.Errorf("failed to delete dev container: %w", err)
+		}
+
+		change.Reason = "dev container removed"
+		if err := s.repo.Transition(ctx, session, models.StatusStopped, change, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.Delete(ctx, session, events.SessionDeleted); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Stop scales the session's dev container to zero, keeping its workspace volume
+func (s *Service) Stop(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.lifecycleSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Status == models.StatusStopped {
+		return session, nil
+	}
+
+	change := repository.StatusChange{Reason: "stop requested", Actor: ActorFrom(ctx)}
+	if err := s.repo.Transition(ctx, session, models.StatusStopping, change, ""); err != nil {
+		return nil, err
+	}
+
+	if err := s.runtime.StopDevContainer(ctx, session.ProjectUUID); err != nil {
+		return nil, s.lifecycleFailed(ctx, session, "stop", err)
+	}
+
+	return session, s.updateLifecycleStatus(ctx, session, models.StatusStopped, nil, events.SessionStopped, "dev container scaled to zero")
+}
+
+// Start scales a stopped dev container back up and refreshes its endpoints.
+// Only stopped and errored sessions are started; a hibernated session is
+// left to the hibernator, which wakes it when it is opened.
+func (s *Service) Start(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.lifecycleSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checked before the cluster is touched
+	switch session.Status {
+	case models.StatusStopped, models.StatusError:
+	case models.StatusHibernated:
+		return nil, ErrHibernated
+	default:
+		return nil, ErrNotStopped
+	}
+
+	endpoints, err := s.runtime.StartDevContainer(ctx, session.ProjectUUID)
+	if err != nil {
+		return nil, s.lifecycleFailed(ctx, session, "start", err)
+	}
+
+	return session, s.updateLifecycleStatus(ctx, session, models.StatusRunning, endpoints, events.SessionRunning, "dev container started")
+}
+
+// Restart replaces the pod of a running dev container
+func (s *Service) Restart(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.lifecycleSession(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if session.Status == models.StatusStopped || session.Status == models.StatusHibernated {
+		return nil, ErrStopped
+	}
+
+	if err := s.runtime.RestartDevContainer(ctx, session.ProjectUUID); err != nil {
+		return nil, s.lifecycleFailed(ctx, session, "restart", err)
+	}
+
+	// The session stays running, so the restart is recorded as a repeated change
+	change := repository.StatusChange{Reason: "dev container restarted", Actor: ActorFrom(ctx), Repeat: true}
+	if err := s.repo.Transition(ctx, session, models.StatusRunning, change, events.SessionRestarted); err != nil {
+		s.log.Error("Failed to update session", zap.Uint("session_id", session.ID), zap.Error(err))
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// RotateToken gives a session a new access token and returns the session
+// with the plaintext token set. The replaced token keeps working for
+// overlap so clients can switch over; without overlap it stops working at
+// once. A token replaced by an earlier rotation always stops working.
+func (s *Service) RotateToken(ctx context.Context, id uint, overlap time.Duration) (*models.Session, error) {
+	if overlap < 0 || overlap > MaxTokenOverlap {
+		return nil, ErrInvalidOverlap
+	}
+
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !session.IsActive {
+		return nil, ErrInactive
+	}
+
+	now := time.Now()
+	previous := session.TokenHash
+	session.SetToken(uuid.New().String())
+	session.TokenRotatedAt = &now
+	if overlap > 0 {
+		until := now.Add(overlap)
+		session.PreviousTokenHash = previous
+		session.PreviousTokenExpiresAt = &until
+	}
+
+	if err := s.repo.Save(ctx, session, events.SessionTokenRotated); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Session token rotated",
+		zap.Uint("session_id", session.ID),
+		zap.Duration("overlap", overlap),
+		zap.String("actor", ActorFrom(ctx)))
+
+	return session, nil
+}
+
+// Update holds the session fields that can be changed after creation.
+// Nil fields are left as they are.
+type Update struct {
+	UserID    *int
+	ProjectID *int
+	ExpiresAt *time.Time
+}
+
+// Update changes a session's owner, project ID or expiry. When the owner or
+// project ID of a running session changes, the dev container is upgraded
+// with the new values; stopped and hibernated containers are left scaled down.
+func (s *Service) Update(ctx context.Context, id uint, update Update) (*models.Session, error) {
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !session.IsActive {
+		return nil, ErrInactive
+	}
+
+	upgrade := false
+	if update.UserID != nil && *update.UserID != session.UserID {
+		session.UserID = *update.UserID
+		upgrade = true
+	}
+	if update.ProjectID != nil && *update.ProjectID != session.ProjectID {
+		session.ProjectID = *update.ProjectID
+		upgrade = true
+	}
+	if update.ExpiresAt != nil {
+		session.ExpiresAt = *update.ExpiresAt
+	}
+
+	// The chart labels the release with the owner, so keep it in step. The
+	// chart deploys one replica, so a scaled-down container is not upgraded,
+	// which would start it again behind the session's back.
+	if upgrade && s.runtime != nil && session.ProjectUUID != "" && (session.Status == models.StatusRunning || session.Status == models.StatusWaking) {
+		if err := s.runtime.UpdateContainer(ctx, session.ProjectUUID, session.ProjectID, session.UserID); err != nil {
+			s.log.Error("Failed to update dev container",
+				zap.Uint("session_id", session.ID),
+				zap.Error(err))
+			return nil, fmt.Errorf("failed to update dev container: %w", err)
+		}
+	}
+
+	if err := s.repo.Save(ctx, session, events.SessionUpdated); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// lifecycleSession loads a session and checks that its dev container can be
+// stopped, started or restarted
+func (s *Service) lifecycleSession(ctx context.Context, id uint) (*models.Session, error) {
+	session, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case s.runtime == nil:
+		return nil, ErrRuntimeUnavailable
+	case session.ProjectUUID == "":
+		return nil, ErrNoDevContainer
+	case !session.IsActive:
+		return nil, ErrInactive
+	case session.Status == models.StatusPending || session.Status == models.StatusProvisioning:
+		return nil, ErrProvisioning
+	case session.Status == models.StatusWaking:
+		return nil, ErrWaking
+	case session.Status == models.StatusStopping:
+		return nil, ErrStopping
+	}
+
+	return session, nil
+}
+
+// lifecycleFailed handles a failed stop, start or restart. A start or
+// restart that fails after the pod was touched leaves the session in error,
+// and so does a failed stop, which has already moved it to stopping.
+func (s *Service) lifecycleFailed(ctx context.Context, session *models.Session, operation string, err error) error {
+	if errors.Is(err, kubernetes.ErrReleaseNotFound) && operation != "stop" {
+		return err
+	}
+
+	s.log.Error("Failed to "+operation+" dev container",
+		zap.Uint("session_id", session.ID),
+		zap.Error(err))
+
+	err = fmt.Errorf("failed to %s dev container: %w", operation, err)
+	change := repository.StatusChange{Reason: err.Error(), Actor: ActorFrom(ctx)}
+	if err := s.repo.Transition(ctx, session, models.StatusError, change, events.SessionError); err != nil {
+		s.log.Error("Failed to update session status", zap.Uint("session_id", session.ID), zap.Error(err))
+	}
+
+	return err
+}
+
+// updateLifecycleStatus moves the session to status for reason and records
+// the refreshed endpoints, if any, together with eventType
+func (s *Service) updateLifecycleStatus(ctx context.Context, session *models.Session, status models.SessionStatus, endpoints *kubernetes.ServiceEndpoints, eventType string, reason string) error {
+	if endpoints != nil {
+		session.IPAddress = endpoints.ClusterIP
+		session.PreviewURL = endpoints.PreviewURL
+		session.PreviewPath = endpoints.PreviewPath
+		session.ChatURL = endpoints.ChatURL
+		session.ChatPath = endpoints.ChatPath
+		session.VscodeURL = endpoints.VscodeURL
+		session.VscodePath = endpoints.VscodePath
+		session.InternalPreviewURL = endpoints.InternalPreviewURL
+		session.InternalChatURL = endpoints.InternalChatURL
+		session.InternalVscodeURL = endpoints.InternalVscodeURL
+	}
+
+	change := repository.StatusChange{Reason: reason, Actor: ActorFrom(ctx)}
+	if err := s.repo.Transition(ctx, session, status, change, eventType); err != nil {
+		s.log.Error("Failed to update session", zap.Uint("session_id", session.ID), zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/auth"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/events"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/kubernetes"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/models"
+	"github.com/villageFlower/paypilot_dev_session_service/internal/repository"
+	"go.uber.org/zap"
+)
+
+const testProjectUUID = "550e8400-e29b-41d4-a716-446655440000"
+
+func TestService_GetOrCreateConcurrent(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	const callers = 10
+	results := make([]*models.Session, callers)
+	existing := make([]bool, callers)
+
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, found, _, err := svc.GetOrCreate(context.Background(), &models.Session{
+				UserID:      i + 1,
+				ProjectUUID: testProjectUUID,
+				IsActive:    true,
+			})
+			assert.NoError(t, err)
+			results[i] = session
+			existing[i] = found
+		}()
+	}
+	wg.Wait()
+
+	created := 0
+	for i := range callers {
+		require.NotNil(t, results[i])
+		assert.Equal(t, results[0].ID, results[i].ID, "every caller must get the same session")
+		if !existing[i] {
+			created++
+		}
+	}
+	assert.Equal(t, 1, created)
+
+	recorded := repo.Events()
+	require.Len(t, recorded, 1)
+	assert.Equal(t, events.SessionCreated, recorded[0].Type)
+}
+
+func TestService_GetOrCreateExisting(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	first, existing, provisioning, err := svc.GetOrCreate(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	require.NoError(t, err)
+	assert.False(t, existing)
+	assert.False(t, provisioning, "nothing is provisioned without a runtime")
+
+	second, existing, _, err := svc.GetOrCreate(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	require.NoError(t, err)
+	assert.True(t, existing)
+	assert.Equal(t, first.ID, second.ID)
+	assert.NotEmpty(t, first.Token)
+	assert.Empty(t, second.Token, "the token is only shown when it is issued")
+	assert.Equal(t, first.TokenHash, second.TokenHash)
+}
+
+func TestService_CreateIgnoresCallerToken(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	session := &models.Session{Token: "chosen-by-caller"}
+	_, err := svc.Create(context.Background(), session)
+	require.NoError(t, err)
+	assert.NotEqual(t, "chosen-by-caller", session.Token)
+	assert.Equal(t, models.HashToken(session.Token), session.TokenHash)
+
+	_, err = repo.GetByToken(context.Background(), "chosen-by-caller")
+	assert.ErrorIs(t, err, repository.ErrNotFound)
+}
+
+func TestService_GetOrCreateRenewsExpired(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	expired := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("old"), ExpiresAt: time.Now().Add(-time.Hour)}
+	require.NoError(t, repo.Create(context.Background(), expired, ""))
+	expired.IsActive = false
+	require.NoError(t, repo.Save(context.Background(), expired, ""))
+
+	session, existing, _, err := svc.GetOrCreate(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	require.NoError(t, err)
+	assert.False(t, existing)
+	assert.Equal(t, expired.ID, session.ID)
+	assert.NotEmpty(t, session.Token)
+	assert.False(t, session.Authenticates("old", time.Now()))
+	assert.True(t, session.IsActive)
+	assert.False(t, session.IsExpired())
+}
+
+func TestService_Restore(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+	ctx := context.Background()
+
+	deleted := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("old")}
+	require.NoError(t, repo.Create(ctx, deleted, ""))
+	require.NoError(t, repo.Delete(ctx, deleted, ""))
+
+	// The project can get a new session after its previous one was deleted
+	current, existing, _, err := svc.GetOrCreate(ctx, &models.Session{ProjectUUID: testProjectUUID})
+	require.NoError(t, err)
+	assert.False(t, existing)
+
+	_, err = svc.Restore(ctx, deleted.ID)
+	assert.ErrorIs(t, err, ErrExists)
+
+	_, err = svc.Delete(ctx, current.ID)
+	require.NoError(t, err)
+
+	restored, err := svc.Restore(ctx, deleted.ID)
+	require.NoError(t, err)
+	assert.Equal(t, deleted.ID, restored.ID)
+
+	recorded := repo.Events()
+	assert.Equal(t, events.SessionRestored, recorded[len(recorded)-1].Type)
+}
+
+func TestService_CreateExisting(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	_, err := svc.Create(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	require.NoError(t, err)
+
+	_, err = svc.Create(context.Background(), &models.Session{ProjectUUID: testProjectUUID})
+	assert.ErrorIs(t, err, ErrExists)
+}
+
+func TestService_CreateIgnoresStatus(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+
+	session := &models.Session{ProjectUUID: testProjectUUID, Status: models.StatusRunning}
+	_, err := svc.Create(context.Background(), session)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusPending, session.Status)
+}
+
+// newRunningSession stores a running session whose dev container exists in runtime
+func newRunningSession(t *testing.T, repo *repository.MemorySessionRepository, runtime *kubernetes.FakeRuntime) *models.Session {
+	ctx := context.Background()
+	_, err := runtime.CreateDevContainer(ctx, testProjectUUID, 1, 1)
+	require.NoError(t, err)
+
+	session := &models.Session{ProjectUUID: testProjectUUID, TokenHash: models.HashToken("tok")}
+	require.NoError(t, repo.Create(ctx, session, ""))
+	require.NoError(t, repo.Transition(ctx, session, models.StatusProvisioning, repository.StatusChange{}, ""))
+	require.NoError(t, repo.Transition(ctx, session, models.StatusRunning, repository.StatusChange{}, ""))
+	return session
+}
+
+func TestService_StopAndStartRecordHistory(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+	ctx := WithActor(context.Background(), "user-7")
+
+	stopped, err := svc.Stop(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusStopped, stopped.Status)
+
+	started, err := svc.Start(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusRunning, started.Status)
+
+	history, _, err := repo.StatusHistory(ctx, session.ID, repository.NewPage(1, 3))
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []models.SessionStatus{models.StatusRunning, models.StatusStopped, models.StatusStopping},
+		[]models.SessionStatus{history[0].ToStatus, history[1].ToStatus, history[2].ToStatus})
+	for _, change := range history {
+		assert.Equal(t, "user-7", change.Actor)
+		assert.NotEmpty(t, change.Reason)
+	}
+}
+
+func TestService_RestartRecordsHistory(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+
+	restarted, err := svc.Restart(context.Background(), session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusRunning, restarted.Status)
+
+	history, _, err := repo.StatusHistory(context.Background(), session.ID, repository.NewPage(1, 1))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, models.StatusRunning, history[0].FromStatus)
+	assert.Equal(t, models.StatusRunning, history[0].ToStatus)
+	assert.Equal(t, "dev container restarted", history[0].Reason)
+}
+
+func TestService_UpdateLeavesStoppedContainerDown(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+	releaseName := kubernetes.ReleaseName(testProjectUUID)
+	owner := 9
+
+	_, err := svc.Stop(context.Background(), session.ID)
+	require.NoError(t, err)
+
+	updated, err := svc.Update(context.Background(), session.ID, Update{UserID: &owner})
+	require.NoError(t, err)
+	assert.Equal(t, owner, updated.UserID)
+	assert.Equal(t, models.StatusStopped, updated.Status)
+
+	rel, ok := runtime.Release(releaseName)
+	require.True(t, ok)
+	assert.Equal(t, 1, rel.Revision, "a stopped container is not upgraded")
+	assert.Equal(t, "stopped", rel.Status)
+}
+
+func TestService_FailedStopLeavesError(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+	runtime.ScaleErr = errors.New("scale failed")
+
+	_, err := svc.Stop(context.Background(), session.ID)
+	require.Error(t, err)
+
+	stored, err := repo.Get(context.Background(), session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusError, stored.Status)
+
+	history, _, err := repo.StatusHistory(context.Background(), session.ID, repository.NewPage(1, 1))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, models.StatusStopping, history[0].FromStatus)
+	assert.Contains(t, history[0].Reason, "scale failed")
+	assert.Equal(t, models.ActorAPI, history[0].Actor)
+}
+
+func TestService_FailedDeleteKeepsSession(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+	runtime.DeleteErr = errors.New("uninstall failed")
+
+	_, err := svc.Delete(context.Background(), session.ID)
+	require.Error(t, err)
+
+	stored, err := svc.Get(context.Background(), session.ID)
+	require.NoError(t, err, "the session is kept until its release is removed")
+	assert.Equal(t, models.StatusStopping, stored.Status)
+	_, ok := runtime.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.True(t, ok)
+
+	runtime.DeleteErr = nil
+	_, err = svc.Delete(context.Background(), session.ID)
+	require.NoError(t, err)
+	_, err = svc.Get(context.Background(), session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, ok = runtime.Release(kubernetes.ReleaseName(testProjectUUID))
+	assert.False(t, ok)
+}
+
+func TestService_CallerSeesOwnSessions(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	runtime := kubernetes.NewFakeRuntime()
+	svc := NewService(zap.NewNop(), repo, runtime, nil, nil)
+	session := newRunningSession(t, repo, runtime)
+	session.UserID = 1
+	require.NoError(t, repo.Save(context.Background(), session, ""))
+
+	other := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: 2})
+	_, err := svc.Get(other, session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = svc.Stop(other, session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = svc.Delete(other, session.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, _, _, err = svc.GetOrCreate(other, &models.Session{UserID: 2, ProjectUUID: testProjectUUID})
+	assert.ErrorIs(t, err, ErrExists, "another user's project is not taken over")
+
+	admin := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: 3, Admin: true})
+	_, err = svc.Get(admin, session.ID)
+	assert.NoError(t, err)
+
+	owner := auth.WithPrincipal(context.Background(), &auth.Principal{UserID: 1})
+	stopped, err := svc.Stop(owner, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.StatusStopped, stopped.Status)
+
+	history, _, err := repo.StatusHistory(owner, session.ID, repository.NewPage(1, 1))
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, "user:1", history[0].Actor)
+}
+
+func TestService_RotateToken(t *testing.T) {
+	repo := repository.NewMemorySessionRepository()
+	svc := NewService(zap.NewNop(), repo, nil, nil, nil)
+	ctx := context.Background()
+
+	created := &models.Session{ProjectUUID: testProjectUUID}
+	_, err := svc.Create(ctx, created)
+	require.NoError(t, err)
+	original := created.Token
+
+	_, err = svc.RotateToken(ctx, created.ID, MaxTokenOverlap+time.Second)
+	assert.ErrorIs(t, err, ErrInvalidOverlap)
+
+	rotated, err := svc.RotateToken(ctx, created.ID, time.Minute)
+	require.NoError(t, err)
+	assert.NotEmpty(t, rotated.Token)
+	assert.NotEqual(t, original, rotated.Token)
+	assert.NotNil(t, rotated.TokenRotatedAt)
+
+	for _, token := range []string{original, rotated.Token} {
+		found, err := repo.GetByToken(ctx, token)
+		require.NoError(t, err, "both tokens work during the overlap")
+		assert.Equal(t, created.ID, found.ID)
+		assert.Empty(t, found.Token, "stored sessions carry no plaintext token")
+	}
+
+	again, err := svc.RotateToken(ctx, created.ID, 0)
+	require.NoError(t, err)
+	for _, token := range []string{original, rotated.Token} {
+		_, err = repo.GetByToken(ctx, token)
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	}
+	_, err = repo.GetByToken(ctx, again.Token)
+	assert.NoError(t, err)
+
+	events := repo.Events()
+	assert.Equal(t, "session.token_rotated", events[len(events)-1].Type)
+}
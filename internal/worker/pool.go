@@ -0,0 +1,140 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrQueueFull is returned by Submit when the job queue has no free slots
+	ErrQueueFull = errors.New("worker queue is full")
+	// ErrPoolClosed is returned by Submit once Shutdown has been called
+	ErrPoolClosed = errors.New("worker pool is shut down")
+)
+
+// Job is a unit of background work. The context is cancelled when the pool
+// is forced to stop before the job finishes.
+type Job func(ctx context.Context)
+
+// Pool runs jobs on a fixed number of goroutines fed by a bounded queue
+type Pool struct {
+	log     *zap.Logger
+	workers int
+	jobs    chan Job
+	wg      sync.WaitGroup
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewPool creates a pool with the given concurrency and queue capacity
+func NewPool(log *zap.Logger, workers int, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pool{
+		log:     log,
+		workers: workers,
+		jobs:    make(chan Job, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Start launches the worker goroutines
+func (p *Pool) Start() {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.run(i)
+	}
+
+	p.log.Info("Worker pool started",
+		zap.Int("workers", p.workers),
+		zap.Int("queue_size", cap(p.jobs)))
+}
+
+// run executes jobs until the queue is closed and drained
+func (p *Pool) run(id int) {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		p.execute(id, job)
+	}
+}
+
+// execute runs a single job, keeping a panicking job from killing the worker
+func (p *Pool) execute(id int, job Job) {
+	defer func() {
+		if err := recover(); err != nil {
+			p.log.Error("Worker job panicked",
+				zap.Int("worker", id),
+				zap.Any("error", err))
+		}
+	}()
+
+	job(p.ctx)
+}
+
+// Submit queues a job without blocking. It returns ErrQueueFull when the
+// queue is at capacity and ErrPoolClosed after Shutdown.
+func (p *Pool) Submit(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown stops accepting jobs and waits for queued and running jobs to
+// finish. If ctx expires first, running jobs are cancelled and ctx.Err() is
+// returned once the workers have exited.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.jobs)
+	p.mu.Unlock()
+
+	p.log.Info("Draining worker pool", zap.Int("queued", len(p.jobs)))
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		p.log.Info("Worker pool drained")
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		p.log.Warn("Worker pool shutdown timed out, in-flight jobs were cancelled")
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPool_ShutdownDrainsQueuedJobs(t *testing.T) {
+	pool := NewPool(zap.NewNop(), 2, 10)
+	pool.Start()
+
+	var done atomic.Int32
+	for i := 0; i < 10; i++ {
+		require.NoError(t, pool.Submit(func(ctx context.Context) {
+			time.Sleep(5 * time.Millisecond)
+			done.Add(1)
+		}))
+	}
+
+	require.NoError(t, pool.Shutdown(context.Background()))
+	assert.Equal(t, int32(10), done.Load())
+}
+
+func TestPool_SubmitQueueFull(t *testing.T) {
+	pool := NewPool(zap.NewNop(), 1, 1)
+	pool.Start()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	require.NoError(t, pool.Submit(func(ctx context.Context) {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	// One job fills the queue, the next one is rejected
+	require.NoError(t, pool.Submit(func(ctx context.Context) {}))
+	assert.ErrorIs(t, pool.Submit(func(ctx context.Context) {}), ErrQueueFull)
+
+	close(release)
+	require.NoError(t, pool.Shutdown(context.Background()))
+}
+
+func TestPool_SubmitAfterShutdown(t *testing.T) {
+	pool := NewPool(zap.NewNop(), 1, 1)
+	pool.Start()
+	require.NoError(t, pool.Shutdown(context.Background()))
+
+	assert.ErrorIs(t, pool.Submit(func(ctx context.Context) {}), ErrPoolClosed)
+	assert.NoError(t, pool.Shutdown(context.Background()))
+}
+
+func TestPool_ShutdownTimeoutCancelsJobs(t *testing.T) {
+	pool := NewPool(zap.NewNop(), 1, 0)
+	pool.Start()
+
+	started := make(chan struct{})
+	var cancelled atomic.Bool
+	require.Eventually(t, func() bool {
+		return pool.Submit(func(ctx context.Context) {
+			close(started)
+			<-ctx.Done()
+			cancelled.Store(true)
+		}) == nil
+	}, time.Second, time.Millisecond)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, pool.Shutdown(ctx), context.DeadlineExceeded)
+	assert.True(t, cancelled.Load())
+}
+
+func TestPool_RecoversFromPanic(t *testing.T) {
+	pool := NewPool(zap.NewNop(), 1, 2)
+	pool.Start()
+
+	var ran atomic.Bool
+	require.NoError(t, pool.Submit(func(ctx context.Context) { panic("boom") }))
+	require.NoError(t, pool.Submit(func(ctx context.Context) { ran.Store(true) }))
+
+	require.NoError(t, pool.Shutdown(context.Background()))
+	assert.True(t, ran.Load())
+}
@@ -9,10 +9,18 @@ import (
 
 // Config holds all application configuration
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	RabbitMQ RabbitMQConfig `mapstructure:"rabbitmq"`
-	Log      LogConfig      `mapstructure:"log"`
+	Server       ServerConfig       `mapstructure:"server"`
+	Database     DatabaseConfig     `mapstructure:"database"`
+	RabbitMQ     RabbitMQConfig     `mapstructure:"rabbitmq"`
+	Kubernetes   KubernetesConfig   `mapstructure:"kubernetes"`
+	Provisioner  ProvisionerConfig  `mapstructure:"provisioner"`
+	Reconciler   ReconcilerConfig   `mapstructure:"reconciler"`
+	Reaper       ReaperConfig       `mapstructure:"reaper"`
+	Hibernation  HibernationConfig  `mapstructure:"hibernation"`
+	Outbox       OutboxConfig       `mapstructure:"outbox"`
+	Auth         AuthConfig         `mapstructure:"auth"`
+	PreviewLinks PreviewLinksConfig `mapstructure:"preview_links"`
+	Log          LogConfig          `mapstructure:"log"`
 }
 
 // ServerConfig holds server configuration
@@ -32,17 +40,130 @@ type DatabaseConfig struct {
 	MaxIdleConns    int    `mapstructure:"max_idle_conns"`
 	MaxOpenConns    int    `mapstructure:"max_open_conns"`
 	ConnMaxLifetime int    `mapstructure:"conn_max_lifetime"`
+	Migrations      string `mapstructure:"migrations"` // "auto", "verify" or "off"
 }
 
 // RabbitMQConfig holds RabbitMQ configuration
 type RabbitMQConfig struct {
-	Host     string `mapstructure:"host"`
-	Port     int    `mapstructure:"port"`
-	User     string `mapstructure:"user"`
-	Password string `mapstructure:"password"`
-	VHost    string `mapstructure:"vhost"`
-	Exchange string `mapstructure:"exchange"`
-	Queue    string `mapstructure:"queue"`
+	Broker   string              `mapstructure:"broker"` // "rabbitmq" or "memory" for an in-process bus
+	Host     string              `mapstructure:"host"`
+	Port     int                 `mapstructure:"port"`
+	User     string              `mapstructure:"user"`
+	Password string              `mapstructure:"password"`
+	VHost    string              `mapstructure:"vhost"`
+	Exchange string              `mapstructure:"exchange"`
+	Queue    string              `mapstructure:"queue"`
+	Commands string              `mapstructure:"commands"` // Routing key pattern of command messages bound to the queue
+	Retry    RabbitMQRetryConfig `mapstructure:"retry"`
+	Ledger   LedgerConfig        `mapstructure:"ledger"`
+}
+
+// LedgerConfig holds the processed-message ledger that deduplicates consumed commands
+type LedgerConfig struct {
+	Retention    int `mapstructure:"retention"`     // Seconds a handled message is remembered
+	ClaimTimeout int `mapstructure:"claim_timeout"` // Seconds before an unfinished claim is taken over by a redelivery
+}
+
+// RabbitMQRetryConfig holds retry and dead-lettering of messages whose handler fails
+type RabbitMQRetryConfig struct {
+	MaxAttempts  int `mapstructure:"max_attempts"`  // Handler attempts before a message is dead-lettered
+	InitialDelay int `mapstructure:"initial_delay"` // Seconds before the first retry, doubled for each further retry
+	MaxDelay     int `mapstructure:"max_delay"`     // Upper bound of the retry delay in seconds
+}
+
+// KubernetesConfig holds dev container runtime configuration
+type KubernetesConfig struct {
+	Runtime    string        `mapstructure:"runtime"`    // helm or fake
+	ChartPath  string        `mapstructure:"chart_path"` // Path to the dev-session-template chart
+	Kubeconfig string        `mapstructure:"kubeconfig"` // Empty uses in-cluster config or $KUBECONFIG
+	Timeout    int           `mapstructure:"timeout"`    // Helm install/upgrade timeout in seconds
+	Ingress    IngressConfig `mapstructure:"ingress"`
+}
+
+// IngressConfig holds the per-session Ingress rendered into the dev container chart
+type IngressConfig struct {
+	Enabled      bool              `mapstructure:"enabled"`
+	ClassName    string            `mapstructure:"class_name"`
+	HostTemplate string            `mapstructure:"host_template"` // {uuid} is replaced by the project UUID
+	TLS          IngressTLSConfig  `mapstructure:"tls"`
+	Auth         IngressAuthConfig `mapstructure:"auth"`
+}
+
+// IngressAuthConfig holds forward authentication of session Ingresses
+// through the /api/v1/auth/verify endpoint
+type IngressAuthConfig struct {
+	Enabled      bool   `mapstructure:"enabled"`
+	URL          string `mapstructure:"url"`           // Verify endpoint as reached by ingress-nginx
+	CookieName   string `mapstructure:"cookie_name"`   // Cookie the session token is kept in
+	CookieSecure bool   `mapstructure:"cookie_secure"` // Only send the cookie over HTTPS
+}
+
+// IngressTLSConfig holds TLS settings for session Ingresses
+type IngressTLSConfig struct {
+	Enabled       bool   `mapstructure:"enabled"`
+	SecretName    string `mapstructure:"secret_name"`    // Certificate secret in each project namespace
+	ClusterIssuer string `mapstructure:"cluster_issuer"` // cert-manager issuer that creates the secret, optional
+}
+
+// ProvisionerConfig holds background dev container provisioning configuration
+type ProvisionerConfig struct {
+	Workers         int `mapstructure:"workers"`          // Concurrent provisioning jobs
+	QueueSize       int `mapstructure:"queue_size"`       // Jobs waiting for a worker before requests are rejected
+	ShutdownTimeout int `mapstructure:"shutdown_timeout"` // Seconds to drain queued jobs on shutdown
+}
+
+// ReconcilerConfig holds configuration for reconciling sessions with Helm releases
+type ReconcilerConfig struct {
+	Enabled        bool   `mapstructure:"enabled"`
+	Interval       int    `mapstructure:"interval"`        // Seconds between runs
+	DryRun         bool   `mapstructure:"dry_run"`         // Report findings without changing anything
+	MissingRelease string `mapstructure:"missing_release"` // mark or reinstall
+	OrphanRelease  string `mapstructure:"orphan_release"`  // report, uninstall or adopt
+	StaleAfter     int    `mapstructure:"stale_after"`     // Seconds before a pending, provisioning, waking or stopping session is reconciled
+}
+
+// ReaperConfig holds configuration for tearing down expired sessions
+type ReaperConfig struct {
+	Enabled     bool `mapstructure:"enabled"`
+	Interval    int  `mapstructure:"interval"`     // Seconds between runs
+	GracePeriod int  `mapstructure:"grace_period"` // Seconds past expires_at before a session is reaped
+	BatchSize   int  `mapstructure:"batch_size"`   // Maximum sessions reaped per run
+}
+
+// HibernationConfig holds configuration for scaling idle dev containers to zero
+type HibernationConfig struct {
+	Enabled     bool `mapstructure:"enabled"`
+	Interval    int  `mapstructure:"interval"`     // Seconds between runs
+	IdleTimeout int  `mapstructure:"idle_timeout"` // Seconds without activity before a session is hibernated
+	BatchSize   int  `mapstructure:"batch_size"`   // Maximum sessions hibernated per run
+}
+
+// OutboxConfig holds configuration for relaying recorded session events to RabbitMQ
+type OutboxConfig struct {
+	Interval  int `mapstructure:"interval"`   // Seconds between relay runs
+	BatchSize int `mapstructure:"batch_size"` // Maximum events published per run
+	Retention int `mapstructure:"retention"`  // Seconds delivered events are kept before removal
+}
+
+// AuthConfig holds JWT authentication of the sessions API
+type AuthConfig struct {
+	Enabled     bool   `mapstructure:"enabled"`
+	HS256Secret string `mapstructure:"hs256_secret"`  // Shared secret of HS256 tokens, empty to reject them
+	JWKSFile    string `mapstructure:"jwks_file"`     // JWKS file with the RS256 public keys
+	JWKSURL     string `mapstructure:"jwks_url"`      // JWKS endpoint with the RS256 public keys
+	JWKSRefresh int    `mapstructure:"jwks_refresh"`  // Seconds between fetches of jwks_url
+	Issuer      string `mapstructure:"issuer"`        // Required iss claim, empty to accept any
+	Audience    string `mapstructure:"audience"`      // Required aud claim, empty to accept any
+	UserIDClaim string `mapstructure:"user_id_claim"` // Claim holding the numeric user ID
+	RolesClaim  string `mapstructure:"roles_claim"`   // Claim holding the list of roles
+	AdminRole   string `mapstructure:"admin_role"`    // Role allowed to see every session and use the admin routes
+}
+
+// PreviewLinksConfig holds shareable preview links
+type PreviewLinksConfig struct {
+	Secret     string `mapstructure:"secret"`      // HMAC key signing the links, empty disables them
+	DefaultTTL int    `mapstructure:"default_ttl"` // Seconds a link is valid when no lifetime is requested
+	MaxTTL     int    `mapstructure:"max_ttl"`     // Longest lifetime in seconds a link may be given
 }
 
 // LogConfig holds logging configuration
@@ -91,6 +212,14 @@ func (c *DatabaseConfig) GetDSN() string {
 		c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode)
 }
 
+// CommandRoutingKey returns the routing key pattern that binds commands to the queue
+func (c *RabbitMQConfig) CommandRoutingKey() string {
+	if c.Commands == "" {
+		return "dev_session.command.#"
+	}
+	return c.Commands
+}
+
 // GetRabbitMQURL returns RabbitMQ connection URL
 func (c *RabbitMQConfig) GetRabbitMQURL() string {
 	return fmt.Sprintf("amqp://%s:%s@%s:%d%s",